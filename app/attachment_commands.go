@@ -0,0 +1,267 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"squirrel/data"
+	"squirrel/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrAttachmentCorrupted = errors.New("attachment contents don't match their checksum")
+
+// AttachCommand encrypts a file and keeps it with an entry.
+func AttachCommand(p types.Printer, s data.Store, e types.Encryptor, d types.Decryptor) Command {
+	return func(args ...string) {
+		if len(args) != 2 {
+			p("{red}Wrong arguments{/red}\nattach command examples:\n\tattach 12 ~/.ssh/id_ed25519\n")
+			return
+		}
+
+		ent, title, attachments, ok := loadAttachments(p, s, d, args[0])
+		if !ok {
+			return
+		}
+
+		path := args[1]
+		info, err := os.Stat(path)
+		if err != nil {
+			p("{red}Can't attach {0}! {1}{/red}\n", path, err)
+			return
+		}
+		if info.IsDir() {
+			p("{red}Can't attach {0}, it is a directory.{/red}\n", path)
+			return
+		}
+		if info.Size() > data.MaxAttachmentSize {
+			p("{red}Can't attach {0}, it is {1} and attachments can be at most {2}.{/red}\n", path, formatSize(info.Size()), formatSize(data.MaxAttachmentSize))
+			return
+		}
+
+		name := filepath.Base(path)
+		if _, taken := findAttachment(attachments, name); taken {
+			p("{red}'{0}' already has an attachment named {1}.{/red}\n", title, name)
+			return
+		}
+
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			p("{red}Can't attach {0}! {1}{/red}\n", path, err)
+			return
+		}
+
+		attachment, err := storeAttachment(s, e, name, contents)
+		if err != nil {
+			p("{red}Storing the attachment failed!{/red} {0}\n", err)
+			return
+		}
+
+		ent.Attachments = append(ent.Attachments[:len(ent.Attachments):len(ent.Attachments)], attachment)
+		ent.Modified = time.Now()
+		if err := s.Update(ent.Id, ent); err != nil {
+			p("{red}Updating entity failed!{/red} {0}\n", err)
+			return
+		}
+
+		p("{green}Attached {0} ({1}) to '{2}'.{/green}\n", name, formatSize(attachment.Size), title)
+	}
+}
+
+// AttachmentsCommand lists the attachments of an entry.
+func AttachmentsCommand(p types.Printer, s data.Store, d types.Decryptor) Command {
+	return func(args ...string) {
+		if len(args) != 1 {
+			p("{red}Wrong arguments{/red}\nattachments command examples:\n\tattachments 12\n")
+			return
+		}
+
+		_, title, attachments, ok := loadAttachments(p, s, d, args[0])
+		if !ok {
+			return
+		}
+
+		if len(attachments) == 0 {
+			p("'{0}' has no attachments.\n", title)
+			return
+		}
+
+		p("Attachments of '{0}':\n", title)
+		for i, attachment := range attachments {
+			p("{0}. {1} \t{gray}{2}{/gray}\n", i+1, attachment.Name, formatSize(attachment.Size))
+		}
+	}
+}
+
+// ExtractCommand decrypts an attachment into a file, checking that it is the file
+// that was attached.
+func ExtractCommand(p types.Printer, s data.Store, d types.Decryptor) Command {
+	return func(args ...string) {
+		if len(args) != 2 && len(args) != 3 {
+			p("{red}Wrong arguments{/red}\nextract command examples:\n\textract 12 id_ed25519 ~/.ssh/\n\textract 12 1 key.pem\n\textract 12 license.txt\n")
+			return
+		}
+
+		_, title, attachments, ok := loadAttachments(p, s, d, args[0])
+		if !ok {
+			return
+		}
+
+		i, found := findAttachment(attachments, args[1])
+		if !found {
+			p("{red}'{0}' has no attachment {1}.{/red} Type {green}attachments {2}{/green} to list them.\n", title, args[1], args[0])
+			return
+		}
+		attachment := attachments[i]
+
+		dest := "."
+		if len(args) == 3 {
+			dest = args[2]
+		}
+		if info, err := os.Stat(dest); err == nil && info.IsDir() {
+			// The name comes from the vault, so it must not lead out of dest
+			dest = filepath.Join(dest, filepath.Base(attachment.Name))
+		}
+		if _, err := os.Stat(dest); err == nil && !GetYesNoInput(p, fmt.Sprintf("Overwrite %v", dest)) {
+			p("Extracting canceled!\n")
+			return
+		}
+
+		contents, err := readAttachment(s, d, attachment)
+		if err != nil {
+			p("{red}Reading attachment {0} failed! {1}{/red}\n", attachment.Name, err)
+			return
+		}
+
+		if err := os.WriteFile(dest, contents, 0600); err != nil {
+			p("{red}Writing {0} failed! {1}{/red}\n", dest, err)
+			return
+		}
+
+		p("{green}Extracted {0} to {1}.{/green}\n", attachment.Name, dest)
+	}
+}
+
+// loadAttachments loads the entry with the given id and returns it as stored,
+// with its title and its attachments decrypted. It reports errors itself.
+func loadAttachments(p types.Printer, s data.Store, d types.Decryptor, idArg string) (data.Entry, string, []data.Attachment, bool) {
+	id, err := strconv.ParseInt(idArg, 10, 64)
+	if err != nil {
+		p("{red}Bad ID! {0}{/red}\n", err)
+		return data.Entry{}, "", nil, false
+	}
+
+	ent, err := s.Load(id)
+	if err != nil {
+		p("{red}Loading entry with ID {0} failed! {1}{/red}\n", id, err)
+		return data.Entry{}, "", nil, false
+	}
+
+	title, err := d(ent.Title)
+	if err == nil {
+		var attachments []data.Attachment
+		attachments, err = mapAttachments(ent.Attachments, d)
+		if err == nil {
+			return ent, title, attachments, true
+		}
+	}
+
+	p("{red}Decrypting entry with ID {0} failed! {1}{/red}\n", id, err)
+	return data.Entry{}, "", nil, false
+}
+
+// storeAttachment encrypts contents into the store and returns the encrypted
+// attachment that refers to them.
+func storeAttachment(s data.Store, e types.Encryptor, name string, contents []byte) (data.Attachment, error) {
+	encrypted, err := e(string(contents))
+	if err != nil {
+		return data.Attachment{}, err
+	}
+
+	file, err := s.SaveAttachment(encrypted)
+	if err != nil {
+		return data.Attachment{}, err
+	}
+
+	sum := sha256.Sum256(contents)
+	attachment := data.Attachment{File: file, Size: int64(len(contents))}
+	if attachment.Name, err = e(name); err != nil {
+		return data.Attachment{}, err
+	}
+	if attachment.Sum, err = e(hex.EncodeToString(sum[:])); err != nil {
+		return data.Attachment{}, err
+	}
+	return attachment, nil
+}
+
+// readAttachment decrypts the contents of a decrypted attachment and checks them
+// against its size and checksum.
+func readAttachment(s data.Store, d types.Decryptor, attachment data.Attachment) ([]byte, error) {
+	encrypted, err := s.LoadAttachment(attachment.File)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := d(encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := []byte(decrypted)
+	sum := sha256.Sum256(contents)
+	if int64(len(contents)) != attachment.Size || hex.EncodeToString(sum[:]) != attachment.Sum {
+		return nil, ErrAttachmentCorrupted
+	}
+	return contents, nil
+}
+
+func findAttachment(attachments []data.Attachment, nameOrNumber string) (int, bool) {
+	return findNamed(attachments, func(attachment data.Attachment) string { return attachment.Name }, nameOrNumber)
+}
+
+// mapAttachments returns a copy of attachments with every name and checksum
+// passed through f.
+func mapAttachments(attachments []data.Attachment, f func(string) (string, error)) ([]data.Attachment, error) {
+	if attachments == nil {
+		return nil, nil
+	}
+
+	mapped := make([]data.Attachment, len(attachments))
+	for i, attachment := range attachments {
+		name, err := f(attachment.Name)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := f(attachment.Sum)
+		if err != nil {
+			return nil, err
+		}
+		mapped[i] = data.Attachment{Name: name, File: attachment.File, Size: attachment.Size, Sum: sum}
+	}
+	return mapped, nil
+}
+
+func attachmentsSummary(entry data.Entry) string {
+	var names []string
+	for _, attachment := range entry.Attachments {
+		names = append(names, attachment.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// formatSize returns a size in bytes in the largest unit it has one of.
+func formatSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
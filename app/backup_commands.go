@@ -0,0 +1,87 @@
+package app
+
+import (
+	"fmt"
+	"squirrel/data"
+	"squirrel/types"
+	"strconv"
+)
+
+func BackupsCommand(p types.Printer, v *data.Vault) Command {
+	return func(args ...string) {
+		snapshots, err := data.ListBackups(v)
+		if err != nil {
+			p("{red}Error in listing backups!{/red}: {0}\n", err)
+			return
+		}
+
+		p("There are {0} backups.\n", len(snapshots))
+
+		// The newest first, numbered for restore
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			snapshot := snapshots[i]
+			p("{0}. {1} \t{gray}{2}, {3} bytes{/gray}\n", len(snapshots)-i, snapshot.Time.Local().Format("2006-01-02 15:04:05"), snapshot.Name, snapshot.Size)
+		}
+	}
+}
+
+func BackupCommand(p types.Printer, backup func() (string, error)) Command {
+	return func(args ...string) {
+		name, err := backup()
+		if err != nil {
+			p("{red}Backing up the vault failed!{/red} {0}\n", err)
+			return
+		}
+
+		p("{green}The vault is backed up in snapshot {0}.{/green}\n", name)
+	}
+}
+
+func RestoreCommand(p types.Printer, v *data.Vault, keep func() int, restore func(name string) error) Command {
+	return func(args ...string) {
+		if len(args) != 1 {
+			p("{red}Which snapshot?{/red}\nrestore command examples:\n\trestore 1\n\trestore 20240131-093000.000\n")
+			return
+		}
+
+		name, err := snapshotName(v, args[0])
+		if err != nil {
+			p("{red}{0}{/red}\n", err)
+			return
+		}
+
+		if !GetYesNoInput(p, fmt.Sprintf("Replace the vault with snapshot %v", name)) {
+			return
+		}
+
+		if err := restore(name); err != nil {
+			p("{red}Restoring snapshot {0} failed!{/red} {1}\n", name, err)
+			return
+		}
+
+		if keep() > 0 {
+			p("{green}Snapshot {0} is restored. The vault before it is kept as a backup.{/green}\n", name)
+		} else {
+			p("{green}Snapshot {0} is restored.{/green} Backups are turned off, the vault before it was not kept.\n", name)
+		}
+	}
+}
+
+// snapshotName returns the name of the snapshot numbered as in the backups listing,
+// or arg itself when it is no such number.
+func snapshotName(v *data.Vault, arg string) (string, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil {
+		return arg, nil
+	}
+
+	snapshots, err := data.ListBackups(v)
+	if err != nil {
+		return "", err
+	}
+	if n < 1 || n > len(snapshots) {
+		return "", fmt.Errorf("there is no backup %d, type backups to list them", n)
+	}
+
+	return snapshots[len(snapshots)-n].Name, nil
+}
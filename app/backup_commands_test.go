@@ -0,0 +1,45 @@
+package app
+
+import (
+	"squirrel/data"
+	"strings"
+	"testing"
+)
+
+func TestBackupsAndSnapshotNumbers(t *testing.T) {
+	v, err := data.OpenVault(t.TempDir())
+	if err != nil {
+		t.Fatalf("OpenVault failed: %v", err)
+	}
+
+	var names []string
+	for _, entry := range testEntries {
+		if err := data.SaveEntry(v, entry); err != nil {
+			t.Fatalf("SaveEntry failed: %v", err)
+		}
+		name, err := data.Backup(v, data.DefaultKeepBackups)
+		if err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+		names = append(names, name)
+	}
+
+	var out recorder
+	BackupsCommand(out.print, v)()
+	if !strings.Contains(out.String(), "There are 3 backups.") || !strings.Contains(out.String(), "1. ") {
+		t.Errorf("Unexpected backups listing:\n%v", out.String())
+	}
+	if strings.Index(out.String(), names[2]) > strings.Index(out.String(), names[0]) {
+		t.Errorf("Expected the newest backup first:\n%v", out.String())
+	}
+
+	for arg, expected := range map[string]string{"1": names[2], "3": names[0], names[1]: names[1]} {
+		if name, err := snapshotName(v, arg); err != nil || name != expected {
+			t.Errorf("Expected %v to name %v, but got %v (%v)", arg, expected, name, err)
+		}
+	}
+
+	if _, err := snapshotName(v, "4"); err == nil {
+		t.Error("Expected an error for a backup number that is not listed")
+	}
+}
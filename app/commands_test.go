@@ -0,0 +1,55 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"squirrel/data"
+	"strings"
+	"testing"
+)
+
+// recorder is a Printer that keeps what was printed, with color tags left in.
+type recorder struct {
+	strings.Builder
+}
+
+func (r *recorder) print(template string, values ...interface{}) {
+	for i, value := range values {
+		template = strings.ReplaceAll(template, fmt.Sprintf("{%d}", i), fmt.Sprint(value))
+	}
+	r.WriteString(template)
+}
+
+// Entries in test stores are "encrypted" by prefixing them.
+const testCipherPrefix = "enc:"
+
+func testEncryptor(value string) (string, error) {
+	return testCipherPrefix + value, nil
+}
+
+func testDecryptor(value string) (string, error) {
+	if !strings.HasPrefix(value, testCipherPrefix) {
+		return "", errors.New("not encrypted")
+	}
+	return strings.TrimPrefix(value, testCipherPrefix), nil
+}
+
+// testStore returns a memory store with the given entries encrypted.
+func testStore(t *testing.T, entries ...data.Entry) *data.MemoryStore {
+	s := data.NewMemoryStore()
+	for _, entry := range entries {
+		if err := encryptEntry(&entry, testEncryptor, t.Logf); err != nil {
+			t.Fatalf("Encrypting entry %v failed: %v", entry.Id, err)
+		}
+		if err := s.Save(entry); err != nil {
+			t.Fatalf("Saving entry %v failed: %v", entry.Id, err)
+		}
+	}
+	return s
+}
+
+var testEntries = []data.Entry{
+	{Id: 1, Title: "gmail", Username: "bob", Password: "secret1"},
+	{Id: 2, Title: "aws", Username: "alice", Password: "secret2", Notes: "root account"},
+	{Id: 3, Title: "work mail", Username: "carol", Password: "secret3"},
+}
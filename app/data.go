@@ -1,32 +1,65 @@
 package app
 
 import (
+	"fmt"
 	"os"
 	d "squirrel/data"
 	l "squirrel/log"
+	"squirrel/types"
 )
 
-func ReadState() d.State {
-	if d.HasDataFile() {
-		count, err := d.CountEntries()
-		if err != nil {
-			l.Println("{red}Error in reading data file!{/red}{0}", err)
-			l.Println("Run using --fix-data-file")
-			os.Exit(3)
-		}
+func ReadState(s d.Store) d.State {
+	count, err := s.Count()
+	if err != nil {
+		l.Println("{red}Error in reading data file!{/red}{0}", err)
+		l.Println("Run using --fix-data-file")
+		os.Exit(3)
+	}
 
-		lastId, err := d.GetLargestId()
-		if err != nil {
-			l.Println("{red}Error in reading data file!{/red}{0}", err)
-			l.Println("Run using --fix-data-file")
-			os.Exit(3)
-		}
+	lastId, err := s.LargestId()
+	if err != nil {
+		l.Println("{red}Error in reading data file!{/red}{0}", err)
+		l.Println("Run using --fix-data-file")
+		os.Exit(3)
+	}
 
-		return d.State{
-			Count:  count,
-			LastId: lastId,
-		}
+	return d.State{
+		Count:  count,
+		LastId: lastId,
 	}
+}
+
+// ReEncrypt decrypts every entry with dec and encrypts it again with enc. The
+// contents of attachments are encrypted again into new files.
+func ReEncrypt(v *d.Vault, dec types.Decryptor, enc types.Encryptor, p types.Printer) error {
+	s := d.NewFileStore(v)
+	return d.RewriteEntries(v, func(ent *d.Entry) error {
+		if err := decrypt(ent, dec); err != nil {
+			return err
+		}
+		for i, attachment := range ent.Attachments {
+			contents, err := readAttachment(s, dec, attachment)
+			if err != nil {
+				return fmt.Errorf("attachment %v: %w", attachment.Name, err)
+			}
+			encrypted, err := enc(string(contents))
+			if err != nil {
+				return err
+			}
+			if ent.Attachments[i].File, err = s.SaveAttachment(encrypted); err != nil {
+				return err
+			}
+		}
+		return encryptEntry(ent, enc, p)
+	})
+}
 
-	return d.State{}
+// EncryptTitles encrypts the titles of a vault written when titles were stored in
+// plain text. It does nothing once the titles are encrypted.
+func EncryptTitles(v *d.Vault, enc types.Encryptor) error {
+	return d.MigrateFeature(v, d.FlagEncryptedTitles, func(ent *d.Entry) error {
+		var err error
+		ent.Title, err = enc(ent.Title)
+		return err
+	})
 }
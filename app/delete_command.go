@@ -5,11 +5,12 @@ import (
 	"squirrel/data"
 	"squirrel/types"
 	"strconv"
+	"time"
 )
 
 var ()
 
-func DeleteCommand(p types.Printer) Command {
+func DeleteCommand(p types.Printer, s data.Store, d types.Decryptor) Command {
 	return func(args ...string) {
 		var id int64
 		if len(args) > 0 {
@@ -25,26 +26,31 @@ func DeleteCommand(p types.Printer) Command {
 			id = readId(p)
 		}
 
-		ent, deleted, err := delete(id, p)
+		ent, deleted, err := delete(s, id, p, d)
 		if err != nil {
 			p("{red}Loading or deleting entry with ID {0} failed! {1}{/red}\n", id, err)
 			return
 		}
 
 		if deleted {
-			p("{green}Entry '{0}' deleted. {/red}\n", ent.Title)
+			p("{green}Entry '{0}' moved to the trash.{/green} Type {green}undelete {1}{/green} to bring it back.\n", ent.Title, id)
 		}
 	}
 }
 
-func delete(id int64, p types.Printer) (data.Entry, bool, error) {
-	ent, err := data.LoadEntry(id)
+func delete(s data.Store, id int64, p types.Printer, d types.Decryptor) (data.Entry, bool, error) {
+	ent, err := s.Load(id)
 	if err != nil {
 		return data.Entry{}, false, err
 	}
 
-	if GetYesNoInput(p, fmt.Sprintf("Delete entry '%v'", ent.Title)) {
-		err := data.DeleteEntryInMemory(id)
+	ent.Title, err = d(ent.Title)
+	if err != nil {
+		return data.Entry{}, false, err
+	}
+
+	if GetYesNoInput(p, fmt.Sprintf("Move entry '%v' to the trash", ent.Title)) {
+		err := s.Trash(id, time.Now())
 		if err != nil {
 			return data.Entry{}, false, err
 		}
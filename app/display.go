@@ -5,6 +5,7 @@ import (
 	"squirrel/data"
 	"squirrel/types"
 	"strings"
+	"time"
 )
 
 func display(ent data.Entry, p types.Printer) {
@@ -12,17 +13,55 @@ func display(ent data.Entry, p types.Printer) {
 }
 
 func format(entry data.Entry) string {
-	fields := []struct {
+	type field struct {
 		name  string
 		value string
-	}{
+	}
+
+	entryType := ""
+	if entry.Type != data.Login {
+		entryType = entry.Type.String()
+	}
+
+	password := entry.Password
+	if password != "" {
+		password = "{gray}{bgWhite}" + password + "{/gray}{/bgWhite}"
+	}
+
+	fields := []field{
 		{"ID", fmt.Sprintf("%d", entry.Id)},
+		{"Type", entryType},
 		{"Title", entry.Title},
-		{"Username", entry.Username},
-		{"Password", "{gray}{bgWhite}" + entry.Password + "{/gray}{/bgWhite}"},
-		{"Address", entry.Address},
+		{coreLabel(entry.Type, coreUsername), entry.Username},
+		{coreLabel(entry.Type, corePassword), password},
+		{coreLabel(entry.Type, coreAddress), entry.Address},
 		{"Notes", entry.Notes},
 	}
+	for _, custom := range entry.Fields {
+		name, value := custom.Name, custom.Value
+		typed, ok := typeFieldNamed(entry.Type, custom.Name)
+		if ok {
+			name = typed.name
+		}
+		if ok && typed.show != nil && value != "" {
+			value = typed.show(value)
+		} else if custom.Secret && value != "" {
+			value = maskedValue
+		}
+		fields = append(fields, field{name, value})
+	}
+	fields = append(fields, []field{
+		{"One-time codes", otpSummary(entry)},
+		{"Attachments", attachmentsSummary(entry)},
+		{"Folder", entry.Folder},
+		{"Tags", tagsOf(entry)},
+		{"Expires", expirySummary(entry, time.Now())},
+		{"History", historySummary(entry)},
+		{"Created", formatTime(entry.Created)},
+		{"Modified", formatTime(entry.Modified)},
+		{"Last used", formatTime(entry.Accessed)},
+		{"Password changed", formatTime(entry.PasswordChanged)},
+	}...)
 
 	maxFieldLength := 0
 	for _, field := range fields {
@@ -50,3 +89,29 @@ func format(entry data.Entry) string {
 
 	return result.String()
 }
+
+// formatTime returns t in local time, or nothing when it is not known.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func tagsOf(entry data.Entry) string {
+	if len(entry.Tags) == 0 {
+		return ""
+	}
+	return formatTags(entry.Tags)
+}
+
+func historySummary(entry data.Entry) string {
+	switch len(entry.History) {
+	case 0:
+		return ""
+	case 1:
+		return "1 previous password"
+	default:
+		return fmt.Sprintf("%d previous passwords", len(entry.History))
+	}
+}
@@ -4,11 +4,12 @@ import (
 	"squirrel/data"
 	"squirrel/types"
 	"strconv"
+	"time"
 )
 
 var ()
 
-func EditCommand(p types.Printer, e types.Encryptor, d types.Decryptor) Command {
+func EditCommand(p types.Printer, s data.Store, e types.Encryptor, d types.Decryptor) Command {
 	return func(args ...string) {
 		var id int64
 		if len(args) > 0 {
@@ -24,7 +25,7 @@ func EditCommand(p types.Printer, e types.Encryptor, d types.Decryptor) Command
 			id = readId(p)
 		}
 
-		ent, err := data.LoadEntry(id)
+		ent, err := s.Load(id)
 		if err != nil {
 			p("{red}Loading entry with ID {0} failed! {1}{/red}\n", id, err)
 			return
@@ -42,34 +43,36 @@ func EditCommand(p types.Printer, e types.Encryptor, d types.Decryptor) Command
 			newTitle = ent.Title
 		}
 
-		if GetYesNoInput(p, "Update password") {
-			var confirmNewPassword string
+		newPassword = ent.Password
+		newUsername = ent.Username
+		newAddress = ent.Address
 
-			for {
-				ReadSecret("New password", "", true, p, &newPassword)
-				ReadSecret("Verify password", "", true, p, &confirmNewPassword)
-
-				if newPassword != confirmNewPassword {
-					p("{red}Password does not match!{/red}\n")
-				} else {
-					break
+		if ent.Type != data.Login {
+			editTypeFields(p, &ent)
+			newPassword, newUsername, newAddress = ent.Password, ent.Username, ent.Address
+		} else {
+			if GetYesNoInput(p, "Update password") {
+				var confirmNewPassword string
+
+				for {
+					ReadSecret("New password", "", true, p, &newPassword)
+					ReadSecret("Verify password", "", true, p, &confirmNewPassword)
+
+					if newPassword != confirmNewPassword {
+						p("{red}Password does not match!{/red}\n")
+					} else {
+						break
+					}
 				}
 			}
 
-		} else {
-			newPassword = ent.Password
-		}
-
-		if GetYesNoInput(p, "Update username") {
-			ReadInput("New title", "", false, p, &newUsername)
-		} else {
-			newUsername = ent.Username
-		}
+			if GetYesNoInput(p, "Update username") {
+				ReadInput("New title", "", false, p, &newUsername)
+			}
 
-		if GetYesNoInput(p, "Update address") {
-			ReadInput("New address", "", false, p, &newAddress)
-		} else {
-			newAddress = ent.Address
+			if GetYesNoInput(p, "Update address") {
+				ReadInput("New address", "", false, p, &newAddress)
+			}
 		}
 
 		if GetYesNoInput(p, "Update notes") {
@@ -78,11 +81,25 @@ func EditCommand(p types.Printer, e types.Encryptor, d types.Decryptor) Command
 			newNotes = ent.Notes
 		}
 
+		if GetYesNoInput(p, "Update OTP secret") {
+			ent.OTP = readOTP(p, "New OTP secret")
+		}
+
+		if GetYesNoInput(p, "Update expiry") {
+			readExpiry(p, &ent, "New expiry")
+		}
+
+		if GetYesNoInput(p, "Update custom fields") {
+			ent.Fields = editFields(p, ent.Type, ent.Fields)
+		}
+
 		ent.Title = newTitle
 		ent.Username = newUsername
 		ent.Address = newAddress
 		ent.Notes = newNotes
-		ent.Password = newPassword
+		now := time.Now()
+		changePassword(&ent, newPassword, now)
+		ent.Modified = now
 
 		p("{magenta}Will update to:{/magenta}\n")
 		display(ent, p)
@@ -94,7 +111,7 @@ func EditCommand(p types.Printer, e types.Encryptor, d types.Decryptor) Command
 				return
 			}
 
-			err = data.UpdateEntry(ent.Id, ent)
+			err = s.Update(ent.Id, ent)
 			if err != nil {
 				p("{red}Updating entity failed!{/red} {0}", err)
 				return
@@ -0,0 +1,343 @@
+package app
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"squirrel/data"
+	"squirrel/types"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// typeField is a field an entry type asks for. Fields with a core name are kept
+// in that fixed field of the entry, the others as custom fields.
+type typeField struct {
+	name      string
+	core      string
+	secret    bool
+	mandatory bool
+	// multiline values are pasted and end with an empty line
+	multiline bool
+	check     func(string) error
+	// show renders the value for display, instead of masking secret values
+	show func(string) string
+	// expires returns when the entry expires given the value, for fields that
+	// tell, so the entry shows up in expiring
+	expires func(string) time.Time
+	// formerly is the name older entries keep the field under
+	formerly string
+}
+
+// Names of the fixed fields a typeField can be kept in.
+const (
+	coreUsername = "Username"
+	corePassword = "Password"
+	coreAddress  = "Address"
+)
+
+// typeFields are the fields each entry type asks for besides title and notes.
+// Logins keep the original prompts.
+var typeFields = map[data.EntryType][]typeField{
+	data.Card: {
+		{name: "Cardholder", mandatory: true},
+		{name: "Card number", secret: true, mandatory: true, check: checkCardNumber, show: lastDigits},
+		{name: "Card expiry", formerly: "Expires", mandatory: true, check: checkExpiry, expires: cardExpires},
+		{name: "CVV", secret: true, check: checkDigits(3, 4)},
+		{name: "PIN", secret: true, check: checkDigits(4, 12)},
+	},
+	data.Identity: {
+		{name: "Full name", mandatory: true},
+		{name: "Email", check: checkEmail},
+		{name: "Phone"},
+		{name: "Address", core: coreAddress},
+		{name: "Birthday", check: checkDate},
+	},
+	data.SSHKey: {
+		{name: "User", core: coreUsername},
+		{name: "Host", core: coreAddress},
+		{name: "Private key", secret: true, mandatory: true, multiline: true, check: checkPrivateKey, show: lineCount},
+		{name: "Public key", check: checkPublicKey, show: fingerprint},
+		{name: "Passphrase", core: corePassword, secret: true},
+	},
+	data.APIToken: {
+		{name: "Token", core: corePassword, secret: true, mandatory: true},
+		{name: "Account", core: coreUsername},
+		{name: "Endpoint", core: coreAddress},
+		{name: "Scopes"},
+		{name: "Token expiry", formerly: "Expires", check: checkDate, expires: dateExpires},
+	},
+}
+
+// readType asks for the type of a new entry, a login when the answer is empty.
+func readType(p types.Printer) data.EntryType {
+	var names []string
+	for _, entryType := range data.EntryTypes() {
+		names = append(names, entryType.String())
+	}
+
+	for {
+		var input string
+		ReadInput("Type", strings.Join(names, ", ")+"; login when empty", false, p, &input)
+		if strings.TrimSpace(input) == "" {
+			return data.Login
+		}
+
+		entryType, err := data.EntryTypeFromString(strings.TrimSpace(input))
+		if err == nil {
+			return entryType
+		}
+		p("{red}{0}{/red}\n", err)
+	}
+}
+
+// readTypeFields asks for the fields of the type of ent and sets them.
+func readTypeFields(p types.Printer, ent *data.Entry) {
+	for _, field := range typeFields[ent.Type] {
+		setTypeField(ent, field, readTypeField(p, field, field.name))
+	}
+}
+
+// editTypeFields asks which fields of the type of ent to update.
+func editTypeFields(p types.Printer, ent *data.Entry) {
+	for _, field := range typeFields[ent.Type] {
+		if GetYesNoInput(p, fmt.Sprintf("Update %v", lowerName(field.name))) {
+			setTypeField(ent, field, readTypeField(p, field, "New "+lowerName(field.name)))
+		}
+	}
+}
+
+// readTypeField asks for the value of field until it passes its check.
+func readTypeField(p types.Printer, field typeField, prompt string) string {
+	desc := ""
+	if !field.mandatory {
+		desc = "optional"
+	}
+
+	for {
+		var value string
+		switch {
+		case field.multiline:
+			value = readLines(p, prompt, field.mandatory)
+		case field.secret:
+			ReadSecret(prompt, desc, field.mandatory, p, &value)
+		default:
+			ReadInput(prompt, desc, field.mandatory, p, &value)
+		}
+
+		if value == "" || field.check == nil {
+			return value
+		}
+		if err := field.check(value); err != nil {
+			p("{red}Invalid {0}: {1}{/red}\n", lowerName(field.name), err)
+			continue
+		}
+		return value
+	}
+}
+
+// readLines reads lines until an empty one, or the file named on a line that
+// starts with @.
+func readLines(p types.Printer, name string, mandatory bool) string {
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		p("{0}{1}:\n", name, descriptionOfField("paste it and end with an empty line, or give its file as @path"))
+
+		var lines []string
+		for scanner.Scan() && scanner.Text() != "" {
+			lines = append(lines, scanner.Text())
+			// A file name is a single line
+			if len(lines) == 1 && strings.HasPrefix(lines[0], "@") {
+				break
+			}
+		}
+
+		value := strings.Join(lines, "\n")
+		if len(lines) == 1 && strings.HasPrefix(value, "@") {
+			contents, err := os.ReadFile(strings.TrimPrefix(value, "@"))
+			if err != nil {
+				p("{red}Reading {0} failed! {1}{/red}\n", value, err)
+				continue
+			}
+			value = strings.TrimRight(string(contents), "\n")
+		}
+
+		if mandatory && strings.TrimSpace(value) == "" {
+			p("{red}The {0} field is mandatory and cannot be empty!{/red}\n", name)
+			continue
+		}
+		return value
+	}
+}
+
+// lowerName returns name for use in a sentence, leaving abbreviations like CVV.
+func lowerName(name string) string {
+	if name == strings.ToUpper(name) {
+		return name
+	}
+	return strings.ToLower(name)
+}
+
+func setTypeField(ent *data.Entry, field typeField, value string) {
+	switch field.core {
+	case coreUsername:
+		ent.Username = value
+	case corePassword:
+		changePassword(ent, value, time.Now())
+	case coreAddress:
+		ent.Address = value
+	default:
+		setCustomField(ent, field, value)
+		if field.expires != nil && value != "" {
+			ent.Expires = field.expires(value)
+		}
+	}
+}
+
+func setCustomField(ent *data.Entry, field typeField, value string) {
+	for i := range ent.Fields {
+		if field.named(ent.Fields[i].Name) {
+			ent.Fields[i].Name = field.name
+			ent.Fields[i].Value = value
+			return
+		}
+	}
+	if value != "" {
+		ent.Fields = append(ent.Fields, data.CustomField{Name: field.name, Value: value, Secret: field.secret})
+	}
+}
+
+// named reports whether the field is called name, now or formerly.
+func (field typeField) named(name string) bool {
+	return name == field.name || field.formerly != "" && name == field.formerly
+}
+
+// coreLabel returns what the type of ent calls one of the fixed fields.
+func coreLabel(entryType data.EntryType, core string) string {
+	for _, field := range typeFields[entryType] {
+		if field.core == core {
+			return field.name
+		}
+	}
+	return core
+}
+
+// typeFieldNamed returns the field of the type called name, if there is one.
+func typeFieldNamed(entryType data.EntryType, name string) (typeField, bool) {
+	for _, field := range typeFields[entryType] {
+		if field.core == "" && field.named(name) {
+			return field, true
+		}
+	}
+	return typeField{}, false
+}
+
+// checkCardNumber accepts 12 to 19 digits, spaces and dashes aside, that pass
+// the Luhn check.
+func checkCardNumber(number string) error {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(number)
+	if len(digits) < 12 || len(digits) > 19 {
+		return errors.New("card numbers have 12 to 19 digits")
+	}
+
+	sum := 0
+	for i := range digits {
+		digit := digits[len(digits)-1-i]
+		if digit < '0' || digit > '9' {
+			return errors.New("card numbers have only digits")
+		}
+
+		n := int(digit - '0')
+		if i%2 == 1 {
+			n *= 2
+			if n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+	}
+
+	if sum%10 != 0 {
+		return errors.New("the number fails the Luhn check, check for typos")
+	}
+	return nil
+}
+
+func checkExpiry(expiry string) error {
+	if _, err := time.Parse("01/06", expiry); err != nil {
+		return errors.New("use MM/YY")
+	}
+	return nil
+}
+
+// cardExpires returns the end of the month a card expires in.
+func cardExpires(expiry string) time.Time {
+	month, _ := time.ParseInLocation("01/06", expiry, time.Local)
+	return month.AddDate(0, 1, 0)
+}
+
+func dateExpires(date string) time.Time {
+	day, _ := time.ParseInLocation(time.DateOnly, date, time.Local)
+	return day
+}
+
+func checkDate(date string) error {
+	if _, err := time.Parse(time.DateOnly, date); err != nil {
+		return errors.New("use YYYY-MM-DD")
+	}
+	return nil
+}
+
+func checkDigits(min, max int) func(string) error {
+	return func(value string) error {
+		if len(value) < min || len(value) > max || strings.Trim(value, "0123456789") != "" {
+			return fmt.Errorf("use %d to %d digits", min, max)
+		}
+		return nil
+	}
+}
+
+func checkEmail(email string) error {
+	_, err := mail.ParseAddress(email)
+	return err
+}
+
+// checkPrivateKey accepts keys in any format ssh can read, with or without a
+// passphrase.
+func checkPrivateKey(key string) error {
+	_, err := ssh.ParseRawPrivateKey([]byte(key))
+	var missing *ssh.PassphraseMissingError
+	if err != nil && !errors.As(err, &missing) {
+		return err
+	}
+	return nil
+}
+
+func checkPublicKey(key string) error {
+	_, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+	return err
+}
+
+func lastDigits(number string) string {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(number)
+	if len(digits) < 4 {
+		return maskedValue
+	}
+	return "**** " + digits[len(digits)-4:]
+}
+
+func lineCount(value string) string {
+	return fmt.Sprintf("%v (%d lines)", maskedValue, strings.Count(value, "\n")+1)
+}
+
+func fingerprint(key string) string {
+	public, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(key))
+	if err != nil {
+		return key
+	}
+	return strings.TrimSpace(fmt.Sprintf("%v %v %v", public.Type(), ssh.FingerprintSHA256(public), comment))
+}
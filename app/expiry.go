@@ -0,0 +1,207 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"squirrel/data"
+	"squirrel/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultExpiryWarningDays is how many days ahead entries are reported before
+// they expire.
+const DefaultExpiryWarningDays = 14
+
+var ErrBadExpiry = errors.New("use a date like 2025-12-31, an interval like 90d, or never")
+
+// ExpiringCommand lists the entries that expired or expire in the given number of
+// days, and passes how many there are to report.
+func ExpiringCommand(p types.Printer, s data.Store, d types.Decryptor, report func(due int)) Command {
+	return func(args ...string) {
+		days := DefaultExpiryWarningDays
+		if len(args) > 1 {
+			p("{red}Wrong arguments{/red}\nexpiring command examples:\n\texpiring\n\texpiring 30\n")
+			return
+		}
+		if len(args) == 1 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 0 {
+				p("{red}Bad number of days {0}.{/red}\n", args[0])
+				return
+			}
+			days = n
+		}
+
+		due, err := expiringEntries(s, d, time.Now(), days)
+		if err != nil {
+			p("{red}Error in loading entries!{/red}: {0}\n", err)
+			return
+		}
+
+		if len(due) == 0 {
+			p("{green}Nothing expires in the next {0} days.{/green}\n", days)
+		} else {
+			p("{0} entries expired or expire in the next {1} days:\n", len(due), days)
+			printExpiring(p, due, time.Now())
+		}
+		report(len(due))
+	}
+}
+
+// PrintExpiryBanner warns about the entries that expired or expire in the given
+// number of days. It prints nothing when there are none.
+func PrintExpiryBanner(p types.Printer, s data.Store, d types.Decryptor, now time.Time, days int) {
+	due, err := expiringEntries(s, d, now, days)
+	if err != nil || len(due) == 0 {
+		return
+	}
+
+	expired := 0
+	for _, entry := range due {
+		if due, _ := dueDate(entry); !due.After(now) {
+			expired++
+		}
+	}
+
+	p("{yellow}{0} expired and {1} expire in the next {2} days:{/yellow}\n", expired, len(due)-expired, days)
+	printExpiring(p, due, now)
+}
+
+func printExpiring(p types.Printer, due []data.Entry, now time.Time) {
+	for _, entry := range due {
+		date, _ := dueDate(entry)
+		if date.After(now) {
+			p("  {0} \tID: {1} \t{yellow}{2}{/yellow}\n", entry.Title, entry.Id, describeDue(date, now))
+		} else {
+			p("  {0} \tID: {1} \t{red}{2}{/red}\n", entry.Title, entry.Id, describeDue(date, now))
+		}
+	}
+}
+
+// expiringEntries returns the entries due before the given number of days from
+// now, the first due first.
+func expiringEntries(s data.Store, d types.Decryptor, now time.Time, days int) ([]data.Entry, error) {
+	entries, err := listAll(s, d)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := now.AddDate(0, 0, days)
+	var due []data.Entry
+	for _, entry := range entries {
+		if date, ok := dueDate(entry); ok && date.Before(limit) {
+			due = append(due, entry)
+		}
+	}
+
+	sort.SliceStable(due, func(i, j int) bool {
+		a, _ := dueDate(due[i])
+		b, _ := dueDate(due[j])
+		return a.Before(b)
+	})
+	return due, nil
+}
+
+// dueDate returns when the entry expires or its password must be rotated,
+// whichever comes first.
+func dueDate(entry data.Entry) (time.Time, bool) {
+	due := entry.Expires
+	if entry.RotationDays > 0 {
+		changed := entry.PasswordChanged
+		if changed.IsZero() {
+			changed = entry.Created
+		}
+		if changed.IsZero() {
+			changed = entry.Modified
+		}
+		if !changed.IsZero() {
+			rotate := changed.AddDate(0, 0, entry.RotationDays)
+			if due.IsZero() || rotate.Before(due) {
+				due = rotate
+			}
+		}
+	}
+	return due, !due.IsZero()
+}
+
+func describeDue(due time.Time, now time.Time) string {
+	days := calendarDays(now, due)
+	switch {
+	case !due.After(now) && days == 0:
+		return "expired today"
+	case !due.After(now):
+		return fmt.Sprintf("expired %d days ago", -days)
+	case days == 0:
+		return "expires today"
+	default:
+		return fmt.Sprintf("expires in %d days", days)
+	}
+}
+
+// calendarDays returns how many days from the day of a to the day of b.
+func calendarDays(a, b time.Time) int {
+	y, m, d := a.Local().Date()
+	dayA := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	y, m, d = b.Local().Date()
+	dayB := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	return int(dayB.Sub(dayA).Hours() / 24)
+}
+
+// parseExpiry reads a date the entry expires on, or an interval like 90d to
+// rotate it. never clears both.
+func parseExpiry(input string) (time.Time, int, error) {
+	input = strings.TrimSpace(strings.ToLower(input))
+	if input == "never" {
+		return time.Time{}, 0, nil
+	}
+
+	if days, ok := strings.CutSuffix(input, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 || n > data.MaxRotationDays {
+			return time.Time{}, 0, ErrBadExpiry
+		}
+		return time.Time{}, n, nil
+	}
+
+	date, err := time.ParseInLocation(time.DateOnly, input, time.Local)
+	if err != nil {
+		return time.Time{}, 0, ErrBadExpiry
+	}
+	return date, 0, nil
+}
+
+// readExpiry asks when the entry expires and sets it; an empty answer keeps what
+// it was.
+func readExpiry(p types.Printer, ent *data.Entry, prompt string) {
+	for {
+		var input string
+		ReadInput(prompt, "optional, a date like 2025-12-31, an interval like 90d or never", false, p, &input)
+		if strings.TrimSpace(input) == "" {
+			return
+		}
+
+		expires, days, err := parseExpiry(input)
+		if err == nil {
+			ent.Expires, ent.RotationDays = expires, days
+			return
+		}
+		p("{red}{0}{/red}\n", err)
+	}
+}
+
+// expirySummary describes when the entry expires for display.
+func expirySummary(entry data.Entry, now time.Time) string {
+	due, ok := dueDate(entry)
+	if !ok {
+		return ""
+	}
+
+	summary := due.Local().Format(time.DateOnly) + ", " + describeDue(due, now)
+	if entry.RotationDays > 0 {
+		summary = fmt.Sprintf("every %d days, next %v", entry.RotationDays, summary)
+	}
+	return summary
+}
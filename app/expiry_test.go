@@ -0,0 +1,95 @@
+package app
+
+import (
+	"squirrel/data"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExpiry(t *testing.T) {
+	date, days, err := parseExpiry("2030-06-01")
+	if err != nil || days != 0 || date.Format(time.DateOnly) != "2030-06-01" {
+		t.Errorf("Expected a date, but got %v %v (%v)", date, days, err)
+	}
+
+	date, days, err = parseExpiry("90d")
+	if err != nil || days != 90 || !date.IsZero() {
+		t.Errorf("Expected an interval, but got %v %v (%v)", date, days, err)
+	}
+
+	if date, days, err := parseExpiry("never"); err != nil || days != 0 || !date.IsZero() {
+		t.Errorf("Expected nothing, but got %v %v (%v)", date, days, err)
+	}
+
+	for _, bad := range []string{"soon", "0d", "-5d", "2030-13-01"} {
+		if _, _, err := parseExpiry(bad); err != ErrBadExpiry {
+			t.Errorf("Expected ErrBadExpiry for %q, but got %v", bad, err)
+		}
+	}
+}
+
+func TestDueDate(t *testing.T) {
+	changed := time.Date(2030, 1, 1, 12, 0, 0, 0, time.Local)
+	expires := time.Date(2030, 2, 1, 0, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		entry data.Entry
+		due   time.Time
+	}{
+		{data.Entry{}, time.Time{}},
+		{data.Entry{Expires: expires}, expires},
+		{data.Entry{RotationDays: 10, PasswordChanged: changed}, changed.AddDate(0, 0, 10)},
+		{data.Entry{RotationDays: 10, Created: changed}, changed.AddDate(0, 0, 10)},
+		{data.Entry{RotationDays: 90, PasswordChanged: changed, Expires: expires}, expires},
+		{data.Entry{RotationDays: 10}, time.Time{}},
+	}
+
+	for _, test := range tests {
+		due, ok := dueDate(test.entry)
+		if !due.Equal(test.due) || ok == test.due.IsZero() {
+			t.Errorf("Expected %+v due %v, but got %v %v", test.entry, test.due, due, ok)
+		}
+	}
+}
+
+func TestExpiringCommand(t *testing.T) {
+	now := time.Now()
+	s := testStore(t,
+		data.Entry{Id: 1, Title: "expired", Expires: now.AddDate(0, 0, -3)},
+		data.Entry{Id: 2, Title: "soon", RotationDays: 30, PasswordChanged: now.AddDate(0, 0, -25)},
+		data.Entry{Id: 3, Title: "later", Expires: now.AddDate(0, 0, 60)},
+		data.Entry{Id: 4, Title: "never"},
+	)
+
+	var out recorder
+	reported := -1
+	ExpiringCommand(out.print, s, testDecryptor, func(due int) { reported = due })()
+
+	printed := out.String()
+	expired, soon := strings.Index(printed, "expired \tID: 1"), strings.Index(printed, "soon \tID: 2")
+	if reported != 2 || expired < 0 || soon < expired || strings.Contains(printed, "later") {
+		t.Errorf("Expected the expired and the soon entry, but got %v: %q", reported, printed)
+	}
+	if !strings.Contains(printed, "expired 3 days ago") || !strings.Contains(printed, "expires in 5 days") {
+		t.Errorf("Expected how long ago and until, but got %q", printed)
+	}
+
+	out.Reset()
+	ExpiringCommand(out.print, s, testDecryptor, func(due int) { reported = due })("90")
+	if reported != 3 {
+		t.Errorf("Expected 3 entries in 90 days, but got %v: %q", reported, out.String())
+	}
+
+	out.Reset()
+	PrintExpiryBanner(out.print, s, testDecryptor, now, 14)
+	if printed := out.String(); !strings.Contains(printed, "1 expired and 1 expire in the next 14 days") {
+		t.Errorf("Expected a banner, but got %q", printed)
+	}
+
+	out.Reset()
+	PrintExpiryBanner(out.print, testStore(t, testEntries...), testDecryptor, now, 14)
+	if printed := out.String(); printed != "" {
+		t.Errorf("Expected no banner, but got %q", printed)
+	}
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"fmt"
+	"squirrel/data"
+	"squirrel/types"
+	"strconv"
+	"strings"
+)
+
+// maskedValue stands in for secret values on screen.
+const maskedValue = "********"
+
+// readFields asks for custom fields until an empty name is given.
+func readFields(p types.Printer) []data.CustomField {
+	var fields []data.CustomField
+	for {
+		var field data.CustomField
+		ReadInput("Field name", "empty to finish", false, p, &field.Name)
+		if strings.TrimSpace(field.Name) == "" {
+			return fields
+		}
+
+		field.Secret = GetYesNoInput(p, fmt.Sprintf("Is '%v' secret", field.Name))
+		if field.Secret {
+			ReadSecret(field.Name, "", false, p, &field.Value)
+		} else {
+			ReadInput(field.Name, "", false, p, &field.Value)
+		}
+		fields = append(fields, field)
+	}
+}
+
+// editFields asks which custom fields to keep or change, then for new ones. The
+// fields of the entry type are kept, they are edited with the type.
+func editFields(p types.Printer, entryType data.EntryType, fields []data.CustomField) []data.CustomField {
+	var edited []data.CustomField
+	for _, field := range fields {
+		if _, typed := typeFieldNamed(entryType, field.Name); typed {
+			edited = append(edited, field)
+			continue
+		}
+		if !GetYesNoInput(p, fmt.Sprintf("Keep field '%v'", field.Name)) {
+			continue
+		}
+
+		if GetYesNoInput(p, fmt.Sprintf("Update value of '%v'", field.Name)) {
+			if field.Secret {
+				ReadSecret("New value", "", false, p, &field.Value)
+			} else {
+				ReadInput("New value", "", false, p, &field.Value)
+			}
+		}
+		edited = append(edited, field)
+	}
+
+	return append(edited, readFields(p)...)
+}
+
+// findField returns the index of the custom field named by its number, counting
+// from 1, or by its name.
+func findField(fields []data.CustomField, nameOrNumber string) (int, bool) {
+	return findNamed(fields, func(field data.CustomField) string { return field.Name }, nameOrNumber)
+}
+
+// findNamed returns the index of the item named by its number, counting from 1,
+// or by its name.
+func findNamed[T any](items []T, name func(T) string, nameOrNumber string) (int, bool) {
+	if n, err := strconv.Atoi(nameOrNumber); err == nil {
+		return n - 1, n >= 1 && n <= len(items)
+	}
+
+	for i, item := range items {
+		if strings.EqualFold(name(item), nameOrNumber) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// mapFields returns a copy of fields with every name and value passed through f,
+// so the entry it came from is left as it was.
+func mapFields(fields []data.CustomField, f func(string) (string, error)) ([]data.CustomField, error) {
+	if fields == nil {
+		return nil, nil
+	}
+
+	mapped := make([]data.CustomField, len(fields))
+	for i, field := range fields {
+		name, err := f(field.Name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := f(field.Value)
+		if err != nil {
+			return nil, err
+		}
+		mapped[i] = data.CustomField{Name: name, Value: value, Secret: field.Secret}
+	}
+	return mapped, nil
+}
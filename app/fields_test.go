@@ -0,0 +1,46 @@
+package app
+
+import (
+	"squirrel/data"
+	"testing"
+)
+
+func TestFindField(t *testing.T) {
+	fields := []data.CustomField{{Name: "PIN"}, {Name: "Recovery code"}}
+
+	tests := []struct {
+		nameOrNumber string
+		index        int
+		found        bool
+	}{
+		{"1", 0, true},
+		{"2", 1, true},
+		{"3", 0, false},
+		{"0", 0, false},
+		{"pin", 0, true},
+		{"Recovery code", 1, true},
+		{"host", 0, false},
+	}
+
+	for _, test := range tests {
+		index, found := findField(fields, test.nameOrNumber)
+		if found != test.found || (found && index != test.index) {
+			t.Errorf("findField(%q) = %v, %v; expected %v, %v", test.nameOrNumber, index, found, test.index, test.found)
+		}
+	}
+}
+
+func TestMapFieldsCopies(t *testing.T) {
+	fields := []data.CustomField{{Name: "PIN", Value: "1234", Secret: true}}
+
+	mapped, err := mapFields(fields, testEncryptor)
+	if err != nil {
+		t.Fatalf("mapFields failed: %v", err)
+	}
+	if mapped[0].Value != testCipherPrefix+"1234" || !mapped[0].Secret {
+		t.Errorf("Expected the field to be encrypted, but got %+v", mapped[0])
+	}
+	if fields[0].Value != "1234" {
+		t.Errorf("Expected the original fields to be left as they were, but got %+v", fields[0])
+	}
+}
@@ -0,0 +1,336 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"squirrel/data"
+	"squirrel/types"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrBadTag = errors.New("tags can't be empty or contain spaces")
+
+// MoveCommand moves an entry into a folder, or to the top level with "/".
+func MoveCommand(p types.Printer, s data.Store, e types.Encryptor, d types.Decryptor) Command {
+	return func(args ...string) {
+		if len(args) != 2 {
+			p("{red}Wrong arguments{/red}\nmv command examples:\n\tmv 12 work/aws/prod\n\tmv 12 /\n")
+			return
+		}
+
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			p("{red}Bad ID! {0}{/red}\n", err)
+			return
+		}
+
+		ent, err := s.Load(id)
+		if err != nil {
+			p("{red}Loading entry with ID {0} failed! {1}{/red}\n", id, err)
+			return
+		}
+
+		title, err := d(ent.Title)
+		if err != nil {
+			p("{red}Decrypting entry with ID {0} failed! {1}{/red}\n", id, err)
+			return
+		}
+
+		folder := cleanFolder(args[1])
+		if ent.Folder, err = mapOptional(folder, e); err != nil {
+			p("{red}Encrypting the folder failed!{/red} {0}\n", err)
+			return
+		}
+		ent.Modified = time.Now()
+
+		if err := s.Update(id, ent); err != nil {
+			p("{red}Updating entity failed!{/red} {0}\n", err)
+			return
+		}
+
+		p("{green}Moved '{0}' to {1}.{/green}\n", title, folderName(folder))
+	}
+}
+
+// TagCommand adds tags to an entry with +tag and removes them with -tag, or
+// lists its tags when no changes are given.
+func TagCommand(p types.Printer, s data.Store, e types.Encryptor, d types.Decryptor) Command {
+	return func(args ...string) {
+		if len(args) < 1 {
+			p("{red}Wrong arguments{/red}\ntag command examples:\n\ttag 12\n\ttag 12 +prod -staging\n")
+			return
+		}
+
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			p("{red}Bad ID! {0}{/red}\n", err)
+			return
+		}
+
+		ent, err := s.Load(id)
+		if err != nil {
+			p("{red}Loading entry with ID {0} failed! {1}{/red}\n", id, err)
+			return
+		}
+
+		title, err := d(ent.Title)
+		if err != nil {
+			p("{red}Decrypting entry with ID {0} failed! {1}{/red}\n", id, err)
+			return
+		}
+
+		tags, err := mapTags(ent.Tags, d)
+		if err != nil {
+			p("{red}Decrypting entry with ID {0} failed! {1}{/red}\n", id, err)
+			return
+		}
+
+		if len(args) == 1 {
+			if len(tags) == 0 {
+				p("'{0}' has no tags.\n", title)
+			} else {
+				p("'{0}' is tagged {1}\n", title, formatTags(tags))
+			}
+			return
+		}
+
+		tags, err = changeTags(tags, args[1:])
+		if err != nil {
+			p("{red}{0}{/red}\ntag command examples:\n\ttag 12\n\ttag 12 +prod -staging\n", err)
+			return
+		}
+
+		if ent.Tags, err = mapTags(tags, e); err != nil {
+			p("{red}Encrypting the tags failed!{/red} {0}\n", err)
+			return
+		}
+		ent.Modified = time.Now()
+
+		if err := s.Update(id, ent); err != nil {
+			p("{red}Updating entity failed!{/red} {0}\n", err)
+			return
+		}
+
+		if len(tags) == 0 {
+			p("{green}'{0}' has no tags now.{/green}\n", title)
+		} else {
+			p("{green}'{0}' is tagged {1}{/green}\n", title, formatTags(tags))
+		}
+	}
+}
+
+// TreeCommand prints the entries in their folders, or only those in the given
+// folder.
+func TreeCommand(p types.Printer, s data.Store, d types.Decryptor) Command {
+	return func(args ...string) {
+		if len(args) > 1 {
+			p("{red}Wrong arguments{/red}\ntree command examples:\n\ttree\n\ttree work/aws\n")
+			return
+		}
+
+		var folder string
+		if len(args) == 1 {
+			folder = cleanFolder(args[0])
+		}
+
+		entries, err := listAll(s, d)
+		if err != nil {
+			p("{red}Error in loading entries!{/red}: {0}\n", err)
+			return
+		}
+
+		root := &folderNode{name: folderName(folder)}
+		for _, entry := range entries {
+			if inFolder(entry.Folder, folder) {
+				root.add(strings.TrimPrefix(strings.TrimPrefix(entry.Folder, folder), "/"), entry)
+			}
+		}
+
+		if len(root.entries) == 0 && len(root.folders) == 0 {
+			p("There are no entries in {0}.\n", folderName(folder))
+			return
+		}
+
+		p("{brightWhite}{0}{/brightWhite}\n", root.name)
+		root.print(p, "  ")
+	}
+}
+
+// folderNode is a folder of the tree, with its subfolders and entries.
+type folderNode struct {
+	name    string
+	folders map[string]*folderNode
+	entries []data.Entry
+}
+
+// add puts entry into the folder at path below the node.
+func (n *folderNode) add(path string, entry data.Entry) {
+	if path == "" {
+		n.entries = append(n.entries, entry)
+		return
+	}
+
+	name, rest, _ := strings.Cut(path, "/")
+	if n.folders == nil {
+		n.folders = map[string]*folderNode{}
+	}
+	child, ok := n.folders[name]
+	if !ok {
+		child = &folderNode{name: name}
+		n.folders[name] = child
+	}
+	child.add(rest, entry)
+}
+
+// print lists the subfolders, then the entries of the node, indented.
+func (n *folderNode) print(p types.Printer, indent string) {
+	names := make([]string, 0, len(n.folders))
+	for name := range n.folders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p("{0}{brightWhite}{1}/{/brightWhite}\n", indent, name)
+		n.folders[name].print(p, indent+"  ")
+	}
+
+	for _, entry := range n.entries {
+		p("{0}{1} \tID: {2} \tUsername: {3}{gray}{4}{/gray}\n", indent, entry.Title, entry.Id, entry.Username, tagsSuffix(entry.Tags))
+	}
+}
+
+// listAll returns every entry, sorted by title.
+func listAll(s data.Store, d types.Decryptor) ([]data.Entry, error) {
+	count, err := s.Count()
+	if err != nil || count == 0 {
+		return nil, err
+	}
+	return s.List(data.ByTitle, int(count), d)
+}
+
+// cleanFolder returns folder without empty, surrounding or repeated slashes, so
+// "/work//aws/" becomes "work/aws" and "/" the top level.
+func cleanFolder(folder string) string {
+	var parts []string
+	for _, part := range strings.Split(folder, "/") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// inFolder reports whether an entry in folder is in parent or below it.
+func inFolder(folder, parent string) bool {
+	return parent == "" || folder == parent || strings.HasPrefix(folder, parent+"/")
+}
+
+func folderName(folder string) string {
+	if folder == "" {
+		return "the top level"
+	}
+	return folder + "/"
+}
+
+// changeTags adds the tags given as +tag and removes those given as -tag. The
+// result is sorted and has no duplicates.
+func changeTags(tags []string, changes []string) ([]string, error) {
+	set := map[string]bool{}
+	for _, tag := range tags {
+		set[tag] = true
+	}
+
+	for _, change := range changes {
+		if len(change) < 2 || (change[0] != '+' && change[0] != '-') {
+			return nil, fmt.Errorf("bad tag change %q, use +tag or -tag", change)
+		}
+		tag, err := cleanTag(change[1:])
+		if err != nil {
+			return nil, err
+		}
+		set[tag] = change[0] == '+'
+	}
+
+	var changed []string
+	for tag, tagged := range set {
+		if tagged {
+			changed = append(changed, tag)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// parseTags reads tags separated by spaces or commas.
+func parseTags(input string) ([]string, error) {
+	var changes []string
+	for _, tag := range strings.FieldsFunc(input, func(r rune) bool { return r == ',' || r == ' ' }) {
+		changes = append(changes, "+"+tag)
+	}
+	return changeTags(nil, changes)
+}
+
+func cleanTag(tag string) (string, error) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+	if tag == "" || strings.ContainsAny(tag, " \t,") {
+		return "", ErrBadTag
+	}
+	return tag, nil
+}
+
+// hasTags reports whether every one of wanted is among tags.
+func hasTags(tags []string, wanted []string) bool {
+	for _, want := range wanted {
+		found := false
+		for _, tag := range tags {
+			if strings.EqualFold(tag, want) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func formatTags(tags []string) string {
+	return "#" + strings.Join(tags, " #")
+}
+
+func tagsSuffix(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return " \t" + formatTags(tags)
+}
+
+// mapTags returns a copy of tags with every tag passed through f.
+func mapTags(tags []string, f func(string) (string, error)) ([]string, error) {
+	if tags == nil {
+		return nil, nil
+	}
+
+	mapped := make([]string, len(tags))
+	for i, tag := range tags {
+		var err error
+		if mapped[i], err = f(tag); err != nil {
+			return nil, err
+		}
+	}
+	return mapped, nil
+}
+
+// mapOptional passes value through f unless it is empty, for fields that older
+// entries leave empty without encrypting them.
+func mapOptional(value string, f func(string) (string, error)) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	return f(value)
+}
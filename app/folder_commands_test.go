@@ -0,0 +1,104 @@
+package app
+
+import (
+	"reflect"
+	"squirrel/data"
+	"strings"
+	"testing"
+)
+
+func TestMoveCommand(t *testing.T) {
+	s := testStore(t, testEntries...)
+
+	var out recorder
+	MoveCommand(out.print, s, testEncryptor, testDecryptor)("1", "/personal//mail/")
+	if printed := out.String(); !strings.Contains(printed, "Moved 'gmail' to personal/mail/.") {
+		t.Errorf("Expected the entry to be moved, but got %q", printed)
+	}
+
+	stored, _ := s.Load(1)
+	if stored.Folder != testCipherPrefix+"personal/mail" || stored.Modified.IsZero() {
+		t.Errorf("Expected the encrypted folder to be stored, but got %+v", stored)
+	}
+
+	out.Reset()
+	MoveCommand(out.print, s, testEncryptor, testDecryptor)("1", "/")
+	if stored, _ := s.Load(1); stored.Folder != "" {
+		t.Errorf("Expected the entry at the top level, but got %+v", stored)
+	}
+}
+
+func TestTagCommand(t *testing.T) {
+	s := testStore(t, data.Entry{Id: 1, Title: "gmail", Tags: []string{"mail", "old"}})
+
+	var out recorder
+	TagCommand(out.print, s, testEncryptor, testDecryptor)("1", "+personal", "-old", "+mail")
+	if printed := out.String(); !strings.Contains(printed, "'gmail' is tagged #mail #personal") {
+		t.Errorf("Expected the new tags, but got %q", printed)
+	}
+
+	stored, _ := s.Load(1)
+	expected := []string{testCipherPrefix + "mail", testCipherPrefix + "personal"}
+	if !reflect.DeepEqual(stored.Tags, expected) {
+		t.Errorf("Expected %v, but got %v", expected, stored.Tags)
+	}
+
+	out.Reset()
+	TagCommand(out.print, s, testEncryptor, testDecryptor)("1", "personal")
+	if printed := out.String(); !strings.Contains(printed, "bad tag change") {
+		t.Errorf("Expected a bad change error, but got %q", printed)
+	}
+}
+
+func TestTreeCommand(t *testing.T) {
+	s := testStore(t,
+		data.Entry{Id: 1, Title: "console", Folder: "work/aws/prod"},
+		data.Entry{Id: 2, Title: "staging", Folder: "work/aws/staging"},
+		data.Entry{Id: 3, Title: "gmail"},
+		data.Entry{Id: 4, Title: "intranet", Folder: "work"},
+	)
+
+	var out recorder
+	TreeCommand(out.print, s, testDecryptor)()
+
+	expected := []string{
+		"{brightWhite}the top level",
+		"  {brightWhite}work/",
+		"    {brightWhite}aws/",
+		"      {brightWhite}prod/",
+		"        console \tID: 1",
+		"      {brightWhite}staging/",
+		"        staging \tID: 2",
+		"    intranet \tID: 4",
+		"  gmail \tID: 3",
+	}
+	printed := out.String()
+	last := -1
+	for _, line := range expected {
+		i := strings.Index(printed, line)
+		if i <= last {
+			t.Fatalf("Expected %q after position %d in %q", line, last, printed)
+		}
+		last = i
+	}
+
+	out.Reset()
+	TreeCommand(out.print, s, testDecryptor)("work/aws")
+	if printed := out.String(); strings.Contains(printed, "gmail") || !strings.Contains(printed, "    console") {
+		t.Errorf("Expected only the work/aws folder, but got %q", printed)
+	}
+}
+
+func TestChangeTags(t *testing.T) {
+	tags, err := changeTags([]string{"b"}, []string{"+a", "+#c", "-b", "-d"})
+	if err != nil || !reflect.DeepEqual(tags, []string{"a", "c"}) {
+		t.Errorf("Expected [a c], but got %v (%v)", tags, err)
+	}
+
+	if _, err := parseTags("a, b c"); err != nil {
+		t.Errorf("Expected tags separated by commas and spaces to parse, but got %v", err)
+	}
+	if _, err := changeTags(nil, []string{"+#"}); err != ErrBadTag {
+		t.Errorf("Expected ErrBadTag, but got %v", err)
+	}
+}
@@ -26,15 +26,159 @@ func HelpCommand(p types.Printer) Command {
 			{
 				command:     "delete",
 				aliases:     []string{"del", "remove"},
-				description: "Deletes an entry.",
+				description: "Moves an entry to the trash.",
 				examples:    []string{"delete 123", "del", "remove 32"},
 			},
+			{
+				command:     "trash",
+				aliases:     []string{},
+				description: "Lists the entries in the trash.",
+				examples:    []string{"trash"},
+			},
+			{
+				command:     "undelete",
+				aliases:     []string{},
+				description: "Takes an entry out of the trash.",
+				examples:    []string{"undelete 123"},
+			},
+			{
+				command:     "purge",
+				aliases:     []string{},
+				description: "Deletes an entry in the trash, or all of them, for good.",
+				examples:    []string{"purge 123", "purge all"},
+			},
 			{
 				command:     "new",
 				aliases:     []string{"create", "add"},
-				description: "Creates a new entry.",
+				description: "Creates a new entry: a login, note, card, identity, ssh key or token.",
 				examples:    []string{"new", "create", "add"},
 			},
+			{
+				command:     "show",
+				aliases:     []string{},
+				description: "Shows an entry with its secret custom fields masked, or reveals one of them.",
+				examples:    []string{"show 12", "show 12 reveal PIN", "show 12 reveal 1"},
+			},
+			{
+				command:     "list",
+				aliases:     []string{"ls"},
+				description: "Lists entries by title, username or ID, optionally only those in a folder or with tags.",
+				examples:    []string{"list", "list username 30", "list --folder work/aws", "list --tag prod --tag shared"},
+			},
+			{
+				command:     "tree",
+				aliases:     []string{},
+				description: "Shows the entries in their folders, or only those in one folder.",
+				examples:    []string{"tree", "tree work"},
+			},
+			{
+				command:     "mv",
+				aliases:     []string{},
+				description: "Moves an entry into a folder, or to the top level with /.",
+				examples:    []string{"mv 12 work/aws/prod", "mv 12 /"},
+			},
+			{
+				command:     "tag",
+				aliases:     []string{},
+				description: "Lists the tags of an entry, adds tags with + and removes them with -.",
+				examples:    []string{"tag 12", "tag 12 +prod -staging"},
+			},
+			{
+				command:     "otp",
+				aliases:     []string{},
+				description: "Prints the current one-time password of an entry, with how long it stays valid.",
+				examples:    []string{"otp 12"},
+			},
+			{
+				command:     "attach",
+				aliases:     []string{},
+				description: "Encrypts a file of up to 16 MB and keeps it with an entry.",
+				examples:    []string{"attach 12 ~/.ssh/id_ed25519"},
+			},
+			{
+				command:     "attachments",
+				aliases:     []string{},
+				description: "Lists the attachments of an entry.",
+				examples:    []string{"attachments 12"},
+			},
+			{
+				command:     "extract",
+				aliases:     []string{},
+				description: "Decrypts an attachment, by its name or number, into a file or directory.",
+				examples:    []string{"extract 12 id_ed25519 ~/.ssh/", "extract 12 1 key.pem"},
+			},
+			{
+				command:     "expiring",
+				aliases:     []string{},
+				description: "Lists the entries that expired or expire in the next days, 14 unless given. Run as squirrel expiring it exits with status 1 when there are any.",
+				examples:    []string{"expiring", "expiring 30"},
+			},
+			{
+				command:     "history",
+				aliases:     []string{},
+				description: "Lists the previous passwords of an entry, reveals one or makes it the current password again.",
+				examples:    []string{"history 12", "history 12 reveal 1", "history 12 restore 1"},
+			},
+			{
+				command:     "verify",
+				aliases:     []string{},
+				description: "Checks that the data file was not modified outside squirrel.",
+				examples:    []string{"verify"},
+			},
+			{
+				command:     "backups",
+				aliases:     []string{},
+				description: "Lists the backups of the vault, the newest first. A backup is taken before every change.",
+				examples:    []string{"backups"},
+			},
+			{
+				command:     "backup",
+				aliases:     []string{},
+				description: "Backs up the vault now.",
+				examples:    []string{"backup"},
+			},
+			{
+				command:     "restore",
+				aliases:     []string{},
+				description: "Replaces the vault with a backup, by its number in the backups list or its name.",
+				examples:    []string{"restore 1", "restore 20240131-093000.000"},
+			},
+			{
+				command:     "passwd",
+				aliases:     []string{},
+				description: "Changes the master password and re-encrypts the vault under it. A backup is taken first, unless backups are off; it opens with the old password.",
+				examples:    []string{"passwd"},
+			},
+			{
+				command:     "rekey",
+				aliases:     []string{},
+				description: "Re-encrypts the vault under a key derived with the default Argon2id parameters, keeping the master password. It moves older vaults to Argon2id.",
+				examples:    []string{"rekey"},
+			},
+			{
+				command:     "kdf-benchmark",
+				aliases:     []string{},
+				description: "Picks the Argon2id parameters that unlock the vault in the given seconds on this machine, 1 unless given, using at most the given MiB of memory, and offers to re-key the vault with them.",
+				examples:    []string{"kdf-benchmark", "kdf-benchmark 2", "kdf-benchmark 0.5 256"},
+			},
+			{
+				command:     "vaults",
+				aliases:     []string{},
+				description: "Lists the vaults, the open one is marked with *.",
+				examples:    []string{"vaults"},
+			},
+			{
+				command:     "open",
+				aliases:     []string{},
+				description: "Opens another vault, creating it if needed. The open vault is closed once the other one is unlocked.",
+				examples:    []string{"open work", "open default"},
+			},
+			{
+				command:     "close",
+				aliases:     []string{},
+				description: "Closes the open vault and forgets its key.",
+				examples:    []string{"close"},
+			},
 		}
 		printHelpLines(helpLines, p)
 	}
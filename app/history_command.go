@@ -0,0 +1,126 @@
+package app
+
+import (
+	"fmt"
+	"squirrel/data"
+	"squirrel/types"
+	"strconv"
+	"time"
+)
+
+// secondsToReveal is how long a revealed password stays on screen.
+const secondsToReveal = 5
+
+func HistoryCommand(p types.Printer, s data.Store, e types.Encryptor, d types.Decryptor) Command {
+	return func(args ...string) {
+		if len(args) != 1 && len(args) != 3 {
+			p("{red}Wrong arguments{/red}\nhistory command examples:\n\thistory 12\n\thistory 12 reveal 2\n\thistory 12 restore 2\n")
+			return
+		}
+
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			p("{red}Bad ID! {0}{/red}\n", err)
+			return
+		}
+
+		ent, err := s.Load(id)
+		if err != nil {
+			p("{red}Loading entry with ID {0} failed! {1}{/red}\n", id, err)
+			return
+		}
+
+		if err := decrypt(&ent, d); err != nil {
+			p("{red}Decrypting entry with ID {0} failed! {1}{/red}\n", id, err)
+			return
+		}
+
+		if len(args) == 1 {
+			printHistory(p, ent)
+			return
+		}
+
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 1 || n > len(ent.History) {
+			p("{red}There is no previous password {0}.{/red} Type {green}history {1}{/green} to list them.\n", args[2], id)
+			return
+		}
+		// Listed the newest first
+		i := len(ent.History) - n
+
+		switch args[1] {
+		case "reveal":
+			PrintSecret(p, fmt.Sprintf("Previous password %d", n), ent.History[i].Password, secondsToReveal)
+		case "restore":
+			if !GetYesNoInput(p, fmt.Sprintf("Make password %d the current password of '%v'", n, ent.Title)) {
+				return
+			}
+
+			restoreHistory(&ent, i, time.Now())
+			if err := encryptEntry(&ent, e, p); err != nil {
+				return
+			}
+			if err := s.Update(ent.Id, ent); err != nil {
+				p("{red}Updating entity failed!{/red} {0}\n", err)
+				return
+			}
+
+			p("{green}Password restored. The replaced one is kept in the history.{/green}\n")
+		default:
+			p("{red}Unknown action {0}.{/red} Use reveal or restore.\n", args[1])
+		}
+	}
+}
+
+func printHistory(p types.Printer, ent data.Entry) {
+	if len(ent.History) == 0 {
+		p("'{0}' has no previous passwords.\n", ent.Title)
+		return
+	}
+
+	p("Previous passwords of '{0}', the newest first:\n", ent.Title)
+	for n := 1; n <= len(ent.History); n++ {
+		change := ent.History[len(ent.History)-n]
+		p("{0}. ******** \t{gray}replaced {1}{/gray}\n", n, change.Replaced.Local().Format("2006-01-02 15:04"))
+	}
+}
+
+// changePassword sets a new password and keeps the one it replaces in the history.
+// It does nothing when the password stays the same.
+func changePassword(ent *data.Entry, password string, now time.Time) {
+	if password == ent.Password {
+		return
+	}
+
+	if ent.Password != "" {
+		ent.History = append(ent.History, data.PasswordChange{Password: ent.Password, Replaced: now})
+	}
+	ent.Password = password
+	ent.PasswordChanged = now
+	ent.Modified = now
+}
+
+// restoreHistory makes the previous password at index i the current one.
+func restoreHistory(ent *data.Entry, i int, now time.Time) {
+	password := ent.History[i].Password
+	ent.History = append(ent.History[:i:i], ent.History[i+1:]...)
+	changePassword(ent, password, now)
+}
+
+// mapHistory returns a copy of history with every password passed through f, so
+// the entry it came from is left as it was.
+func mapHistory(history []data.PasswordChange, f func(string) (string, error)) ([]data.PasswordChange, error) {
+	if history == nil {
+		return nil, nil
+	}
+
+	mapped := make([]data.PasswordChange, len(history))
+	for i, change := range history {
+		password, err := f(change.Password)
+		if err != nil {
+			return nil, err
+		}
+		mapped[i] = data.PasswordChange{Password: password, Replaced: change.Replaced}
+	}
+	return mapped, nil
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"squirrel/data"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangePasswordKeepsHistory(t *testing.T) {
+	ent := data.Entry{Id: 1, Password: "first"}
+	now := time.Unix(1700000000, 0)
+
+	changePassword(&ent, "first", now)
+	if len(ent.History) != 0 {
+		t.Errorf("Expected an unchanged password to stay out of the history, but got %+v", ent.History)
+	}
+
+	changePassword(&ent, "second", now)
+	changePassword(&ent, "third", now.Add(time.Hour))
+	if ent.Password != "third" || len(ent.History) != 2 || ent.History[0].Password != "first" || !ent.History[1].Replaced.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Unexpected history %+v for password %v", ent.History, ent.Password)
+	}
+
+	restoreHistory(&ent, 0, now.Add(2*time.Hour))
+	if ent.Password != "first" {
+		t.Errorf("Expected the restored password to be current, but got %v", ent.Password)
+	}
+	if len(ent.History) != 2 || ent.History[0].Password != "second" || ent.History[1].Password != "third" {
+		t.Errorf("Expected the replaced password in the history, but got %+v", ent.History)
+	}
+}
+
+func TestHistoryCommandMasksPasswords(t *testing.T) {
+	entry := data.Entry{Id: 1, Title: "gmail", Password: "current", History: []data.PasswordChange{
+		{Password: "oldest", Replaced: time.Unix(1700000000, 0)},
+		{Password: "newer", Replaced: time.Unix(1800000000, 0)},
+	}}
+	s := testStore(t, entry)
+
+	var out recorder
+	HistoryCommand(out.print, s, testEncryptor, testDecryptor)("1")
+
+	if !strings.Contains(out.String(), "Previous passwords of 'gmail'") {
+		t.Errorf("Unexpected history listing:\n%v", out.String())
+	}
+	if strings.Contains(out.String(), "oldest") || strings.Contains(out.String(), "newer") {
+		t.Errorf("Expected the passwords to be masked:\n%v", out.String())
+	}
+
+	newest := time.Unix(1800000000, 0).Local().Format("2006-01-02")
+	if !strings.Contains(out.String(), "1. ******** \t{gray}replaced "+newest) {
+		t.Errorf("Expected the newest password first:\n%v", out.String())
+	}
+
+	// The stored entry is still encrypted
+	stored, _ := s.Load(1)
+	if stored.History[0].Password != testCipherPrefix+"oldest" {
+		t.Errorf("Expected the stored history to stay encrypted, but got %+v", stored.History)
+	}
+}
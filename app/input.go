@@ -3,6 +3,7 @@ package app
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"squirrel/types"
 	"strconv"
@@ -63,6 +64,10 @@ func ReadSecret(name string, desc string, mandatory bool, p types.Printer, t *st
 		p("{0}{1}: ", name, description)
 
 		password, err := readPasswordWithMask()
+		if err == io.EOF {
+			p("\n{red}There is no more input for {0}.{/red}\n", name)
+			os.Exit(1)
+		}
 		if err != nil {
 			p("{red}Reading {0} failed!{/red}{1}\n", name, err)
 			continue
@@ -78,8 +83,8 @@ func ReadSecret(name string, desc string, mandatory bool, p types.Printer, t *st
 	}
 }
 
-func PrintSecret(p types.Printer, secret string, seconds int) {
-	p("{gray}Your chosen master password: {0}{/gray}\n", secret)
+func PrintSecret(p types.Printer, label string, secret string, seconds int) {
+	p("{gray}{0}: {1}{/gray}\n", label, secret)
 
 	time.Sleep(time.Duration(seconds) * time.Second)
 
@@ -116,6 +121,25 @@ func GetYesNoInput(p types.Printer, prompt string) bool {
 	}
 }
 
+// readLineUnbuffered reads a line a byte at a time, so it doesn't take any input
+// meant for the next prompt.
+func readLineUnbuffered(r io.Reader) (string, error) {
+	var line []byte
+	var b [1]byte
+	for {
+		n, err := r.Read(b[:])
+		if n == 1 && b[0] != '\n' {
+			line = append(line, b[0])
+		}
+		if n == 1 && b[0] == '\n' || err == io.EOF && len(line) > 0 {
+			return strings.TrimSuffix(string(line), "\r"), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
 // clearLine uses ANSI escape codes to clear the previous line
 func clearLine() {
 	// Move cursor up one line and clear the line
@@ -126,6 +150,11 @@ func readPasswordWithMask() (string, error) {
 	var password []byte
 	var err error
 
+	// Scripts pipe the password in
+	if !term.IsTerminal(int(syscall.Stdin)) {
+		return readLineUnbuffered(os.Stdin)
+	}
+
 	password, err = term.ReadPassword(int(syscall.Stdin))
 	if err != nil {
 		return "", err
@@ -14,29 +14,95 @@ var (
 	DefaultLimit               = 10
 )
 
-func ListCommand(p types.Printer, d types.Decryptor) Command {
+func ListCommand(p types.Printer, s data.Store, d types.Decryptor) Command {
 	return func(args ...string) {
-		order, limit, err := determineOrderAndLimit(args...)
+		filter, args, err := parseListFilter(args...)
+		var order data.Order
+		var limit int
+		if err == nil {
+			order, limit, err = determineOrderAndLimit(args...)
+		}
 		if err != nil {
 			p("{gray}{0}{/gray}\n", err.Error())
-			p("{red}Wrong arguments{/red}\nlist command examples:{/brightWhite}\n\tlist\n\tlist title 20\n\tlist 20\n\tlist username\n\tlist username 30{/brightWhite}\n")
+			p("{red}Wrong arguments{/red}\nlist command examples:{/brightWhite}\n\tlist\n\tlist title 20\n\tlist 20\n\tlist username\n\tlist username 30\n\tlist --folder work/aws\n\tlist --tag prod --tag shared{/brightWhite}\n")
 			return
 		}
 
-		count, _ := data.CountEntries()
+		count, _ := s.Count()
 		p("There are {0} entries.\n", count)
 
 		if count > 0 {
-			entries, err := data.Entries(order, limit, d)
+			listed := limit
+			if filter.active() {
+				listed = int(count)
+			}
+
+			entries, err := s.List(order, listed, d)
 			if err != nil {
 				p("{red}Error in loading entries!{/red}: {0}\n", err)
 			}
 
+			if filter.active() {
+				entries = filter.apply(entries)
+				p("{0} of them match.\n", len(entries))
+				if len(entries) > limit {
+					entries = entries[:limit]
+				}
+			}
+
 			for i, entry := range entries {
-				p("{0}. {1} \tID: {2} \tUsername: {3}\n", i+1, entry.Title, entry.Id, entry.Username)
+				p("{0}. {1} \tID: {2} \tUsername: {3}{gray}{4}{/gray}\n", i+1, entry.Title, entry.Id, entry.Username, tagsSuffix(entry.Tags))
+			}
+		}
+	}
+}
+
+// listFilter keeps the entries in a folder, or below it, that have all the tags.
+type listFilter struct {
+	folder string
+	tags   []string
+}
+
+func (f listFilter) active() bool {
+	return f.folder != "" || len(f.tags) > 0
+}
+
+func (f listFilter) apply(entries []data.Entry) []data.Entry {
+	var kept []data.Entry
+	for _, entry := range entries {
+		if inFolder(entry.Folder, f.folder) && hasTags(entry.Tags, f.tags) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// parseListFilter takes the --folder and --tag options out of args and returns
+// the arguments left.
+func parseListFilter(args ...string) (listFilter, []string, error) {
+	var filter listFilter
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--folder", "--tag":
+			if i+1 == len(args) {
+				return listFilter{}, nil, fmt.Errorf("%v; %v needs a value", ErrWrongArgsForCommandList, args[i])
 			}
+			if args[i] == "--folder" {
+				filter.folder = cleanFolder(args[i+1])
+			} else {
+				tag, err := cleanTag(args[i+1])
+				if err != nil {
+					return listFilter{}, nil, fmt.Errorf("%v; %w", ErrWrongArgsForCommandList, err)
+				}
+				filter.tags = append(filter.tags, tag)
+			}
+			i++
+		default:
+			rest = append(rest, args[i])
 		}
 	}
+	return filter, rest, nil
 }
 
 func determineOrderAndLimit(args ...string) (data.Order, int, error) {
@@ -52,7 +118,7 @@ func determineOrderAndLimit(args ...string) (data.Order, int, error) {
 			return DefaultOrder, DefaultLimit, fmt.Errorf("%v; %w", ErrWrongArgsForCommandList, err)
 		}
 
-		limit, err := strconv.Atoi(args[1])
+		limit, err := parseLimit(args[1])
 		if err != nil {
 			return DefaultOrder, DefaultLimit, fmt.Errorf("%v; %w", ErrWrongArgsForCommandList, err)
 		}
@@ -61,9 +127,11 @@ func determineOrderAndLimit(args ...string) (data.Order, int, error) {
 
 	case 1:
 		order, err1 := data.OrderFromString(args[0])
-		limit, err2 := strconv.Atoi(args[0])
+		limit, err2 := parseLimit(args[0])
 
-		if err1 != nil && err2 != nil {
+		if errors.Is(err2, errLimitOutOfRange) {
+			return DefaultOrder, DefaultLimit, fmt.Errorf("%v; %w", ErrWrongArgsForCommandList, err2)
+		} else if err1 != nil && err2 != nil {
 			return DefaultOrder, DefaultLimit, fmt.Errorf("%v; %w", ErrWrongArgsForCommandList, fmt.Errorf("%v - %v", err1, err2))
 		} else if err1 != nil {
 			return DefaultOrder, limit, nil
@@ -74,3 +142,17 @@ func determineOrderAndLimit(args ...string) (data.Order, int, error) {
 		return DefaultOrder, DefaultLimit, ErrWrongArgsForCommandList
 	}
 }
+
+var errLimitOutOfRange = errors.New("the number of entries must be at least 1")
+
+// parseLimit reads the number of entries to list.
+func parseLimit(arg string) (int, error) {
+	limit, err := strconv.Atoi(arg)
+	if err != nil {
+		return 0, err
+	}
+	if limit < 1 {
+		return 0, errLimitOutOfRange
+	}
+	return limit, nil
+}
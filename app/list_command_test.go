@@ -0,0 +1,110 @@
+package app
+
+import (
+	"fmt"
+	"squirrel/data"
+	"strings"
+	"testing"
+)
+
+func TestListCommand(t *testing.T) {
+	var out recorder
+	ListCommand(out.print, testStore(t, testEntries...), testDecryptor)()
+
+	printed := out.String()
+	if !strings.Contains(printed, "There are 3 entries.") {
+		t.Errorf("Expected the entry count, but got %q", printed)
+	}
+
+	aws := strings.Index(printed, "1. aws")
+	gmail := strings.Index(printed, "2. gmail")
+	work := strings.Index(printed, "3. work mail")
+	if aws < 0 || gmail < aws || work < gmail {
+		t.Errorf("Expected decrypted titles in title order, but got %q", printed)
+	}
+}
+
+func TestListCommandOrderAndLimit(t *testing.T) {
+	var out recorder
+	ListCommand(out.print, testStore(t, testEntries...), testDecryptor)("username", "1")
+
+	printed := out.String()
+	if !strings.Contains(printed, "1. aws \tID: 2 \tUsername: alice") {
+		t.Errorf("Expected alice first, but got %q", printed)
+	}
+	if strings.Contains(printed, "2. ") {
+		t.Errorf("Expected a single entry, but got %q", printed)
+	}
+}
+
+func TestListCommandEmpty(t *testing.T) {
+	var out recorder
+	ListCommand(out.print, data.NewMemoryStore(), testDecryptor)()
+
+	if printed := out.String(); printed != "There are 0 entries.\n" {
+		t.Errorf("Unexpected output %q", printed)
+	}
+}
+
+func TestListCommandFilters(t *testing.T) {
+	s := testStore(t,
+		data.Entry{Id: 1, Title: "console", Folder: "work/aws/prod", Tags: []string{"prod", "shared"}},
+		data.Entry{Id: 2, Title: "staging", Folder: "work/aws/staging", Tags: []string{"shared"}},
+		data.Entry{Id: 3, Title: "gmail", Tags: []string{"prod"}},
+		data.Entry{Id: 4, Title: "workshop", Folder: "workshop"},
+	)
+
+	tests := []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"--folder", "work"}, []string{"console", "staging"}},
+		{[]string{"--folder", "/work/aws/prod/"}, []string{"console"}},
+		{[]string{"--tag", "prod"}, []string{"console", "gmail"}},
+		{[]string{"--tag", "#shared", "--folder", "work", "id", "1"}, []string{"console"}},
+		{[]string{"--tag", "prod", "--tag", "shared"}, []string{"console"}},
+	}
+
+	for _, test := range tests {
+		var out recorder
+		ListCommand(out.print, s, testDecryptor)(test.args...)
+
+		printed := out.String()
+		if !strings.Contains(printed, "There are 4 entries.") {
+			t.Errorf("%v: expected the entry count, but got %q", test.args, printed)
+		}
+		for i, title := range test.expected {
+			if !strings.Contains(printed, fmt.Sprintf("%d. %s \t", i+1, title)) {
+				t.Errorf("%v: expected %q listed as %d, but got %q", test.args, title, i+1, printed)
+			}
+		}
+		if strings.Contains(printed, fmt.Sprintf("%d. ", len(test.expected)+1)) {
+			t.Errorf("%v: expected %d entries, but got %q", test.args, len(test.expected), printed)
+		}
+	}
+}
+
+func TestListCommandRejectsLimitBelowOne(t *testing.T) {
+	for _, args := range [][]string{
+		{"--tag", "prod", "-5"},
+		{"-5"},
+		{"0"},
+		{"title", "-1"},
+	} {
+		var out recorder
+		ListCommand(out.print, testStore(t, testEntries...), testDecryptor)(args...)
+
+		if printed := out.String(); !strings.Contains(printed, "Wrong arguments") || strings.Contains(printed, "1. ") {
+			t.Errorf("%v: expected a usage error, but got %q", args, printed)
+		}
+	}
+}
+
+func TestListCommandFilterNeedsValue(t *testing.T) {
+	var out recorder
+	ListCommand(out.print, testStore(t, testEntries...), testDecryptor)("--tag")
+
+	if printed := out.String(); !strings.Contains(printed, "Wrong arguments") {
+		t.Errorf("Expected a usage error, but got %q", printed)
+	}
+}
@@ -4,59 +4,92 @@ import (
 	"fmt"
 	"squirrel/data"
 	"squirrel/types"
+	"time"
 )
 
-func NewCommand(p types.Printer, e types.Encryptor) Command {
+func NewCommand(p types.Printer, s data.Store, e types.Encryptor) Command {
 	return func(args ...string) {
 		var ne data.Entry
-		p("{gray}New entry (all fields except title will be encrypted){/gray}\n")
-
-		var pass, veryfy string
+		p("{gray}New entry (all fields will be encrypted){/gray}\n")
 
+		ne.Type = readType(p)
 		ReadInput("Title", "", true, p, &ne.Title)
 
+		now := time.Now()
+		ne.Created = now
+		ne.Modified = now
+		if ne.Type == data.Login {
+			readLogin(p, &ne, now)
+		} else {
+			readTypeFields(p, &ne)
+		}
+		ReadInput("Notes", "optional", false, p, &ne.Notes)
+		var folder, tags string
+		ReadInput("Folder", "optional, like work/aws", false, p, &folder)
+		ne.Folder = cleanFolder(folder)
 		for {
-			ReadSecret("Password", "optional", false, p, &pass)
-
-			if pass == "" {
-				break
-			}
-
-			ReadSecret("Verify password", "optional", false, p, &veryfy)
-
-			if pass != veryfy {
-				p("{brightWhite}Password did not match! Try again.{/brightWhite}\n")
-			} else {
+			ReadInput("Tags", "optional, separated by spaces", false, p, &tags)
+			var err error
+			if ne.Tags, err = parseTags(tags); err == nil {
 				break
 			}
+			p("{red}{0}{/red}\n", err)
+		}
+		ne.OTP = readOTP(p, "OTP secret")
+		readExpiry(p, &ne, "Expires")
+		if GetYesNoInput(p, "Add custom fields") {
+			ne.Fields = readFields(p)
 		}
 
-		ne.Password = pass
-		ReadInput("Username", "optional", false, p, &ne.Username)
-		ReadInput("Address", "optional", false, p, &ne.Address)
-		ReadInput("Notes", "optional", false, p, &ne.Notes)
-
+		title := ne.Title
 		err := encryptEntry(&ne, e, p)
 		if err != nil {
 			p("{red}Encrypting the new entry failed!{/red}\n", err)
 			return
 		}
 
-		id, err := data.GetLargestId()
+		id, err := s.LargestId()
 		if err != nil {
 			p("{red}Getting last ID failed!{/red}\n", err)
 		}
 
 		ne.Id = id + 1
 
-		err = data.SaveEntry(ne)
+		err = s.Save(ne)
 		if err != nil {
 			p("{red}Saving the new entry failed!{/red}\n", err)
 		} else {
-			p("{green}Entry '{0}' was saved successfully. ID: {1}{/green}\n", ne.Title, ne.Id)
+			p("{green}Entry '{0}' was saved successfully. ID: {1}{/green}\n", title, ne.Id)
+		}
+
+	}
+}
+
+func readLogin(p types.Printer, ne *data.Entry, now time.Time) {
+	var pass, veryfy string
+
+	for {
+		ReadSecret("Password", "optional", false, p, &pass)
+
+		if pass == "" {
+			break
+		}
+
+		ReadSecret("Verify password", "optional", false, p, &veryfy)
+
+		if pass != veryfy {
+			p("{brightWhite}Password did not match! Try again.{/brightWhite}\n")
+		} else {
+			break
 		}
+	}
 
+	ne.Password = pass
+	if pass != "" {
+		ne.PasswordChanged = now
 	}
+	ReadInput("Username", "optional", false, p, &ne.Username)
+	ReadInput("Address", "optional", false, p, &ne.Address)
 }
 
 func descriptionOfField(desc string) string {
@@ -70,6 +103,12 @@ func descriptionOfField(desc string) string {
 func encryptEntry(ent *data.Entry, encrypt types.Encryptor, print types.Printer) error {
 	var err error
 
+	ent.Title, err = encrypt(ent.Title)
+	if err != nil {
+		print("{red}Error in encrypting title{/red} {0}", err)
+		return err
+	}
+
 	ent.Username, err = encrypt(ent.Username)
 	if err != nil {
 		print("{red}Error in encrypting username{/red} {0}", err)
@@ -94,5 +133,41 @@ func encryptEntry(ent *data.Entry, encrypt types.Encryptor, print types.Printer)
 		return err
 	}
 
+	ent.History, err = mapHistory(ent.History, encrypt)
+	if err != nil {
+		print("{red}Error in encrypting password history{/red} {0}", err)
+		return err
+	}
+
+	ent.Fields, err = mapFields(ent.Fields, encrypt)
+	if err != nil {
+		print("{red}Error in encrypting custom fields{/red} {0}", err)
+		return err
+	}
+
+	ent.Folder, err = mapOptional(ent.Folder, encrypt)
+	if err != nil {
+		print("{red}Error in encrypting folder{/red} {0}", err)
+		return err
+	}
+
+	ent.Tags, err = mapTags(ent.Tags, encrypt)
+	if err != nil {
+		print("{red}Error in encrypting tags{/red} {0}", err)
+		return err
+	}
+
+	ent.OTP, err = mapOptional(ent.OTP, encrypt)
+	if err != nil {
+		print("{red}Error in encrypting one-time password secret{/red} {0}", err)
+		return err
+	}
+
+	ent.Attachments, err = mapAttachments(ent.Attachments, encrypt)
+	if err != nil {
+		print("{red}Error in encrypting attachment names{/red} {0}", err)
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,113 @@
+package app
+
+import (
+	"fmt"
+	"squirrel/data"
+	"squirrel/secure"
+	"squirrel/types"
+	"strconv"
+	"time"
+)
+
+// OTPCommand prints the current one-time password of an entry. Counter based
+// codes move the counter on, so each is printed once.
+func OTPCommand(p types.Printer, s data.Store, e types.Encryptor, d types.Decryptor) Command {
+	return func(args ...string) {
+		if len(args) != 1 {
+			p("{red}Wrong arguments{/red}\notp command examples:\n\totp 12\n")
+			return
+		}
+
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			p("{red}Bad ID! {0}{/red}\n", err)
+			return
+		}
+
+		ent, err := s.Load(id)
+		if err != nil {
+			p("{red}Loading entry with ID {0} failed! {1}{/red}\n", id, err)
+			return
+		}
+
+		title, err := d(ent.Title)
+		if err != nil {
+			p("{red}Decrypting entry with ID {0} failed! {1}{/red}\n", id, err)
+			return
+		}
+
+		secret, err := mapOptional(ent.OTP, d)
+		if err != nil {
+			p("{red}Decrypting entry with ID {0} failed! {1}{/red}\n", id, err)
+			return
+		}
+		if secret == "" {
+			p("'{0}' has no one-time password secret.\n", title)
+			return
+		}
+
+		key, err := secure.ParseOTP(secret)
+		if err != nil {
+			p("{red}The one-time password secret of '{0}' is broken! {1}{/red}\n", title, err)
+			return
+		}
+
+		now := time.Now()
+		ent.Accessed = now
+		if key.Kind == secure.HOTP {
+			code := key.HOTP(key.Counter)
+			key.Counter++
+			if ent.OTP, err = e(key.String()); err != nil {
+				p("{red}Encrypting the one-time password secret failed!{/red} {0}\n", err)
+				return
+			}
+			// The counter must move on before the code is shown
+			if err := s.Update(id, ent); err != nil {
+				p("{red}Updating entity failed!{/red} {0}\n", err)
+				return
+			}
+			p("{green}{0}{/green} {gray}counter {1}{/gray}\n", code, key.Counter-1)
+			return
+		}
+
+		code, remaining := key.TOTP(now)
+		p("{green}{0}{/green} {gray}valid for {1} more seconds{/gray}\n", code, remaining)
+		if err := s.Update(id, ent); err != nil {
+			p("{gray}Recording when the entry was used failed: {0}{/gray}\n", err)
+		}
+	}
+}
+
+// readOTP asks for a base32 secret or otpauth:// URI until it parses, and returns
+// it as a URI, or nothing when the answer is empty.
+func readOTP(p types.Printer, name string) string {
+	for {
+		var input string
+		ReadSecret(name, "optional, base32 or otpauth:// URI", false, p, &input)
+		if input == "" {
+			return ""
+		}
+
+		key, err := secure.ParseOTP(input)
+		if err == nil {
+			return key.String()
+		}
+		p("{red}{0}{/red}\n", err)
+	}
+}
+
+// otpSummary describes the one-time passwords of an entry without the secret.
+func otpSummary(entry data.Entry) string {
+	if entry.OTP == "" {
+		return ""
+	}
+
+	key, err := secure.ParseOTP(entry.OTP)
+	if err != nil {
+		return "broken secret"
+	}
+	if key.Kind == secure.HOTP {
+		return fmt.Sprintf("HOTP, %d digits, %v, counter %d", key.Digits, key.Algorithm, key.Counter)
+	}
+	return fmt.Sprintf("TOTP, %d digits, %v, every %ds", key.Digits, key.Algorithm, key.Period)
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"squirrel/data"
+	"squirrel/secure"
+	"strings"
+	"testing"
+)
+
+// The RFC 4226 test secret
+const testOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestOTPCommandTOTP(t *testing.T) {
+	s := testStore(t, data.Entry{Id: 1, Title: "github", OTP: "otpauth://totp/github?secret=" + testOTPSecret + "&digits=8"})
+
+	var out recorder
+	OTPCommand(out.print, s, testEncryptor, testDecryptor)("1")
+
+	printed := out.String()
+	if !strings.Contains(printed, "valid for") || len(strings.Fields(printed)[0]) != len("{green}12345678{/green}") {
+		t.Errorf("Expected an 8 digit code, but got %q", printed)
+	}
+	if stored, _ := s.Load(1); stored.Accessed.IsZero() {
+		t.Errorf("Expected the use to be recorded, but got %+v", stored)
+	}
+}
+
+func TestOTPCommandHOTP(t *testing.T) {
+	s := testStore(t, data.Entry{Id: 1, Title: "bank", OTP: "otpauth://hotp/bank?secret=" + testOTPSecret + "&counter=1"})
+
+	for _, expected := range []string{"287082", "359152"} {
+		var out recorder
+		OTPCommand(out.print, s, testEncryptor, testDecryptor)("1")
+		if printed := out.String(); !strings.Contains(printed, expected) {
+			t.Errorf("Expected %v, but got %q", expected, printed)
+		}
+	}
+
+	stored, _ := s.Load(1)
+	key, err := secure.ParseOTP(strings.TrimPrefix(stored.OTP, testCipherPrefix))
+	if !strings.HasPrefix(stored.OTP, testCipherPrefix) || err != nil || key.Counter != 3 {
+		t.Errorf("Expected the encrypted counter to move on to 3, but got %q (%v)", stored.OTP, err)
+	}
+}
+
+func TestOTPCommandWithoutSecret(t *testing.T) {
+	var out recorder
+	OTPCommand(out.print, testStore(t, testEntries...), testEncryptor, testDecryptor)("1")
+
+	if printed := out.String(); !strings.Contains(printed, "'gmail' has no one-time password secret.") {
+		t.Errorf("Expected a missing secret message, but got %q", printed)
+	}
+}
@@ -1,13 +1,44 @@
 package app
 
 import (
+	"math"
+	"squirrel/data"
 	"squirrel/types"
+	"strings"
 )
 
 var ()
 
-func SearchCommand(p types.Printer, d types.Decryptor) Command {
+func SearchCommand(p types.Printer, s data.Store, d types.Decryptor) Command {
 	return func(args ...string) {
+		if len(args) == 0 {
+			p("{red}Nothing to search for!{/red}\nsearch command examples:\n\tsearch gmail\n\tsearch work mail\n")
+			return
+		}
 
+		if count, _ := s.Count(); count == 0 {
+			p("There are no entries.\n")
+			return
+		}
+
+		// Titles are encrypted, so they are matched after decrypting them in memory
+		entries, err := s.List(data.ByTitle, math.MaxInt, d)
+		if err != nil {
+			p("{red}Error in loading entries!{/red}: {0}\n", err)
+			return
+		}
+
+		query := strings.ToLower(strings.Join(args, " "))
+		found := 0
+		for _, entry := range entries {
+			if strings.Contains(strings.ToLower(entry.Title), query) {
+				found++
+				p("{0}. {1} \tID: {2} \tUsername: {3}\n", found, entry.Title, entry.Id, entry.Username)
+			}
+		}
+
+		if found == 0 {
+			p("No entry matches '{0}'.\n", query)
+		}
 	}
 }
@@ -0,0 +1,38 @@
+package app
+
+import (
+	"squirrel/data"
+	"strings"
+	"testing"
+)
+
+func TestSearchCommand(t *testing.T) {
+	var out recorder
+	SearchCommand(out.print, testStore(t, testEntries...), testDecryptor)("MAIL")
+
+	printed := out.String()
+	if !strings.Contains(printed, "1. gmail") || !strings.Contains(printed, "2. work mail") {
+		t.Errorf("Expected both mail entries, but got %q", printed)
+	}
+	if strings.Contains(printed, "aws") {
+		t.Errorf("Expected aws not to match, but got %q", printed)
+	}
+}
+
+func TestSearchCommandNoMatch(t *testing.T) {
+	var out recorder
+	SearchCommand(out.print, testStore(t, testEntries...), testDecryptor)("bank")
+
+	if printed := out.String(); printed != "No entry matches 'bank'.\n" {
+		t.Errorf("Unexpected output %q", printed)
+	}
+}
+
+func TestSearchCommandEmptyStore(t *testing.T) {
+	var out recorder
+	SearchCommand(out.print, data.NewMemoryStore(), testDecryptor)("gmail")
+
+	if printed := out.String(); printed != "There are no entries.\n" {
+		t.Errorf("Unexpected output %q", printed)
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"squirrel/data"
 	"squirrel/types"
 	"strconv"
+	"strings"
+	"time"
 )
 
 var ()
 
-func ShowCommand(p types.Printer, d types.Decryptor) Command {
+func ShowCommand(p types.Printer, s data.Store, d types.Decryptor) Command {
 	return func(args ...string) {
 		var id int64
 		if len(args) > 0 {
@@ -24,21 +26,47 @@ func ShowCommand(p types.Printer, d types.Decryptor) Command {
 			id = readId(p)
 		}
 
-		ent, err := data.LoadEntry(id)
+		ent, err := s.Load(id)
 		if err != nil {
 			p("{red}Loading entry with ID {0} failed! {1}{/red}\n", id, err)
 			return
 		}
+		stored := ent
 
 		decrypt(&ent, d)
 
-		display(ent, p)
+		if len(args) > 1 {
+			if args[1] != "reveal" || len(args) < 3 {
+				p("{red}Wrong arguments{/red}\nshow command examples:\n\tshow 12\n\tshow 12 reveal PIN\n")
+				return
+			}
+
+			name := strings.Join(args[2:], " ")
+			i, ok := findField(ent.Fields, name)
+			if !ok {
+				p("{red}'{0}' has no field {1}.{/red}\n", ent.Title, name)
+				return
+			}
+			PrintSecret(p, ent.Fields[i].Name, ent.Fields[i].Value, secondsToReveal)
+		} else {
+			display(ent, p)
+		}
+
+		stored.Accessed = time.Now()
+		if err := s.Update(id, stored); err != nil {
+			p("{gray}Recording when the entry was used failed: {0}{/gray}\n", err)
+		}
 	}
 }
 
 func decrypt(ent *data.Entry, d types.Decryptor) error {
 	var error error
 
+	ent.Title, error = d(ent.Title)
+	if error != nil {
+		return error
+	}
+
 	ent.Username, error = d(ent.Username)
 	if error != nil {
 		return error
@@ -59,5 +87,35 @@ func decrypt(ent *data.Entry, d types.Decryptor) error {
 		return error
 	}
 
+	ent.History, error = mapHistory(ent.History, d)
+	if error != nil {
+		return error
+	}
+
+	ent.Fields, error = mapFields(ent.Fields, d)
+	if error != nil {
+		return error
+	}
+
+	ent.Folder, error = mapOptional(ent.Folder, d)
+	if error != nil {
+		return error
+	}
+
+	ent.Tags, error = mapTags(ent.Tags, d)
+	if error != nil {
+		return error
+	}
+
+	ent.OTP, error = mapOptional(ent.OTP, d)
+	if error != nil {
+		return error
+	}
+
+	ent.Attachments, error = mapAttachments(ent.Attachments, d)
+	if error != nil {
+		return error
+	}
+
 	return nil
 }
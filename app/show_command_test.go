@@ -0,0 +1,101 @@
+package app
+
+import (
+	"squirrel/data"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShowCommand(t *testing.T) {
+	var out recorder
+	ShowCommand(out.print, testStore(t, testEntries...), testDecryptor)("2")
+
+	printed := out.String()
+	for _, expected := range []string{"aws", "alice", "secret2", "root account"} {
+		if !strings.Contains(printed, expected) {
+			t.Errorf("Expected %q in %q", expected, printed)
+		}
+	}
+	if strings.Contains(printed, testCipherPrefix) {
+		t.Errorf("Expected every field decrypted, but got %q", printed)
+	}
+}
+
+func TestShowCommandMissingEntry(t *testing.T) {
+	var out recorder
+	ShowCommand(out.print, testStore(t, testEntries...), testDecryptor)("42")
+
+	if printed := out.String(); !strings.Contains(printed, "Loading entry with ID 42 failed!") {
+		t.Errorf("Expected a loading error, but got %q", printed)
+	}
+}
+
+func TestShowCommandBadId(t *testing.T) {
+	var out recorder
+	ShowCommand(out.print, testStore(t, testEntries...), testDecryptor)("abc")
+
+	if printed := out.String(); !strings.Contains(printed, "Bad ID!") {
+		t.Errorf("Expected a bad ID error, but got %q", printed)
+	}
+}
+
+func TestShowCommandRecordsUse(t *testing.T) {
+	s := testStore(t, testEntries...)
+	before := time.Now()
+
+	var out recorder
+	ShowCommand(out.print, s, testDecryptor)("1")
+	if strings.Contains(out.String(), "Last used") {
+		t.Errorf("Expected no last use for an entry never shown, but got %q", out.String())
+	}
+
+	stored, _ := s.Load(1)
+	if stored.Accessed.Before(before) {
+		t.Errorf("Expected the use to be recorded, but got %v", stored.Accessed)
+	}
+	if stored.Password != testCipherPrefix+"secret1" {
+		t.Errorf("Expected the entry to stay encrypted, but got %+v", stored)
+	}
+
+	out.Reset()
+	ShowCommand(out.print, s, testDecryptor)("1")
+	if !strings.Contains(out.String(), "Last used") {
+		t.Errorf("Expected the last use to be shown, but got %q", out.String())
+	}
+}
+
+func TestShowCommandMasksSecretFields(t *testing.T) {
+	entry := data.Entry{Id: 1, Title: "bank", Fields: []data.CustomField{
+		{Name: "PIN", Value: "4321", Secret: true},
+		{Name: "Branch", Value: "downtown"},
+	}}
+	s := testStore(t, entry)
+
+	stored, _ := s.Load(1)
+	if stored.Fields[0].Name != testCipherPrefix+"PIN" || stored.Fields[0].Value != testCipherPrefix+"4321" {
+		t.Errorf("Expected the custom fields to be encrypted, but got %+v", stored.Fields)
+	}
+
+	var out recorder
+	ShowCommand(out.print, s, testDecryptor)("1")
+
+	printed := out.String()
+	for _, expected := range []string{"PIN", maskedValue, "Branch", "downtown"} {
+		if !strings.Contains(printed, expected) {
+			t.Errorf("Expected %q in %q", expected, printed)
+		}
+	}
+	if strings.Contains(printed, "4321") {
+		t.Errorf("Expected the secret field to be masked, but got %q", printed)
+	}
+}
+
+func TestShowCommandRevealUnknownField(t *testing.T) {
+	var out recorder
+	ShowCommand(out.print, testStore(t, testEntries...), testDecryptor)("1", "reveal", "PIN")
+
+	if printed := out.String(); !strings.Contains(printed, "'gmail' has no field PIN.") {
+		t.Errorf("Expected a missing field error, but got %q", printed)
+	}
+}
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"fmt"
+	"squirrel/data"
+	"squirrel/types"
+	"strconv"
+	"time"
+)
+
+// DefaultTrashDays is how long entries stay in the trash before they are purged.
+const DefaultTrashDays = 30
+
+func TrashCommand(p types.Printer, s data.Store, d types.Decryptor, days func() int) Command {
+	return func(args ...string) {
+		trashed, err := s.ListTrash(d)
+		if err != nil {
+			p("{red}Error in loading the trash!{/red}: {0}\n", err)
+			return
+		}
+
+		p("There are {0} entries in the trash.\n", len(trashed))
+		for i, entry := range trashed {
+			p("{0}. {1} \tID: {2} \tUsername: {3} \t{gray}deleted {4}{/gray}\n", i+1, entry.Title, entry.Id, entry.Username, entry.Deleted.Local().Format("2006-01-02 15:04"))
+		}
+
+		if len(trashed) > 0 && days() > 0 {
+			p("{gray}Entries are purged {0} days after they were deleted.{/gray}\n", days())
+		}
+	}
+}
+
+func UndeleteCommand(p types.Printer, s data.Store) Command {
+	return func(args ...string) {
+		if len(args) != 1 {
+			p("{red}Which entry?{/red}\nundelete command examples:\n\tundelete 12\n")
+			return
+		}
+
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			p("{red}Bad ID! {0}{/red}\n", err)
+			return
+		}
+
+		if err := s.Undelete(id); err != nil {
+			p("{red}Undeleting entry with ID {0} failed! {1}{/red}\n", id, err)
+			return
+		}
+
+		p("{green}Entry {0} is back.{/green}\n", id)
+	}
+}
+
+func PurgeCommand(p types.Printer, s data.Store, d types.Decryptor) Command {
+	return func(args ...string) {
+		if len(args) > 1 {
+			p("{red}Wrong arguments{/red}\npurge command examples:\n\tpurge 12\n\tpurge all\n")
+			return
+		}
+
+		trashed, err := s.ListTrash(d)
+		if err != nil {
+			p("{red}Error in loading the trash!{/red}: {0}\n", err)
+			return
+		}
+		if len(trashed) == 0 {
+			p("The trash is empty.\n")
+			return
+		}
+
+		if len(args) == 0 || args[0] == "all" {
+			if !GetYesNoInput(p, fmt.Sprintf("Delete all %d entries in the trash for good", len(trashed))) {
+				return
+			}
+
+			// Everything deleted until now
+			purged, err := s.PurgeTrash(time.Now().Add(time.Second))
+			if err != nil {
+				p("{red}Emptying the trash failed!{/red} {0}\n", err)
+				return
+			}
+
+			p("{green}Deleted {0} entries for good.{/green}\n", purged)
+			return
+		}
+
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			p("{red}Bad ID! {0}{/red}\n", err)
+			return
+		}
+
+		for _, entry := range trashed {
+			if entry.Id != id {
+				continue
+			}
+
+			if !GetYesNoInput(p, fmt.Sprintf("Delete entry '%v' for good", entry.Title)) {
+				return
+			}
+			if err := s.Delete(id); err != nil {
+				p("{red}Deleting entry with ID {0} failed! {1}{/red}\n", id, err)
+				return
+			}
+
+			p("{green}Entry '{0}' deleted for good.{/green}\n", entry.Title)
+			return
+		}
+
+		p("{red}Entry {0} is not in the trash.{/red}\n", id)
+	}
+}
+
+// PurgeExpiredTrash deletes the entries that were in the trash for more than the
+// given number of days, after calling before. No days means they are kept forever.
+func PurgeExpiredTrash(p types.Printer, s data.Store, d types.Decryptor, days int, now time.Time, before func() error) error {
+	if days <= 0 {
+		return nil
+	}
+
+	cutoff := now.AddDate(0, 0, -days)
+
+	trashed, err := s.ListTrash(d)
+	if err != nil {
+		return err
+	}
+
+	expired := 0
+	for _, entry := range trashed {
+		if entry.Deleted.Before(cutoff) {
+			expired++
+		}
+	}
+	if expired == 0 {
+		return nil
+	}
+
+	if err := before(); err != nil {
+		return err
+	}
+
+	purged, err := s.PurgeTrash(cutoff)
+	if err != nil {
+		return err
+	}
+
+	p("{gray}Purged {0} entries that were in the trash for more than {1} days.{/gray}\n", purged, days)
+	return nil
+}
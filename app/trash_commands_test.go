@@ -0,0 +1,68 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrashAndUndelete(t *testing.T) {
+	s := testStore(t, testEntries...)
+	s.Trash(2, time.Now())
+
+	var out recorder
+	TrashCommand(out.print, s, testDecryptor, func() int { return 30 })()
+	if !strings.Contains(out.String(), "There are 1 entries in the trash.") || !strings.Contains(out.String(), "1. aws \tID: 2") {
+		t.Errorf("Unexpected trash listing:\n%v", out.String())
+	}
+
+	out.Reset()
+	UndeleteCommand(out.print, s)("2")
+	if !strings.Contains(out.String(), "Entry 2 is back.") {
+		t.Errorf("Unexpected output:\n%v", out.String())
+	}
+	if _, err := s.Load(2); err != nil {
+		t.Errorf("Expected entry 2 to be back, but got %v", err)
+	}
+
+	out.Reset()
+	UndeleteCommand(out.print, s)("2")
+	if !strings.Contains(out.String(), "entry is not in the trash") {
+		t.Errorf("Expected undeleting an entry outside the trash to fail:\n%v", out.String())
+	}
+}
+
+func TestPurgeExpiredTrash(t *testing.T) {
+	now := time.Now()
+	s := testStore(t, testEntries...)
+	s.Trash(1, now.AddDate(0, 0, -31))
+	s.Trash(2, now.AddDate(0, 0, -29))
+
+	backups := 0
+	backup := func() error {
+		backups++
+		return nil
+	}
+
+	var out recorder
+	if err := PurgeExpiredTrash(out.print, s, testDecryptor, 30, now, backup); err != nil {
+		t.Fatalf("PurgeExpiredTrash failed: %v", err)
+	}
+
+	if trashed, _ := s.ListTrash(testDecryptor); len(trashed) != 1 || trashed[0].Id != 2 {
+		t.Errorf("Expected only entry 2 left in the trash, but got %+v", trashed)
+	}
+	if backups != 1 || !strings.Contains(out.String(), "Purged 1 entries") {
+		t.Errorf("Expected one backup before purging, but got %v:\n%v", backups, out.String())
+	}
+
+	// Nothing expired, nothing to back up
+	if err := PurgeExpiredTrash(out.print, s, testDecryptor, 30, now, backup); err != nil || backups != 1 {
+		t.Errorf("Expected no backup without expired entries, but got %v (%v)", backups, err)
+	}
+
+	// Kept until purged by hand
+	if err := PurgeExpiredTrash(out.print, s, testDecryptor, 0, now.AddDate(1, 0, 0), backup); err != nil || backups != 1 {
+		t.Errorf("Expected no purge without retention, but got %v (%v)", backups, err)
+	}
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"fmt"
+	"squirrel/data"
+	"squirrel/secure"
+	"squirrel/types"
+	"strconv"
+	"time"
+)
+
+// DefaultUnlockTime is the time kdf-benchmark aims for unless given, in seconds.
+const DefaultUnlockTime = 1.0
+
+// DefaultBenchmarkMemory is the most memory kdf-benchmark picks unless given, in MiB.
+const DefaultBenchmarkMemory = 1024
+
+func VaultsCommand(p types.Printer, home func() string, current func() string) Command {
+	return func(args ...string) {
+		names, err := data.ListVaults(home())
+		if err != nil {
+			p("{red}Error in listing vaults!{/red}: {0}\n", err)
+			return
+		}
+
+		for _, name := range names {
+			dir, err := data.VaultDir(home(), name)
+			if err != nil {
+				continue
+			}
+
+			marker := "  "
+			if name == current() {
+				marker = "{brightGreen}*{/brightGreen} "
+			}
+
+			if data.HasPassVerifyFile(&data.Vault{Dir: dir}) {
+				p(marker+"{0} \t{gray}{1}{/gray}\n", name, dir)
+			} else {
+				p(marker+"{0} \t{gray}(not initialized){/gray}\n", name)
+			}
+		}
+	}
+}
+
+func OpenCommand(p types.Printer, open func(name string) error) Command {
+	return func(args ...string) {
+		if len(args) != 1 {
+			p("{red}Which vault?{/red}\nopen command examples:\n\topen work\n\topen default\n")
+			return
+		}
+
+		if err := open(args[0]); err != nil {
+			p("{red}Can't open vault {0}!{/red} {1}\n", args[0], err)
+		}
+	}
+}
+
+func CloseCommand(p types.Printer, current func() string, close func()) Command {
+	return func(args ...string) {
+		name := current()
+		if name == "" {
+			p("No vault is open.\n")
+			return
+		}
+
+		close()
+		p("Vault {0} is closed.\n", name)
+	}
+}
+
+// PasswdCommand changes the master password of the open vault. check tells whether
+// the current password is right, change re-encrypts the vault under the new one.
+func PasswdCommand(p types.Printer, check func(current []byte) error, change func(current, next []byte) error) Command {
+	return func(args ...string) {
+		if len(args) != 0 {
+			p("{red}Wrong arguments{/red}\npasswd command examples:\n\tpasswd\n")
+			return
+		}
+
+		var current, next, verify string
+		ReadSecret("Current password", "", true, p, &current)
+		if err := check([]byte(current)); err != nil {
+			p("{red}Can't change the password!{/red} {0}\n", err)
+			return
+		}
+
+		for {
+			ReadSecret("New password", "", true, p, &next)
+			ReadSecret("Verify password", "", true, p, &verify)
+			if next == verify {
+				break
+			}
+			p("Password did not match!\n")
+		}
+
+		p("{gray}Re-encrypting the vault...{/gray}\n")
+		if err := change([]byte(current), []byte(next)); err != nil {
+			p("{red}Changing the password failed!{/red} {0}\n", err)
+			return
+		}
+
+		p("{green}The master password is changed.{/green} Backups taken before keep the old one.\n")
+	}
+}
+
+// RekeyCommand re-encrypts the vault under a key derived with the default Argon2id
+// parameters, keeping the master password. It moves older vaults to Argon2id.
+func RekeyCommand(p types.Printer, current func() (secure.KDFParams, error), rekey func(secure.KDFParams) error) Command {
+	return func(args ...string) {
+		if len(args) != 0 {
+			p("{red}Wrong arguments{/red}\nrekey command examples:\n\trekey\n")
+			return
+		}
+
+		params, err := secure.NewKDFParams()
+		if err != nil {
+			p("{red}Can't generate a salt!{/red} {0}\n", err)
+			return
+		}
+		confirmRekey(p, current, rekey, params)
+	}
+}
+
+// KDFBenchmarkCommand measures Argon2id on this machine, picks the parameters that
+// unlock in about the given seconds and offers to re-key the vault with them.
+func KDFBenchmarkCommand(p types.Printer, tune func(target time.Duration, maxMemory uint32) (secure.KDFParams, time.Duration), current func() (secure.KDFParams, error), rekey func(secure.KDFParams) error) Command {
+	return func(args ...string) {
+		if len(args) > 2 {
+			p("{red}Wrong arguments{/red}\nkdf-benchmark command examples:\n\tkdf-benchmark\n\tkdf-benchmark 2\n\tkdf-benchmark 0.5 256\n")
+			return
+		}
+
+		seconds := DefaultUnlockTime
+		if len(args) > 0 {
+			n, err := strconv.ParseFloat(args[0], 64)
+			if err != nil || n < 0.1 || n > 60 {
+				p("{red}Bad unlock time {0}, give seconds from 0.1 to 60.{/red}\n", args[0])
+				return
+			}
+			seconds = n
+		}
+
+		memory := DefaultBenchmarkMemory
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < secure.MinArgon2Memory>>10 || n > secure.MaxArgon2Memory>>10 {
+				p("{red}Bad memory {0}, give MiB from {1} to {2}.{/red}\n", args[1], secure.MinArgon2Memory>>10, secure.MaxArgon2Memory>>10)
+				return
+			}
+			memory = n
+		}
+
+		p("{gray}Measuring Argon2id on this machine, this takes a few seconds...{/gray}\n")
+		params, took := tune(time.Duration(seconds*float64(time.Second)), uint32(memory)<<10)
+		p("{0} unlocks in {1}s here.\n", params.Describe(), fmt.Sprintf("%.2f", took.Seconds()))
+
+		confirmRekey(p, current, rekey, params)
+	}
+}
+
+// confirmRekey shows how the key of the vault is derived now and with params, and
+// re-keys the vault when that is confirmed.
+func confirmRekey(p types.Printer, current func() (secure.KDFParams, error), rekey func(secure.KDFParams) error, params secure.KDFParams) {
+	old, err := current()
+	if err != nil {
+		p("{red}Can't read the vault header!{/red} {0}\n", err)
+		return
+	}
+
+	p("The vault key is derived with {0}.\n", old.Describe())
+	if !GetYesNoInput(p, fmt.Sprintf("Re-encrypt the vault with %v", params.Describe())) {
+		return
+	}
+
+	p("{gray}Re-encrypting the vault...{/gray}\n")
+	if err := rekey(params); err != nil {
+		p("{red}Re-keying the vault failed!{/red} {0}\n", err)
+		return
+	}
+
+	p("{green}The vault key is derived with {0} now.{/green}\n", params.Describe())
+}
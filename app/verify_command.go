@@ -0,0 +1,36 @@
+package app
+
+import (
+	"squirrel/data"
+	"squirrel/types"
+)
+
+func VerifyCommand(p types.Printer, v *data.Vault) Command {
+	return func(args ...string) {
+		report, err := data.VerifyDataFile(v)
+		if err != nil {
+			p("{red}Verifying the data file failed!{/red} {0}\n", err)
+			return
+		}
+
+		PrintIntegrityReport(p, report)
+	}
+}
+
+// PrintIntegrityReport tells whether the data file passed its integrity check, and
+// which records failed it.
+func PrintIntegrityReport(p types.Printer, report data.IntegrityReport) {
+	if report.OK() {
+		p("{green}The data file is intact. All {0} records verified.{/green}\n", report.Records)
+		return
+	}
+
+	p("{red}The data file was modified outside squirrel! {0} problems found:{/red}\n", len(report.Problems))
+	for _, problem := range report.Problems {
+		if problem.Record == 0 {
+			p("  offset {0}: {1}\n", problem.Offset, problem.Reason)
+			continue
+		}
+		p("  offset {0}, record {1} (ID {2}): {3}\n", problem.Offset, problem.Record, problem.Id, problem.Reason)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"squirrel/app"
+	"squirrel/data"
+	l "squirrel/log"
+)
+
+// keepBackups is how many snapshots are kept for each vault. With 0 no snapshots
+// are taken before changes, and none are removed.
+var keepBackups = data.DefaultKeepBackups
+
+// withBackup takes a snapshot of the open vault before running a command that
+// changes it. The command doesn't run when the snapshot fails.
+func withBackup(command app.Command) app.Command {
+	return func(args ...string) {
+		if err := autoBackup(); err != nil {
+			l.Println("{red}Backing up the vault failed, nothing was changed!{/red} {0}", err)
+			return
+		}
+
+		command(args...)
+	}
+}
+
+// autoBackup takes the snapshot of the open vault before a change, unless
+// snapshots are turned off.
+func autoBackup() error {
+	if keepBackups <= 0 {
+		return nil
+	}
+	_, err := data.Backup(vault, keepBackups)
+	return err
+}
+
+func backupVault() (string, error) {
+	return data.Backup(vault, keepBackups)
+}
+
+// restoreBackup asks for the master password of the snapshot, checks the snapshot
+// and then replaces the open vault with it. The vault is unlocked with the key of
+// the snapshot afterwards, which differs if the password changed since.
+func restoreBackup(name string) error {
+	snapshot, err := data.BackupVault(vault, name)
+	if err != nil {
+		return err
+	}
+
+	header, err := data.LoadVaultHeader(snapshot)
+	if err != nil {
+		return err
+	}
+
+	l.Println("Enter the master password of the vault at the time of the snapshot.")
+	key, pass, _, err := unlock(header)
+	if err != nil {
+		return err
+	}
+
+	snapshot.SetKey(key)
+	if data.HasDataFile(snapshot) {
+		report, err := data.VerifyDataFile(snapshot)
+		if err != nil {
+			return err
+		}
+		if !report.OK() {
+			app.PrintIntegrityReport(l.Print, report)
+			return data.ErrIntegrity
+		}
+	}
+
+	if err := data.RestoreBackup(vault, name, keepBackups); err != nil {
+		return err
+	}
+
+	clear(encryptionKey)
+	clear(password)
+	encryptionKey = key
+	password = pass
+	vault.SetKey(key)
+	state = app.ReadState(data.NewFileStore(vault))
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Steps of writeFileAtomic, in order.
+const (
+	stepCreate  = "create"
+	stepWrite   = "write"
+	stepSync    = "sync"
+	stepClose   = "close"
+	stepRename  = "rename"
+	stepSyncDir = "sync-dir"
+)
+
+// interrupt is called before every step of writeFileAtomic with the step and the
+// file written. Tests set it to simulate a crash at that step; it is nil otherwise.
+var interrupt func(step, fileName string) error
+
+// InterruptWrites makes every step of writing a vault file call interrupt first,
+// until the returned func is called. The steps are create, write, sync, close,
+// rename and sync-dir. Tests of other packages use it to simulate a crash: an
+// error fails the write, a panic stops everything where it is.
+func InterruptWrites(f func(step, fileName string) error) (restore func()) {
+	interrupt = f
+	return func() { interrupt = nil }
+}
+
+// writeFileAtomic replaces fileName with what write produces. The content goes to a
+// uniquely named temp file in the same directory, which is synced, renamed over
+// fileName, and then the directory is synced. A crash at any point leaves either
+// the old file or the new one, never a mix of both.
+func writeFileAtomic(fileName string, perm os.FileMode, write func(*os.File) error) error {
+	dir := filepath.Dir(fileName)
+
+	if err := checkpoint(stepCreate, fileName); err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(dir, "."+filepath.Base(fileName)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	renamed := false
+	defer func() {
+		if !renamed {
+			temp.Close()
+			os.Remove(temp.Name())
+		}
+	}()
+
+	if err := checkpoint(stepWrite, fileName); err != nil {
+		return err
+	}
+	if err := temp.Chmod(perm); err != nil {
+		return err
+	}
+	if err := write(temp); err != nil {
+		return err
+	}
+
+	if err := checkpoint(stepSync, fileName); err != nil {
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		return err
+	}
+
+	if err := checkpoint(stepClose, fileName); err != nil {
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	if err := checkpoint(stepRename, fileName); err != nil {
+		return err
+	}
+	if err := os.Rename(temp.Name(), fileName); err != nil {
+		return err
+	}
+	renamed = true
+
+	if err := checkpoint(stepSyncDir, fileName); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// renameAtomic renames oldName to newName and syncs the directory.
+func renameAtomic(oldName, newName string) error {
+	if err := os.Rename(oldName, newName); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(newName))
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	// Directories can't be opened for syncing on Windows, renames are durable there
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+func checkpoint(step, fileName string) error {
+	if interrupt == nil {
+		return nil
+	}
+	return interrupt(step, fileName)
+}
@@ -0,0 +1,200 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var errCrash = errors.New("simulated crash")
+
+var atomicSteps = []string{stepCreate, stepWrite, stepSync, stepClose, stepRename, stepSyncDir}
+
+// crashAt makes writeFileAtomic fail at the given step until the returned func is called.
+func crashAt(step string) func() {
+	return InterruptWrites(func(s, _ string) error {
+		if s == step {
+			return errCrash
+		}
+		return nil
+	})
+}
+
+func seedEntries(t *testing.T, v *Vault, n int64) []byte {
+	for i := int64(1); i <= n; i++ {
+		entry := Entry{
+			Id:       i,
+			Title:    "Title " + fmt.Sprint(i),
+			Username: "user" + fmt.Sprint(i),
+			Password: "pass" + fmt.Sprint(i),
+		}
+		if err := SaveEntry(v, entry); err != nil {
+			t.Fatalf("SaveEntry failed for ID %v: %v", i, err)
+		}
+	}
+
+	raw, err := os.ReadFile(v.path(dataFile))
+	if err != nil {
+		t.Fatalf("Reading data file failed: %v", err)
+	}
+	return raw
+}
+
+// withoutFlag writes the entries of the data file again as if flag was never set.
+func withoutFlag(t *testing.T, v *Vault, flag Flags) []byte {
+	entries, err := readAllEntries(v)
+	if err != nil {
+		t.Fatalf("Reading entries failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	header := newDataHeader(v)
+	header.Flags &^= flag
+	if err := writeAllEntries(v, &buf, header, entries); err != nil {
+		t.Fatalf("Writing entries failed: %v", err)
+	}
+	if err := os.WriteFile(v.path(dataFile), buf.Bytes(), 0600); err != nil {
+		t.Fatalf("Writing data file failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func tempFiles(t *testing.T, v *Vault) []string {
+	matches, err := filepath.Glob(v.path(".*.tmp-*"))
+	if err != nil {
+		t.Fatalf("Glob failed: %v", err)
+	}
+	return matches
+}
+
+func TestMutationsSurviveInterruption(t *testing.T) {
+	mutations := map[string]func(v *Vault) error{
+		"SaveEntry":           func(v *Vault) error { return SaveEntry(v, Entry{Id: 100, Title: "new"}) },
+		"DeleteEntry":         func(v *Vault) error { return DeleteEntry(v, 2) },
+		"DeleteEntryInMemory": func(v *Vault) error { return DeleteEntryInMemory(v, 2) },
+		"UpdateEntry":         func(v *Vault) error { return UpdateEntry(v, 2, Entry{Id: 2, Title: "updated"}) },
+		"UpdateEntryInMemory": func(v *Vault) error { return UpdateEntryInMemory(v, 2, Entry{Id: 2, Title: "updated"}) },
+		"RewriteEntries":      func(v *Vault) error { return RewriteEntries(v, func(e *Entry) error { e.Notes = "x"; return nil }) },
+		"MigrateFeature": func(v *Vault) error {
+			return MigrateFeature(v, FlagEncryptedTitles, func(e *Entry) error { e.Notes = "x"; return nil })
+		},
+	}
+
+	for name, mutate := range mutations {
+		for _, step := range atomicSteps {
+			t.Run(name+"/"+step, func(t *testing.T) {
+				v := testVault(t)
+
+				original := seedEntries(t, v, 3)
+				if name == "MigrateFeature" {
+					original = withoutFlag(t, v, FlagEncryptedTitles)
+				}
+
+				restore := crashAt(step)
+				err := mutate(v)
+				restore()
+
+				if !errors.Is(err, errCrash) {
+					t.Fatalf("Expected the simulated crash, but got %v", err)
+				}
+
+				if leftovers := tempFiles(t, v); len(leftovers) > 0 {
+					t.Errorf("Temp files were left behind: %v", leftovers)
+				}
+
+				after, err := os.ReadFile(v.path(dataFile))
+				if err != nil {
+					t.Fatalf("The data file is gone: %v", err)
+				}
+
+				if step == stepSyncDir {
+					// The rename already happened, the new content must be complete
+					if bytes.Equal(after, original) {
+						t.Error("Expected the new content after the rename")
+					}
+					if _, err := CountEntries(v); err != nil {
+						t.Errorf("New content is not readable: %v", err)
+					}
+				} else if !bytes.Equal(after, original) {
+					t.Error("The original data file was modified")
+				}
+			})
+		}
+	}
+}
+
+func TestVaultHeaderSurvivesInterruption(t *testing.T) {
+	v := testVault(t)
+
+	if err := SaveVaultHeader(v, VaultHeader{Verifier: "original"}); err != nil {
+		t.Fatalf("SaveVaultHeader failed: %v", err)
+	}
+
+	for _, step := range atomicSteps[:len(atomicSteps)-1] {
+		restore := crashAt(step)
+		err := SaveVaultHeader(v, VaultHeader{Verifier: "replacement"})
+		restore()
+
+		if !errors.Is(err, errCrash) {
+			t.Fatalf("%v: expected the simulated crash, but got %v", step, err)
+		}
+
+		header, err := LoadVaultHeader(v)
+		if err != nil {
+			t.Fatalf("%v: LoadVaultHeader failed: %v", step, err)
+		}
+		if header.Verifier != "original" {
+			t.Errorf("%v: the vault header was modified", step)
+		}
+	}
+}
+
+func TestStrayTempFileIsIgnored(t *testing.T) {
+	v := testVault(t)
+
+	seedEntries(t, v, 2)
+
+	// A real crash doesn't get to clean up its temp file
+	stray := v.path("." + dataFile + ".tmp-crashed")
+	if err := os.WriteFile(stray, []byte("half written"), 0600); err != nil {
+		t.Fatalf("Writing stray file failed: %v", err)
+	}
+
+	if err := UpdateEntry(v, 1, Entry{Id: 1, Title: "updated"}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
+
+	entry, err := LoadEntry(v, 1)
+	if err != nil {
+		t.Fatalf("LoadEntry failed: %v", err)
+	}
+	if entry.Title != "updated" {
+		t.Errorf("Unexpected title %v", entry.Title)
+	}
+
+	raw, err := os.ReadFile(stray)
+	if err != nil || string(raw) != "half written" {
+		t.Error("The stray temp file should not be reused")
+	}
+}
+
+func TestDeleteMissingEntryKeepsFile(t *testing.T) {
+	v := testVault(t)
+
+	original := seedEntries(t, v, 2)
+
+	if err := DeleteEntry(v, 42); err != ErrEntryNotFound {
+		t.Fatalf("Expected ErrEntryNotFound, but got %v", err)
+	}
+
+	after, _ := os.ReadFile(v.path(dataFile))
+	if !bytes.Equal(after, original) {
+		t.Error("The data file was modified")
+	}
+	if leftovers := tempFiles(t, v); len(leftovers) > 0 {
+		t.Errorf("Temp files were left behind: %v", leftovers)
+	}
+}
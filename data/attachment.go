@@ -0,0 +1,177 @@
+package data
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var ErrAttachmentNotFound = errors.New("attachment not found")
+var ErrAttachmentTooLarge = errors.New("attachment is too large")
+
+// attachmentsDir holds the encrypted contents of the attachments of a vault, one
+// file each. The records only name them.
+const attachmentsDir = "attachments"
+
+// MaxAttachmentSize is the largest file that can be attached, in bytes.
+const MaxAttachmentSize = 16 << 20
+
+// maxStoredAttachmentSize bounds an encrypted attachment file: the hex encoding of
+// the largest attachment with room for the cipher overhead.
+const maxStoredAttachmentSize = 2 * (MaxAttachmentSize + 1024)
+
+var attachmentFilePattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// attachmentGrace is how old an attachment file must be before a sweep removes
+// it. A newer one may belong to an entry another process is about to save.
+const attachmentGrace = time.Hour
+
+// Attachment is a file kept with an entry. Name and Sum, the hex SHA-256 of the
+// contents, are encrypted; the contents are stored encrypted in File.
+type Attachment struct {
+	Name string
+	File string
+	Size int64
+	Sum  string
+}
+
+// SaveAttachment stores encrypted contents in a new file of the attachment store
+// and returns its name. Attachment files are never changed. They are kept as long
+// as the data file or a snapshot refers to them, see sweepAttachments.
+func SaveAttachment(v *Vault, contents string) (string, error) {
+	if len(contents) > maxStoredAttachmentSize {
+		return "", ErrAttachmentTooLarge
+	}
+
+	release, err := v.lock(true)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	if err := os.MkdirAll(v.path(attachmentsDir), 0700); err != nil {
+		return "", err
+	}
+
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	file := hex.EncodeToString(id[:])
+
+	err = writeFileAtomic(filepath.Join(v.path(attachmentsDir), file), filePerm, func(f *os.File) error {
+		_, err := io.WriteString(f, contents)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// LoadAttachment returns the encrypted contents stored in file.
+func LoadAttachment(v *Vault, file string) (string, error) {
+	if !attachmentFilePattern.MatchString(file) {
+		return "", fmt.Errorf("%w: bad file name %q", ErrAttachmentNotFound, file)
+	}
+
+	release, err := v.lock(false)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	f, err := os.Open(filepath.Join(v.path(attachmentsDir), file))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %v is missing", ErrAttachmentNotFound, file)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	contents, err := io.ReadAll(io.LimitReader(f, maxStoredAttachmentSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(contents) > maxStoredAttachmentSize {
+		return "", ErrAttachmentTooLarge
+	}
+	return string(contents), nil
+}
+
+// sweep runs sweepAttachments after a change, and tells v.Warn when it fails.
+func (v *Vault) sweep() {
+	if _, err := sweepAttachments(v); err != nil && v.Warn != nil {
+		v.Warn(fmt.Errorf("removing unused attachment files failed: %w", err))
+	}
+}
+
+// sweepAttachments removes the attachment files that neither the data file nor
+// any snapshot refers to, and returns how many it removed. Nothing is removed when
+// one of the data files can't be read. It runs after the changes that may leave
+// files unused, which don't fail when it does: what it misses goes with the next
+// sweep. Failures are passed to Warn all the same, as a sweep that keeps failing
+// would keep every file around.
+func sweepAttachments(v *Vault) (int, error) {
+	release, err := v.lock(true)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	files, err := os.ReadDir(v.path(attachmentsDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	vaults := []*Vault{v}
+	snapshots, err := ListBackups(v)
+	if err != nil {
+		return 0, err
+	}
+	for _, snapshot := range snapshots {
+		vaults = append(vaults, &Vault{Dir: v.snapshotPath(snapshot.Name)})
+	}
+
+	referenced := make(map[string]bool)
+	for _, vault := range vaults {
+		if !HasDataFile(vault) {
+			continue
+		}
+		_, entries, err := loadAllEntries(vault)
+		if err != nil {
+			return 0, fmt.Errorf("reading %v: %w", vault.Dir, err)
+		}
+		for _, entry := range entries {
+			for _, attachment := range entry.Attachments {
+				referenced[attachment.File] = true
+			}
+		}
+	}
+
+	removed := 0
+	for _, file := range files {
+		if !attachmentFilePattern.MatchString(file.Name()) || referenced[file.Name()] {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil || time.Since(info.ModTime()) < attachmentGrace {
+			continue
+		}
+		if err := os.Remove(filepath.Join(v.path(attachmentsDir), file.Name())); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,154 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAttachmentsAreKeptWithEntries(t *testing.T) {
+	v := testVault(t)
+
+	file, err := SaveAttachment(v, "encrypted contents")
+	if err != nil {
+		t.Fatalf("SaveAttachment failed: %v", err)
+	}
+
+	entry := Entry{Id: 1, Title: "server", Attachments: []Attachment{{Name: "id_rsa", File: file, Size: 18, Sum: "sum"}}}
+	if err := SaveEntry(v, entry); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+	if loaded, err := LoadEntry(v, 1); err != nil || !reflect.DeepEqual(loaded, entry) {
+		t.Errorf("Expected %+v, but got %+v (%v)", entry, loaded, err)
+	}
+
+	info, err := os.Stat(filepath.Join(v.path(attachmentsDir), file))
+	if err != nil || info.Mode().Perm() != filePerm {
+		t.Errorf("Expected the attachment file readable by its owner only, but got %v (%v)", info, err)
+	}
+}
+
+func TestLoadAttachmentChecksFileNames(t *testing.T) {
+	v := testVault(t)
+
+	for _, file := range []string{"../data.bin", "", "ABCDEF"} {
+		if _, err := LoadAttachment(v, file); !errors.Is(err, ErrAttachmentNotFound) {
+			t.Errorf("Expected ErrAttachmentNotFound for %q, but got %v", file, err)
+		}
+	}
+}
+
+func TestSweepAttachments(t *testing.T) {
+	v := testVault(t)
+
+	save := func() string {
+		file, err := SaveAttachment(v, "encrypted contents")
+		if err != nil {
+			t.Fatalf("SaveAttachment failed: %v", err)
+		}
+		old := time.Now().Add(-2 * attachmentGrace)
+		if err := os.Chtimes(filepath.Join(v.path(attachmentsDir), file), old, old); err != nil {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+		return file
+	}
+	exists := func(file string) bool {
+		_, err := os.Stat(filepath.Join(v.path(attachmentsDir), file))
+		return err == nil
+	}
+
+	inVault, inSnapshot, unreferenced := save(), save(), save()
+	young, err := SaveAttachment(v, "encrypted contents")
+	if err != nil {
+		t.Fatalf("SaveAttachment failed: %v", err)
+	}
+
+	if err := SaveEntry(v, Entry{Id: 1, Title: "old", Attachments: []Attachment{{Name: "a", File: inSnapshot}}}); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+	if _, err := Backup(v, 0); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if err := UpdateEntry(v, 1, Entry{Title: "new", Attachments: []Attachment{{Name: "a", File: inVault}}}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
+
+	if removed, err := sweepAttachments(v); err != nil || removed != 1 {
+		t.Errorf("Expected 1 file removed, but got %v (%v)", removed, err)
+	}
+	if !exists(inVault) || !exists(inSnapshot) || !exists(young) {
+		t.Errorf("Expected the files still referred to, and the new one, to be kept")
+	}
+	if exists(unreferenced) {
+		t.Errorf("Expected %v to be removed", unreferenced)
+	}
+}
+
+func TestPurgeRemovesAttachments(t *testing.T) {
+	v := testVault(t)
+
+	file, err := SaveAttachment(v, "encrypted contents")
+	if err != nil {
+		t.Fatalf("SaveAttachment failed: %v", err)
+	}
+	path := filepath.Join(v.path(attachmentsDir), file)
+	old := time.Now().Add(-2 * attachmentGrace)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	entry := Entry{Id: 1, Title: "server", Attachments: []Attachment{{Name: "id_rsa", File: file}}}
+	if err := SaveEntry(v, entry); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+	if _, err := Backup(v, 1); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if err := DeleteEntry(v, 1); err != nil {
+		t.Fatalf("DeleteEntry failed: %v", err)
+	}
+
+	// The snapshot still refers to it
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Expected the attachment to be kept for the snapshot, but got %v", err)
+	}
+
+	// Rotating the snapshot out lets it go
+	if _, err := Backup(v, 1); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected the attachment to be removed, but got %v", err)
+	}
+}
+
+func TestFailedSweepWarns(t *testing.T) {
+	v := testVault(t)
+	var warnings []error
+	v.Warn = func(err error) { warnings = append(warnings, err) }
+
+	if _, err := SaveAttachment(v, "encrypted contents"); err != nil {
+		t.Fatalf("SaveAttachment failed: %v", err)
+	}
+	seedEntries(t, v, 2)
+	name, err := Backup(v, 0)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	// A snapshot this version can't read stops the sweep
+	if err := os.WriteFile(filepath.Join(v.snapshotPath(name), dataFile), dataMagic[:], filePerm); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	if err := DeleteEntry(v, 1); err != nil {
+		t.Fatalf("Expected the delete to stand, but got %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0].Error(), name) {
+		t.Errorf("Expected a warning about the snapshot, but got %v", warnings)
+	}
+}
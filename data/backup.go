@@ -0,0 +1,275 @@
+package data
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+var ErrSnapshotNotFound = errors.New("no such snapshot")
+
+// DefaultKeepBackups is how many snapshots are kept unless configured otherwise.
+const DefaultKeepBackups = 10
+
+// backupsDir holds the snapshots of a vault, one directory each.
+const backupsDir = "backups"
+
+// snapshotLayout names snapshots by the time they were taken, so sorting the names
+// sorts the snapshots.
+const snapshotLayout = "20060102-150405.000"
+
+var snapshotPattern = regexp.MustCompile(`^\d{8}-\d{6}\.\d{3}(-\d+)?$`)
+
+// vaultFiles are the files a snapshot keeps. They are encrypted already, so the
+// snapshot is as safe as the vault itself. The vault header comes last, see
+// RestoreBackup.
+var vaultFiles = []string{dataFile, stateFile, passwordVerifyFile}
+
+// Snapshot is a copy of the vault files taken at one point in time.
+type Snapshot struct {
+	Name string
+	Time time.Time
+	// Size is the size of the files in the snapshot, in bytes
+	Size int64
+}
+
+// Backup copies the vault files into a new snapshot and removes the oldest
+// snapshots so that at most keep are left. With keep 0 or less no snapshot is
+// removed.
+// Nothing is copied when the vault didn't change since the last snapshot; its name
+// is returned instead.
+func Backup(v *Vault, keep int) (string, error) {
+	release, err := v.lock(true)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	name, rotated, err := backup(v, keep)
+	if err == nil && rotated {
+		v.sweep()
+	}
+	return name, err
+}
+
+// backup is Backup without the sweep, and reports whether old snapshots were
+// removed.
+func backup(v *Vault, keep int) (string, bool, error) {
+	snapshots, err := ListBackups(v)
+	if err != nil {
+		return "", false, err
+	}
+
+	if len(snapshots) > 0 {
+		latest := snapshots[len(snapshots)-1].Name
+		if same, err := sameAsSnapshot(v, latest); err != nil || same {
+			return latest, false, err
+		}
+	}
+
+	name, err := writeSnapshot(v)
+	if err != nil {
+		return "", false, err
+	}
+
+	rotated := false
+	if keep > 0 {
+		snapshots, err := ListBackups(v)
+		if err != nil {
+			return "", false, err
+		}
+		for len(snapshots) > keep {
+			if err := os.RemoveAll(v.snapshotPath(snapshots[0].Name)); err != nil {
+				return "", false, err
+			}
+			snapshots = snapshots[1:]
+			rotated = true
+		}
+	}
+
+	return name, rotated, nil
+}
+
+// ListBackups returns the snapshots of the vault, the oldest first.
+func ListBackups(v *Vault) ([]Snapshot, error) {
+	dirs, err := os.ReadDir(v.path(backupsDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	for _, dir := range dirs {
+		if !dir.IsDir() || !snapshotPattern.MatchString(dir.Name()) {
+			continue
+		}
+
+		taken, err := time.ParseInLocation(snapshotLayout, dir.Name()[:len(snapshotLayout)], time.UTC)
+		if err != nil {
+			continue
+		}
+
+		snapshot := Snapshot{Name: dir.Name(), Time: taken}
+		for _, name := range vaultFiles {
+			if info, err := os.Stat(filepath.Join(v.snapshotPath(dir.Name()), name)); err == nil {
+				snapshot.Size += info.Size()
+			}
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Name < snapshots[j].Name
+	})
+
+	return snapshots, nil
+}
+
+// BackupVault returns the named snapshot as a vault, so it can be unlocked and
+// checked before it is restored.
+func BackupVault(v *Vault, name string) (*Vault, error) {
+	if !snapshotPattern.MatchString(name) || !fileExists(v.snapshotPath(name)) {
+		return nil, fmt.Errorf("%w: %v", ErrSnapshotNotFound, name)
+	}
+
+	return &Vault{Dir: v.snapshotPath(name)}, nil
+}
+
+// RestoreBackup replaces the vault files with those of the named snapshot. The
+// current files are backed up first, so a restore can be undone, unless keep is 0
+// or less. The vault header is written last: until then the vault still unlocks
+// with its current password.
+func RestoreBackup(v *Vault, name string, keep int) error {
+	snapshot, err := BackupVault(v, name)
+	if err != nil {
+		return err
+	}
+
+	release, err := v.lock(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// Read the snapshot first, backing up the current files may rotate it away
+	files := make(map[string][]byte)
+	for _, file := range vaultFiles {
+		if files[file], err = readOptionalFile(snapshot.path(file)); err != nil {
+			return err
+		}
+	}
+
+	rotated := false
+	if keep > 0 {
+		if _, rotated, err = backup(v, keep); err != nil {
+			return fmt.Errorf("backing up the current vault failed: %w", err)
+		}
+	}
+
+	// A header staged by an interrupted upgrade belongs to the files replaced here
+	if err := DiscardVaultHeader(v); err != nil {
+		return err
+	}
+
+	for _, file := range vaultFiles {
+		raw := files[file]
+		if raw == nil {
+			// The vault had no such file when the snapshot was taken
+			if err := os.Remove(v.path(file)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+
+		err = writeFileAtomic(v.path(file), filePerm, func(f *os.File) error {
+			_, err := f.Write(raw)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	v.index = nil
+	if rotated {
+		// Only now, the attachments of the restored snapshot may be among the old ones
+		v.sweep()
+	}
+	return nil
+}
+
+// writeSnapshot copies the vault files into a temp directory and renames it to the
+// snapshot name once complete, so a crash never leaves half a snapshot.
+func writeSnapshot(v *Vault) (string, error) {
+	if err := os.MkdirAll(v.path(backupsDir), 0700); err != nil {
+		return "", err
+	}
+
+	temp, err := os.MkdirTemp(v.path(backupsDir), ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(temp)
+
+	for _, name := range vaultFiles {
+		if err := copyVaultFile(v, &Vault{Dir: temp}, name); err != nil {
+			return "", err
+		}
+	}
+
+	// Two snapshots in the same millisecond get a counter
+	taken := time.Now().UTC().Format(snapshotLayout)
+	name := taken
+	for i := 2; fileExists(v.snapshotPath(name)); i++ {
+		name = fmt.Sprintf("%v-%d", taken, i)
+	}
+
+	if err := renameAtomic(temp, v.snapshotPath(name)); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// sameAsSnapshot reports whether the vault files equal those in the snapshot.
+func sameAsSnapshot(v *Vault, name string) (bool, error) {
+	for _, file := range vaultFiles {
+		current, err := readOptionalFile(v.path(file))
+		if err != nil {
+			return false, err
+		}
+		saved, err := readOptionalFile(filepath.Join(v.snapshotPath(name), file))
+		if err != nil {
+			return false, err
+		}
+
+		if (current == nil) != (saved == nil) || !bytes.Equal(current, saved) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// readOptionalFile returns nil when the file doesn't exist.
+func readOptionalFile(fileName string) ([]byte, error) {
+	raw, err := os.ReadFile(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if raw == nil && err == nil {
+		raw = []byte{}
+	}
+	return raw, err
+}
+
+func (v *Vault) snapshotPath(name string) string {
+	return filepath.Join(v.path(backupsDir), name)
+}
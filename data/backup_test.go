@@ -0,0 +1,167 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestBackupSkipsUnchangedVault(t *testing.T) {
+	v := testVault(t)
+	seedEntries(t, v, 2)
+
+	first, err := Backup(v, DefaultKeepBackups)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	second, err := Backup(v, DefaultKeepBackups)
+	if err != nil || second != first {
+		t.Errorf("Expected the unchanged vault to reuse snapshot %v, but got %v (%v)", first, second, err)
+	}
+
+	snapshot, err := BackupVault(v, first)
+	if err != nil {
+		t.Fatalf("BackupVault failed: %v", err)
+	}
+	if count, err := CountEntries(snapshot); err != nil || count != 2 {
+		t.Errorf("Expected 2 entries in the snapshot, but got %v (%v)", count, err)
+	}
+}
+
+func TestBackupRotation(t *testing.T) {
+	v := testVault(t)
+
+	var names []string
+	for i := int64(1); i <= 5; i++ {
+		if err := SaveEntry(v, Entry{Id: i, Title: "Title"}); err != nil {
+			t.Fatalf("SaveEntry failed: %v", err)
+		}
+
+		name, err := Backup(v, 3)
+		if err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+		names = append(names, name)
+	}
+
+	snapshots, err := ListBackups(v)
+	if err != nil {
+		t.Fatalf("ListBackups failed: %v", err)
+	}
+
+	if len(snapshots) != 3 {
+		t.Fatalf("Expected 3 snapshots, but got %+v", snapshots)
+	}
+	for i, snapshot := range snapshots {
+		if snapshot.Name != names[i+2] {
+			t.Errorf("Expected the newest snapshots to be kept, but got %v at %d", snapshot.Name, i)
+		}
+	}
+}
+
+func TestRestoreBackup(t *testing.T) {
+	v := testVault(t)
+	seedEntries(t, v, 3)
+	if err := SaveVaultHeader(v, VaultHeader{Verifier: "verifier"}); err != nil {
+		t.Fatalf("SaveVaultHeader failed: %v", err)
+	}
+
+	name, err := Backup(v, DefaultKeepBackups)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+
+	if err := DeleteEntryInMemory(v, 2); err != nil {
+		t.Fatalf("DeleteEntryInMemory failed: %v", err)
+	}
+
+	if err := RestoreBackup(v, name, DefaultKeepBackups); err != nil {
+		t.Fatalf("RestoreBackup failed: %v", err)
+	}
+
+	if entry, err := LoadEntry(v, 2); err != nil || entry.Title != "Title 2" {
+		t.Errorf("Expected entry 2 to be back, but got %+v (%v)", entry, err)
+	}
+
+	// The state before the restore was backed up too
+	snapshots, _ := ListBackups(v)
+	if len(snapshots) != 2 {
+		t.Fatalf("Expected 2 snapshots, but got %+v", snapshots)
+	}
+	undo, _ := BackupVault(v, snapshots[1].Name)
+	if count, err := CountEntries(undo); err != nil || count != 2 {
+		t.Errorf("Expected the vault before the restore in the newest snapshot, but got %v entries (%v)", count, err)
+	}
+}
+
+func TestRestoreRotatedSnapshot(t *testing.T) {
+	v := testVault(t)
+	seedEntries(t, v, 1)
+
+	name, err := Backup(v, 1)
+	if err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if err := SaveEntry(v, Entry{Id: 2, Title: "Title 2"}); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+
+	// Backing up the current vault rotates the restored snapshot away
+	if err := RestoreBackup(v, name, 1); err != nil {
+		t.Fatalf("RestoreBackup failed: %v", err)
+	}
+
+	if count, err := CountEntries(v); err != nil || count != 1 {
+		t.Errorf("Expected the snapshot with 1 entry to be restored, but got %v (%v)", count, err)
+	}
+}
+
+func TestBackupsTurnedOff(t *testing.T) {
+	v := testVault(t)
+
+	for i := int64(1); i <= 3; i++ {
+		if err := SaveEntry(v, Entry{Id: i, Title: "Title"}); err != nil {
+			t.Fatalf("SaveEntry failed: %v", err)
+		}
+		// Asked for, a snapshot is taken and none are removed
+		if _, err := Backup(v, 0); err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+	}
+
+	snapshots, err := ListBackups(v)
+	if err != nil || len(snapshots) != 3 {
+		t.Fatalf("Expected 3 snapshots, but got %+v (%v)", snapshots, err)
+	}
+
+	if err := SaveEntry(v, Entry{Id: 4, Title: "Title"}); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+	if err := RestoreBackup(v, snapshots[0].Name, 0); err != nil {
+		t.Fatalf("RestoreBackup failed: %v", err)
+	}
+
+	// The vault before the restore is not kept
+	if after, err := ListBackups(v); err != nil || len(after) != 3 {
+		t.Errorf("Expected no new snapshot, but got %+v (%v)", after, err)
+	}
+	if count, err := CountEntries(v); err != nil || count != 1 {
+		t.Errorf("Expected the snapshot with 1 entry to be restored, but got %v (%v)", count, err)
+	}
+}
+
+func TestUnknownSnapshot(t *testing.T) {
+	v := testVault(t)
+	seedEntries(t, v, 1)
+
+	for _, name := range []string{"20240101-000000.000", "../..", ""} {
+		if err := RestoreBackup(v, name, DefaultKeepBackups); !errors.Is(err, ErrSnapshotNotFound) {
+			t.Errorf("Expected ErrSnapshotNotFound for %q, but got %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(v.path(dataFile)); err != nil {
+		t.Errorf("The data file must be left alone: %v", err)
+	}
+}
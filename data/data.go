@@ -2,18 +2,113 @@ package data
 
 import (
 	"errors"
+	"fmt"
 	"strings"
+	"time"
 )
 
 type Entry struct {
-	Id       int64
+	Id int64
+	// Type decides which fields the entry has, the fields beyond the fixed ones
+	// are kept as custom fields
+	Type     EntryType
 	Title    string
 	Username string
 	Password string
 	Address  string
 	Notes    string
+	// History holds the previous passwords, the oldest first
+	History []PasswordChange
+	// Fields are the custom fields of the entry, in the order they are shown
+	Fields []CustomField
+	// Folder is the slash separated path of the folder the entry is in, empty for
+	// the top level. It is encrypted unless it is empty.
+	Folder string
+	// Tags are the encrypted tags of the entry
+	Tags []string
+	// OTP is the one-time password secret of the entry as an otpauth:// URI,
+	// encrypted unless it is empty
+	OTP string
+	// Attachments are the files kept with the entry, see attachment.go
+	Attachments []Attachment
+	// Expires is when the secrets of the entry expire, zero when they don't
+	Expires time.Time
+	// RotationDays is how many days after its last change the password must be
+	// changed again, 0 when it need not
+	RotationDays int
+	// Deleted is when the entry was moved to the trash, zero while it is not
+	Deleted time.Time
+
+	// The times below are zero when they are not known, for entries from before
+	// they were recorded
+	Created         time.Time
+	Modified        time.Time
+	Accessed        time.Time
+	PasswordChanged time.Time
+}
+
+// Trashed reports whether the entry is in the trash.
+func (e Entry) Trashed() bool {
+	return !e.Deleted.IsZero()
+}
+
+// PasswordChange is a password an entry had before, encrypted like the current one.
+type PasswordChange struct {
+	Password string
+	Replaced time.Time
 }
 
+// CustomField is a named value an entry carries besides the fixed ones. Secret
+// values are masked when the entry is shown. Both name and value are encrypted.
+type CustomField struct {
+	Name   string
+	Value  string
+	Secret bool
+}
+
+// EntryType is the kind of secret an entry keeps. Entries from before types are
+// logins.
+type EntryType uint8
+
+const (
+	Login EntryType = iota
+	SecureNote
+	Card
+	Identity
+	SSHKey
+	APIToken
+)
+
+var entryTypeNames = []string{"login", "note", "card", "identity", "ssh", "token"}
+
+func (t EntryType) String() string {
+	if int(t) < len(entryTypeNames) {
+		return entryTypeNames[t]
+	}
+	return fmt.Sprintf("type %d", t)
+}
+
+// EntryTypes returns every entry type in order.
+func EntryTypes() []EntryType {
+	types := make([]EntryType, len(entryTypeNames))
+	for i := range types {
+		types[i] = EntryType(i)
+	}
+	return types
+}
+
+func EntryTypeFromString(name string) (EntryType, error) {
+	for i, typeName := range entryTypeNames {
+		if strings.EqualFold(name, typeName) {
+			return EntryType(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown entry type %q, use one of %v", name, strings.Join(entryTypeNames, ", "))
+}
+
+// MaxRotationDays bounds the rotation interval of an entry.
+const MaxRotationDays = 100 * 366
+
 type State struct {
 	LastId int64
 	Count  int64
@@ -1,680 +1,978 @@
 package data
 
 import (
+	"bufio"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sort"
+	"squirrel/secure"
 	"squirrel/types"
+	"time"
 )
 
 var ErrEntryExists = errors.New("entry with this ID already exists")
 var ErrEntryNotFound = errors.New("entry not found")
+var ErrFieldTooLarge = errors.New("record field is too large")
 
 const dataFile = "data.bin"
 const stateFile = "state.bin"
 const passwordVerifyFile = "enc.bin"
 
-func SaveEntry(entry Entry) error {
+// maxFieldSize bounds the length of a single record field, so a damaged length
+// can't make a read allocate huge amounts of memory.
+const maxFieldSize = 16 << 20
+
+// maxListSize bounds the number of items in a list of a record.
+const maxListSize = 1 << 16
+
+// filePerm keeps vault files readable by their owner only.
+const filePerm os.FileMode = 0600
+
+func SaveEntry(v *Vault, entry Entry) error {
+	release, err := v.lock(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// Check if an entry with the same ID already exists
-	_, err := LoadEntry(entry.Id)
+	_, err = LoadEntry(v, entry.Id)
 	if err == nil {
 		// If no error, it means the entry exists, so we return an error
 		return ErrEntryExists
 	}
 
-	file, err := os.OpenFile(dataFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+	if !HasDataFile(v) {
+		return writeDataFile(v, func(w *bufio.Writer) error {
+			return writeAllEntries(v, w, newDataHeader(v), []Entry{entry})
+		})
 	}
-	defer file.Close()
 
-	err = binary.Write(file, binary.LittleEndian, entry.Id)
+	header, err := LoadDataHeader(v)
 	if err != nil {
 		return err
 	}
 
-	if err := writeString(file, entry.Title); err != nil {
-		return err
+	// Never append records of the current format to an older file, or records
+	// the vault can't seal to a sealed one
+	if header != upgradeDataHeader(v, header) {
+		return rewriteEntries(v, 0, func(*Entry) error { return nil }, entry)
 	}
-	if err := writeString(file, entry.Username); err != nil {
+
+	index, err := v.loadIndex()
+	if err != nil {
 		return err
 	}
-	if err := writeString(file, entry.Password); err != nil {
+
+	sourceFile, header, err := openDataFile(v)
+	if err != nil {
 		return err
 	}
-	if err := writeString(file, entry.Address); err != nil {
+	defer sourceFile.Close()
+
+	source, err := recordsReader(sourceFile, header)
+	if err != nil {
 		return err
 	}
-	if err := writeString(file, entry.Notes); err != nil {
+
+	err = writeDataFile(v, func(w *bufio.Writer) error {
+		records, err := newRecordWriter(v, w, header)
+		if err != nil {
+			return err
+		}
+		// Sealed records continue the chain of the records copied before them
+		if header.Flags.Has(FlagSealed) {
+			if records.count, records.last, err = readChain(sourceFile); err != nil {
+				return err
+			}
+		}
+		if _, err := io.Copy(w, source); err != nil {
+			return err
+		}
+		if err := records.write(entry); err != nil {
+			return err
+		}
+		return records.close()
+	})
+	if err != nil {
 		return err
 	}
 
+	// The new record is appended right where the old records ended
+	if info, err := os.Stat(v.path(dataFile)); err == nil {
+		index.add(entry, index.end)
+		index.end = recordsEnd(info.Size(), header)
+		index.info = info
+		v.index = index
+	}
+
 	return nil
 }
 
-func SavePassVerify(encrypted string) error {
+func DeleteEntry(v *Vault, entryID int64) error {
+	release, err := v.lock(true)
+	if err != nil {
+		return err
+	}
+	defer release()
 
-	file, err := os.OpenFile(passwordVerifyFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	sourceFile, header, err := openDataFile(v)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer sourceFile.Close()
 
-	if err := writeString(file, encrypted); err != nil {
+	source, err := recordsReader(sourceFile, header)
+	if err != nil {
 		return err
 	}
 
+	entryFound := false
+	reader := bufio.NewReader(source)
+
+	err = writeDataFile(v, func(w *bufio.Writer) error {
+		records, err := newRecordWriter(v, w, upgradeDataHeader(v, header))
+		if err != nil {
+			return err
+		}
+
+		for {
+			entry, err := readEntry(reader, header)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			if entry.Id == entryID {
+				entryFound = true
+				continue
+			}
+
+			if err := records.write(entry); err != nil {
+				return err
+			}
+		}
+
+		// Keep the original file untouched
+		if !entryFound {
+			return ErrEntryNotFound
+		}
+
+		return records.close()
+	})
+	if err != nil {
+		return err
+	}
+
+	v.sweep()
 	return nil
 }
 
-func LoadPassVerify() (string, error) {
-	file, err := os.Open(passwordVerifyFile)
+// In-memory version of DeleteEntry
+func DeleteEntryInMemory(v *Vault, id int64) error {
+	release, err := v.lock(true)
 	if err != nil {
-		return "", err
+		return err
 	}
-	defer file.Close()
-
-	var result = ""
-	result, err = readString(file)
+	defer release()
 
+	// Read all entries into memory
+	header, entries, err := loadAllEntries(v)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	return result, nil
+	// Filter out the entry to be deleted
+	var newEntries []Entry
+	for _, entry := range entries {
+		if entry.Id != id {
+			newEntries = append(newEntries, entry)
+		}
+	}
+
+	// Replace the file with the remaining entries
+	return writeDataFile(v, func(w *bufio.Writer) error {
+		return writeAllEntries(v, w, upgradeDataHeader(v, header), newEntries)
+	})
 }
 
-func DeleteEntry(entryID int64) error {
-	sourceFile, err := os.Open(dataFile)
+func UpdateEntry(v *Vault, entryId int64, updatedEntry Entry) error {
+	release, err := v.lock(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	sourceFile, header, err := openDataFile(v)
 	if err != nil {
 		return err
 	}
 	defer sourceFile.Close()
 
-	tempFile, err := os.OpenFile("temp_"+dataFile, os.O_CREATE|os.O_WRONLY, 0644)
+	source, err := recordsReader(sourceFile, header)
 	if err != nil {
 		return err
 	}
-	defer tempFile.Close()
 
 	entryFound := false
+	reader := bufio.NewReader(source)
 
-	for {
-		var entry Entry
-
-		err = binary.Read(sourceFile, binary.LittleEndian, &entry.Id)
-		if err == io.EOF {
-			break
-		}
+	err = writeDataFile(v, func(w *bufio.Writer) error {
+		records, err := newRecordWriter(v, w, upgradeDataHeader(v, header))
 		if err != nil {
 			return err
 		}
 
-		entry.Title, err = readString(sourceFile)
-		if err != nil {
-			return err
-		}
-		entry.Username, err = readString(sourceFile)
-		if err != nil {
-			return err
-		}
-		entry.Password, err = readString(sourceFile)
-		if err != nil {
-			return err
-		}
-		entry.Address, err = readString(sourceFile)
-		if err != nil {
-			return err
-		}
-		entry.Notes, err = readString(sourceFile)
-		if err != nil {
-			return err
-		}
+		for {
+			entry, err := readEntry(reader, header)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
 
-		if entry.Id == entryID {
-			entryFound = true
-			continue
-		}
+			if entry.Id == entryId {
+				entry = updatedEntry
+				entry.Id = entryId
+				entryFound = true
+			}
 
-		err = binary.Write(tempFile, binary.LittleEndian, entry.Id)
-		if err != nil {
-			return err
-		}
-		if err := writeString(tempFile, entry.Title); err != nil {
-			return err
-		}
-		if err := writeString(tempFile, entry.Username); err != nil {
-			return err
-		}
-		if err := writeString(tempFile, entry.Password); err != nil {
-			return err
-		}
-		if err := writeString(tempFile, entry.Address); err != nil {
-			return err
+			if err := records.write(entry); err != nil {
+				return err
+			}
 		}
-		if err := writeString(tempFile, entry.Notes); err != nil {
-			return err
+
+		// Keep the original file untouched
+		if !entryFound {
+			return ErrEntryNotFound
 		}
-	}
 
-	if !entryFound {
-		return ErrEntryNotFound
+		return records.close()
+	})
+
+	return err
+}
+
+// In-memory version of UpdateEntry
+func UpdateEntryInMemory(v *Vault, id int64, updatedEntry Entry) error {
+	release, err := v.lock(true)
+	if err != nil {
+		return err
 	}
+	defer release()
 
-	err = os.Remove(dataFile)
+	// Read all entries into memory
+	header, entries, err := loadAllEntries(v)
 	if err != nil {
 		return err
 	}
 
-	err = os.Rename("temp_"+dataFile, dataFile)
+	// Update the entry in memory
+	for i, entry := range entries {
+		if entry.Id == id {
+			entries[i] = updatedEntry
+			break
+		}
+	}
+
+	// Replace the file with the updated entries
+	return writeDataFile(v, func(w *bufio.Writer) error {
+		return writeAllEntries(v, w, upgradeDataHeader(v, header), entries)
+	})
+}
+
+// RewriteEntries applies transform to every entry and writes the result back.
+func RewriteEntries(v *Vault, transform func(*Entry) error) error {
+	release, err := v.lock(true)
 	if err != nil {
 		return err
 	}
+	defer release()
 
-	return nil
+	return rewriteEntries(v, 0, transform)
 }
 
-// In-memory version of DeleteEntry
-func DeleteEntryInMemory(id int64) error {
-	// Read all entries into memory
-	file, err := os.OpenFile(dataFile, os.O_RDWR, 0644)
+// MigrateFeature applies transform to every entry of a data file that doesn't have
+// flag yet, and sets the flag in the same write.
+func MigrateFeature(v *Vault, flag Flags, transform func(*Entry) error) error {
+	release, err := v.lock(false)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	defer release()
 
-	var entries []Entry
-	for {
-		var entry Entry
-		err = binary.Read(file, binary.LittleEndian, &entry.Id)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
+	migrated := func() (bool, error) {
+		if !HasDataFile(v) {
+			return true, nil
 		}
+		header, err := LoadDataHeader(v)
+		return header.Flags.Has(flag), err
+	}
 
-		entry.Title, err = readString(file)
-		if err != nil {
-			return err
-		}
-		entry.Username, err = readString(file)
-		if err != nil {
-			return err
-		}
-		entry.Password, err = readString(file)
-		if err != nil {
-			return err
-		}
-		entry.Address, err = readString(file)
-		if err != nil {
-			return err
-		}
-		entry.Notes, err = readString(file)
-		if err != nil {
-			return err
-		}
+	if done, err := migrated(); done || err != nil {
+		return err
+	}
 
-		entries = append(entries, entry)
+	// Only take the write lock when there is something to migrate, and look again
+	// under it in case another process migrated the file in between
+	releaseWrite, err := v.lock(true)
+	if err != nil {
+		return err
 	}
+	defer releaseWrite()
 
-	// Filter out the entry to be deleted
-	var newEntries []Entry
-	for _, entry := range entries {
-		if entry.Id != id {
-			newEntries = append(newEntries, entry)
-		}
+	if done, err := migrated(); done || err != nil {
+		return err
 	}
 
-	// Re-open the file to overwrite it
-	file, err = os.OpenFile(dataFile, os.O_TRUNC|os.O_WRONLY, 0644)
+	return rewriteEntries(v, flag, transform)
+}
+
+// rewriteEntries transforms every entry, appends extra ones and replaces the data
+// file with the result in a single atomic write.
+func rewriteEntries(v *Vault, setFlags Flags, transform func(*Entry) error, extra ...Entry) error {
+	if !HasDataFile(v) {
+		return nil
+	}
+
+	header, entries, err := loadAllEntries(v)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	header.Flags |= setFlags
 
-	for _, entry := range newEntries {
-		err = binary.Write(file, binary.LittleEndian, entry.Id)
-		if err != nil {
-			return err
-		}
-		if err := writeString(file, entry.Title); err != nil {
-			return err
-		}
-		if err := writeString(file, entry.Username); err != nil {
-			return err
-		}
-		if err := writeString(file, entry.Password); err != nil {
-			return err
-		}
-		if err := writeString(file, entry.Address); err != nil {
-			return err
-		}
-		if err := writeString(file, entry.Notes); err != nil {
-			return err
+	for i := range entries {
+		if err := transform(&entries[i]); err != nil {
+			return fmt.Errorf("entry %d: %w", entries[i].Id, err)
 		}
 	}
 
-	return nil
+	return writeDataFile(v, func(w *bufio.Writer) error {
+		return writeAllEntries(v, w, upgradeDataHeader(v, header), append(entries, extra...))
+	})
 }
 
-func UpdateEntry(entryId int64, updatedEntry Entry) error {
-	sourceFile, err := os.Open(dataFile)
+// UpgradeDataFile rewrites the data file if its header is older than the current
+// format or its descriptors no longer match the vault, and migrates the entries to
+// features they don't have yet.
+func UpgradeDataFile(v *Vault) error {
+	release, err := v.lock(false)
 	if err != nil {
 		return err
 	}
-	defer sourceFile.Close()
+	defer release()
+
+	if err := MigrateFeature(v, FlagTimestamps, addTimestamps); err != nil {
+		return err
+	}
+
+	if !HasDataFile(v) {
+		return nil
+	}
 
-	tempFile, err := os.OpenFile("temp_"+dataFile, os.O_CREATE|os.O_WRONLY, 0644)
+	header, err := LoadDataHeader(v)
 	if err != nil {
 		return err
 	}
-	defer tempFile.Close()
 
-	entryFound := false
+	if header == upgradeDataHeader(v, header) {
+		return nil
+	}
 
-	for {
-		var entry Entry
+	return RewriteEntries(v, func(*Entry) error { return nil })
+}
 
-		err = binary.Read(sourceFile, binary.LittleEndian, &entry.Id)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
+// addTimestamps fills in the times that older entries tell. Only the password
+// history knows one: the last time a password was replaced.
+func addTimestamps(entry *Entry) error {
+	if len(entry.History) > 0 {
+		entry.PasswordChanged = entry.History[len(entry.History)-1].Replaced
+		entry.Modified = entry.PasswordChanged
+	}
+	return nil
+}
 
-		entry.Title, err = readString(sourceFile)
-		if err != nil {
-			return err
-		}
-		entry.Username, err = readString(sourceFile)
-		if err != nil {
-			return err
-		}
-		entry.Password, err = readString(sourceFile)
-		if err != nil {
-			return err
-		}
-		entry.Address, err = readString(sourceFile)
-		if err != nil {
-			return err
-		}
-		entry.Notes, err = readString(sourceFile)
-		if err != nil {
-			return err
-		}
+func LoadEntry(v *Vault, id int64) (Entry, error) {
+	release, err := v.lock(false)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer release()
 
-		if entry.Id == entryId {
-			entry = updatedEntry
-			entry.Id = entryId
-			entryFound = true
-		}
+	index, err := v.loadIndex()
+	if err != nil {
+		return Entry{}, err
+	}
 
-		err = binary.Write(tempFile, binary.LittleEndian, entry.Id)
-		if err != nil {
-			return err
-		}
-		if err := writeString(tempFile, entry.Title); err != nil {
-			return err
-		}
-		if err := writeString(tempFile, entry.Username); err != nil {
-			return err
-		}
-		if err := writeString(tempFile, entry.Password); err != nil {
-			return err
-		}
-		if err := writeString(tempFile, entry.Address); err != nil {
-			return err
-		}
-		if err := writeString(tempFile, entry.Notes); err != nil {
-			return err
-		}
+	offset, found := index.offsets[id]
+	if !found {
+		return Entry{}, ErrEntryNotFound
 	}
 
-	if !entryFound {
-		return ErrEntryNotFound
+	file, err := os.Open(v.path(dataFile))
+	if err != nil {
+		return Entry{}, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return Entry{}, err
 	}
 
-	err = os.Remove(dataFile)
+	entry, err := readEntry(bufio.NewReader(file), index.header)
 	if err != nil {
-		return err
+		return Entry{}, eofIsUnexpected(err)
+	}
+
+	if entry.Id != id {
+		return Entry{}, fmt.Errorf("data file changed while reading entry %d", id)
 	}
 
-	err = os.Rename("temp_"+dataFile, dataFile)
+	return entry, nil
+}
+
+func GetLargestId(v *Vault) (int64, error) {
+	release, err := v.lock(false)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	defer release()
 
-	return nil
+	if !HasDataFile(v) {
+		return 0, nil
+	}
+
+	index, err := v.loadIndex()
+	if err != nil {
+		return 0, err
+	}
+
+	return index.largestId, nil
 }
 
-// In-memory version of UpdateEntry
-func UpdateEntryInMemory(id int64, updatedEntry Entry) error {
-	// Read all entries into memory
-	file, err := os.OpenFile(dataFile, os.O_RDWR, 0644)
+func CountEntries(v *Vault) (int64, error) {
+	release, err := v.lock(false)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	defer file.Close()
+	defer release()
 
-	var entries []Entry
-	for {
-		var entry Entry
-		err = binary.Read(file, binary.LittleEndian, &entry.Id)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return err
-		}
+	index, err := v.loadIndex()
+	if err != nil {
+		return 0, err
+	}
 
-		entry.Title, err = readString(file)
-		if err != nil {
-			return err
-		}
-		entry.Username, err = readString(file)
-		if err != nil {
-			return err
-		}
-		entry.Password, err = readString(file)
-		if err != nil {
-			return err
-		}
-		entry.Address, err = readString(file)
-		if err != nil {
-			return err
-		}
-		entry.Notes, err = readString(file)
-		if err != nil {
-			return err
-		}
+	return index.count - index.trashed, nil
+}
 
-		entries = append(entries, entry)
+func Entries(v *Vault, o Order, limit int, d types.Decryptor) ([]Entry, error) {
+	release, err := v.lock(false)
+	if err != nil {
+		return nil, err
 	}
+	defer release()
 
-	// Update the entry in memory
-	for i, entry := range entries {
-		if entry.Id == id {
-			entries[i] = updatedEntry
-			break
-		}
+	// Retrieve all entries
+	allEntries, err := entries(v, d)
+	if err != nil {
+		return nil, err
+	}
+
+	return sortEntries(withoutTrashed(allEntries), o, limit)
+}
+
+// sortEntries sorts entries by o and returns the first limit of them.
+func sortEntries(allEntries []Entry, o Order, limit int) ([]Entry, error) {
+	// Sort entries based on the Order
+	switch o {
+	case ByTitle:
+		sort.SliceStable(allEntries, func(i, j int) bool {
+			return allEntries[i].Title < allEntries[j].Title
+		})
+	case ByUsername:
+		sort.SliceStable(allEntries, func(i, j int) bool {
+			return allEntries[i].Username < allEntries[j].Username
+		})
+	case ById:
+		sort.SliceStable(allEntries, func(i, j int) bool {
+			return allEntries[i].Id < allEntries[j].Id
+		})
+	default:
+		return nil, fmt.Errorf("unknown order: %v", o)
+	}
+
+	// Apply the limit to the number of entries returned
+	if limit > len(allEntries) {
+		limit = len(allEntries)
+	}
+
+	return allEntries[:limit], nil
+}
+
+// entries reads and returns all entries from a file
+func entries(v *Vault, d func(string) (string, error)) ([]Entry, error) {
+	_, entries, err := loadAllEntries(v)
+	if err != nil {
+		return nil, err
 	}
 
-	// Re-open the file to overwrite it
-	file, err = os.OpenFile(dataFile, os.O_TRUNC|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
+	if err := decryptListed(entries, d); err != nil {
+		return nil, err
 	}
-	defer file.Close()
 
-	for _, entry := range entries {
-		err = binary.Write(file, binary.LittleEndian, entry.Id)
+	return entries, nil
+}
+
+// decryptListed decrypts the fields that lists show, sort and filter by.
+func decryptListed(entries []Entry, d types.Decryptor) error {
+	var err error
+	for i := range entries {
+		entries[i].Title, err = d(entries[i].Title)
 		if err != nil {
 			return err
 		}
-		if err := writeString(file, entry.Title); err != nil {
-			return err
-		}
-		if err := writeString(file, entry.Username); err != nil {
-			return err
-		}
-		if err := writeString(file, entry.Password); err != nil {
+		entries[i].Username, err = d(entries[i].Username)
+		if err != nil {
 			return err
 		}
-		if err := writeString(file, entry.Address); err != nil {
-			return err
+		if entries[i].Folder != "" {
+			entries[i].Folder, err = d(entries[i].Folder)
+			if err != nil {
+				return err
+			}
 		}
-		if err := writeString(file, entry.Notes); err != nil {
-			return err
+		// Copied, the tags may be shared with the stored entry
+		if entries[i].Tags != nil {
+			tags := make([]string, len(entries[i].Tags))
+			for j, tag := range entries[i].Tags {
+				if tags[j], err = d(tag); err != nil {
+					return err
+				}
+			}
+			entries[i].Tags = tags
 		}
 	}
 
 	return nil
 }
 
-func LoadEntry(id int64) (Entry, error) {
-	file, err := os.Open(dataFile)
+// loadAllEntries reads the data file header and every entry after it.
+func loadAllEntries(v *Vault) (DataHeader, []Entry, error) {
+	file, header, err := openDataFile(v)
 	if err != nil {
-		return Entry{}, err
+		return DataHeader{}, nil, err
 	}
 	defer file.Close()
 
-	var entry Entry
+	source, err := recordsReader(file, header)
+	if err != nil {
+		return DataHeader{}, nil, err
+	}
 
+	var entries []Entry
+	reader := bufio.NewReader(source)
 	for {
-		err = binary.Read(file, binary.LittleEndian, &entry.Id)
+		entry, err := readEntry(reader, header)
 		if err == io.EOF {
-			return Entry{}, ErrEntryNotFound
-		} else if err != nil {
-			return Entry{}, err
-		}
-
-		if entry.Title, err = readString(file); err != nil {
-			return Entry{}, err
-		}
-		if entry.Username, err = readString(file); err != nil {
-			return Entry{}, err
-		}
-		if entry.Password, err = readString(file); err != nil {
-			return Entry{}, err
+			break
 		}
-		if entry.Address, err = readString(file); err != nil {
-			return Entry{}, err
+		if err != nil {
+			return DataHeader{}, nil, err
 		}
-		if entry.Notes, err = readString(file); err != nil {
-			return Entry{}, err
+
+		entries = append(entries, entry)
+	}
+
+	return header, entries, nil
+}
+
+// writeDataFile replaces the data file with what write produces, see writeFileAtomic.
+func writeDataFile(v *Vault, write func(*bufio.Writer) error) error {
+	v.index = nil
+
+	return writeFileAtomic(v.path(dataFile), filePerm, func(file *os.File) error {
+		w := bufio.NewWriter(file)
+		if err := write(w); err != nil {
+			return err
 		}
+		return w.Flush()
+	})
+}
 
-		if entry.Id == id {
-			return entry, nil
+func writeAllEntries(v *Vault, w io.Writer, header DataHeader, entries []Entry) error {
+	records, err := newRecordWriter(v, w, header)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := records.write(entry); err != nil {
+			return err
 		}
 	}
+
+	return records.close()
 }
 
-func GetLargestId() (int64, error) {
-	if !HasDataFile() {
-		return 0, nil
-	}
+// readEntry reads one record. It returns io.EOF only when the file ends cleanly
+// before the record starts.
+func readEntry(r io.Reader, header DataHeader) (Entry, error) {
+	var entry Entry
 
-	file, err := os.Open(dataFile)
+	id, err := readUint64(r)
 	if err != nil {
-		return 0, err
+		return Entry{}, err
 	}
-	defer file.Close()
+	entry.Id = int64(id)
 
-	var largestId int64
-	for {
-		var entry Entry
-
-		err := binary.Read(file, binary.LittleEndian, &entry.Id)
-		if err != nil {
-			if errors.Is(err, os.ErrNotExist) {
-				break
-			} else if err == io.EOF {
-				break
+	// Read the strings for Title, Username, Password, Address, and Notes
+	if entry.Title, err = readString(r); err != nil {
+		return Entry{}, eofIsUnexpected(err)
+	}
+	if entry.Username, err = readString(r); err != nil {
+		return Entry{}, eofIsUnexpected(err)
+	}
+	if entry.Password, err = readString(r); err != nil {
+		return Entry{}, eofIsUnexpected(err)
+	}
+	if entry.Address, err = readString(r); err != nil {
+		return Entry{}, eofIsUnexpected(err)
+	}
+	if entry.Notes, err = readString(r); err != nil {
+		return Entry{}, eofIsUnexpected(err)
+	}
+	if header.Flags.Has(FlagPasswordHistory) {
+		if entry.History, err = readHistory(r); err != nil {
+			return Entry{}, eofIsUnexpected(err)
+		}
+	}
+	if header.Flags.Has(FlagTrash) {
+		if entry.Deleted, err = readTime(r); err != nil {
+			return Entry{}, eofIsUnexpected(err)
+		}
+	}
+	if header.Flags.Has(FlagTimestamps) {
+		for _, t := range []*time.Time{&entry.Created, &entry.Modified, &entry.Accessed, &entry.PasswordChanged} {
+			if *t, err = readTime(r); err != nil {
+				return Entry{}, eofIsUnexpected(err)
 			}
 		}
-
-		if _, err := readString(file); err != nil {
-			return 0, err
+	}
+	if header.Flags.Has(FlagCustomFields) {
+		if entry.Fields, err = readFields(r); err != nil {
+			return Entry{}, eofIsUnexpected(err)
+		}
+	}
+	if header.Flags.Has(FlagFolders) {
+		if entry.Folder, err = readString(r); err != nil {
+			return Entry{}, eofIsUnexpected(err)
+		}
+		if entry.Tags, err = readStrings(r); err != nil {
+			return Entry{}, eofIsUnexpected(err)
+		}
+	}
+	if header.Flags.Has(FlagOTP) {
+		if entry.OTP, err = readString(r); err != nil {
+			return Entry{}, eofIsUnexpected(err)
+		}
+	}
+	if header.Flags.Has(FlagAttachments) {
+		if entry.Attachments, err = readAttachments(r); err != nil {
+			return Entry{}, eofIsUnexpected(err)
 		}
-		if _, err := readString(file); err != nil {
-			return 0, err
+	}
+	if header.Flags.Has(FlagTypes) {
+		var entryType [1]byte
+		if _, err = io.ReadFull(r, entryType[:]); err != nil {
+			return Entry{}, eofIsUnexpected(err)
 		}
-		if _, err := readString(file); err != nil {
-			return 0, err
+		entry.Type = EntryType(entryType[0])
+	}
+	if header.Flags.Has(FlagExpiry) {
+		if entry.Expires, err = readTime(r); err != nil {
+			return Entry{}, eofIsUnexpected(err)
 		}
-		if _, err := readString(file); err != nil {
-			return 0, err
+		days, err := readUint64(r)
+		if err != nil {
+			return Entry{}, eofIsUnexpected(err)
 		}
-		if _, err := readString(file); err != nil {
-			return 0, err
+		if days > MaxRotationDays {
+			return Entry{}, fmt.Errorf("implausible rotation interval of %d days", days)
 		}
+		entry.RotationDays = int(days)
+	}
 
-		if entry.Id > largestId {
-			largestId = entry.Id
+	// Only the integrity check looks at the tag of sealed records
+	if header.Flags.Has(FlagSealed) {
+		var tag [secure.MACSize]byte
+		if _, err := io.ReadFull(r, tag[:]); err != nil {
+			return Entry{}, eofIsUnexpected(err)
 		}
 	}
 
-	return largestId, nil
+	return entry, nil
 }
 
-func CountEntries() (int64, error) {
-	file, err := os.Open(dataFile)
-	if err != nil {
-		return 0, err
+func writeEntry(w io.Writer, header DataHeader, entry Entry) error {
+	if err := binary.Write(w, binary.LittleEndian, entry.Id); err != nil {
+		return err
 	}
-	defer file.Close()
-
-	var count int64 = 0
-	for {
-		var entry Entry
-
-		// Attempt to read the ID
-		err = binary.Read(file, binary.LittleEndian, &entry.Id)
-		if err != nil {
-			if err == io.EOF {
-				// We reached the end of the file, break out of the loop
-				break
+	if err := writeString(w, entry.Title); err != nil {
+		return err
+	}
+	if err := writeString(w, entry.Username); err != nil {
+		return err
+	}
+	if err := writeString(w, entry.Password); err != nil {
+		return err
+	}
+	if err := writeString(w, entry.Address); err != nil {
+		return err
+	}
+	if err := writeString(w, entry.Notes); err != nil {
+		return err
+	}
+	if header.Flags.Has(FlagPasswordHistory) {
+		if err := writeHistory(w, entry.History); err != nil {
+			return err
+		}
+	}
+	if header.Flags.Has(FlagTrash) {
+		if err := writeTime(w, entry.Deleted); err != nil {
+			return err
+		}
+	}
+	if header.Flags.Has(FlagTimestamps) {
+		for _, t := range []time.Time{entry.Created, entry.Modified, entry.Accessed, entry.PasswordChanged} {
+			if err := writeTime(w, t); err != nil {
+				return err
 			}
-
-			return 0, err
 		}
-
-		// Read the strings (Title, Username, Password, Address, Notes)
-		if _, err := readString(file); err != nil {
-			return 0, err
+	}
+	if header.Flags.Has(FlagCustomFields) {
+		if err := writeFields(w, entry.Fields); err != nil {
+			return err
+		}
+	}
+	if header.Flags.Has(FlagFolders) {
+		if err := writeString(w, entry.Folder); err != nil {
+			return err
 		}
-		if _, err := readString(file); err != nil {
-			return 0, err
+		if err := writeStrings(w, entry.Tags); err != nil {
+			return err
 		}
-		if _, err := readString(file); err != nil {
-			return 0, err
+	}
+	if header.Flags.Has(FlagOTP) {
+		if err := writeString(w, entry.OTP); err != nil {
+			return err
 		}
-		if _, err := readString(file); err != nil {
-			return 0, err
+	}
+	if header.Flags.Has(FlagAttachments) {
+		if err := writeAttachments(w, entry.Attachments); err != nil {
+			return err
 		}
-		if _, err := readString(file); err != nil {
-			return 0, err
+	}
+	if header.Flags.Has(FlagTypes) {
+		if _, err := w.Write([]byte{byte(entry.Type)}); err != nil {
+			return err
 		}
-
-		// Increment the count for each successfully read entry
-		count++
 	}
-
-	return count, nil
+	if header.Flags.Has(FlagExpiry) {
+		if err := writeTime(w, entry.Expires); err != nil {
+			return err
+		}
+		if err := writeUint64(w, uint64(entry.RotationDays)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func Entries(o Order, limit int, d types.Decryptor) ([]Entry, error) {
-	// Retrieve all entries
-	allEntries, err := entries(d)
-	if err != nil {
+func readHistory(r io.Reader) ([]PasswordChange, error) {
+	count, err := readCount(r)
+	if err != nil || count == 0 {
 		return nil, err
 	}
 
-	// Sort entries based on the Order
-	switch o {
-	case ByTitle:
-		sort.SliceStable(allEntries, func(i, j int) bool {
-			return allEntries[i].Title < allEntries[j].Title
-		})
-	case ByUsername:
-		sort.SliceStable(allEntries, func(i, j int) bool {
-			return allEntries[i].Username < allEntries[j].Username
-		})
-	case ById:
-		sort.SliceStable(allEntries, func(i, j int) bool {
-			return allEntries[i].Id < allEntries[j].Id
-		})
-	default:
-		return nil, fmt.Errorf("unknown order: %v", o)
+	history := make([]PasswordChange, count)
+	for i := range history {
+		if history[i].Replaced, err = readTime(r); err != nil {
+			return nil, err
+		}
+		if history[i].Password, err = readString(r); err != nil {
+			return nil, err
+		}
 	}
+	return history, nil
+}
 
-	// Apply the limit to the number of entries returned
-	if limit > len(allEntries) {
-		limit = len(allEntries)
+func writeHistory(w io.Writer, history []PasswordChange) error {
+	if err := writeUint64(w, uint64(len(history))); err != nil {
+		return err
 	}
-
-	return allEntries[:limit], nil
+	for _, change := range history {
+		if err := writeTime(w, change.Replaced); err != nil {
+			return err
+		}
+		if err := writeString(w, change.Password); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// entries reads and returns all entries from a file
-func entries(d func(string) (string, error)) ([]Entry, error) {
-	file, err := os.Open(dataFile) // Replace dataFile with your file path
-	if err != nil {
+func readFields(r io.Reader) ([]CustomField, error) {
+	count, err := readCount(r)
+	if err != nil || count == 0 {
 		return nil, err
 	}
-	defer file.Close()
 
-	count, err := CountEntries()
-	if err != nil {
-		return nil, err
+	fields := make([]CustomField, count)
+	for i := range fields {
+		if fields[i].Name, err = readString(r); err != nil {
+			return nil, err
+		}
+		if fields[i].Value, err = readString(r); err != nil {
+			return nil, err
+		}
+		var secret [1]byte
+		if _, err = io.ReadFull(r, secret[:]); err != nil {
+			return nil, err
+		}
+		fields[i].Secret = secret[0] != 0
 	}
+	return fields, nil
+}
 
-	var entries = make([]Entry, 0, count)
+func writeFields(w io.Writer, fields []CustomField) error {
+	if err := writeUint64(w, uint64(len(fields))); err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if err := writeString(w, field.Name); err != nil {
+			return err
+		}
+		if err := writeString(w, field.Value); err != nil {
+			return err
+		}
+		var secret byte
+		if field.Secret {
+			secret = 1
+		}
+		if _, err := w.Write([]byte{secret}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	for {
-		var entry Entry
+func readAttachments(r io.Reader) ([]Attachment, error) {
+	count, err := readCount(r)
+	if err != nil || count == 0 {
+		return nil, err
+	}
 
-		// Read the ID
-		if err := binary.Read(file, binary.LittleEndian, &entry.Id); err != nil {
-			if err == io.EOF {
-				// End of file reached
-				break
-			}
+	attachments := make([]Attachment, count)
+	for i := range attachments {
+		if attachments[i].Name, err = readString(r); err != nil {
 			return nil, err
 		}
-
-		// Read the strings for Title, Username, Password, Address, and Notes
-		if entry.Title, err = readString(file); err != nil {
+		if attachments[i].File, err = readString(r); err != nil {
 			return nil, err
 		}
-		if entry.Username, err = readString(file); err != nil {
+		size, err := readUint64(r)
+		if err != nil {
 			return nil, err
 		}
-		if entry.Password, err = readString(file); err != nil {
+		attachments[i].Size = int64(size)
+		if attachments[i].Sum, err = readString(r); err != nil {
 			return nil, err
 		}
-		if entry.Address, err = readString(file); err != nil {
-			return nil, err
+	}
+	return attachments, nil
+}
+
+func writeAttachments(w io.Writer, attachments []Attachment) error {
+	if err := writeUint64(w, uint64(len(attachments))); err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		if err := writeString(w, attachment.Name); err != nil {
+			return err
 		}
-		if entry.Notes, err = readString(file); err != nil {
-			return nil, err
+		if err := writeString(w, attachment.File); err != nil {
+			return err
 		}
-
-		// Append the successfully read entry to the list
-		entry.Username, err = d(entry.Username)
-		if err != nil {
-			return nil, err
+		if err := writeUint64(w, uint64(attachment.Size)); err != nil {
+			return err
+		}
+		if err := writeString(w, attachment.Sum); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		entries = append(entries, entry)
+func readStrings(r io.Reader) ([]string, error) {
+	count, err := readCount(r)
+	if err != nil || count == 0 {
+		return nil, err
 	}
 
-	return entries, nil
+	values := make([]string, count)
+	for i := range values {
+		if values[i], err = readString(r); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
 }
 
-func SaveState(state State) error {
-	file, err := os.OpenFile(stateFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-	if err != nil {
+func writeStrings(w io.Writer, values []string) error {
+	if err := writeUint64(w, uint64(len(values))); err != nil {
 		return err
 	}
-	defer file.Close()
+	for _, value := range values {
+		if err := writeString(w, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func eofIsUnexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
 
-	err = binary.Write(file, binary.LittleEndian, state)
+func SaveState(v *Vault, state State) error {
+	release, err := v.lock(true)
 	if err != nil {
 		return err
 	}
+	defer release()
 
-	return nil
+	return writeFileAtomic(v.path(stateFile), filePerm, func(file *os.File) error {
+		return binary.Write(file, binary.LittleEndian, state)
+	})
 }
 
-func LoadState() (State, error) {
-	file, err := os.Open(stateFile)
+func LoadState(v *Vault) (State, error) {
+	release, err := v.lock(false)
+	if err != nil {
+		return State{}, err
+	}
+	defer release()
+
+	file, err := os.Open(v.path(stateFile))
 	if err != nil {
 		return State{}, err
 	}
@@ -690,41 +988,90 @@ func LoadState() (State, error) {
 	return state, nil
 }
 
-func HasStateFile() bool {
-	return fileExists(stateFile)
+func HasStateFile(v *Vault) bool {
+	return fileExists(v.path(stateFile))
 }
 
-func HasDataFile() bool {
-	return fileExists(dataFile)
+func HasDataFile(v *Vault) bool {
+	return fileExists(v.path(dataFile))
 }
 
-func HasPassVerifyFile() bool {
-	return fileExists(passwordVerifyFile)
+func HasPassVerifyFile(v *Vault) bool {
+	return fileExists(v.path(passwordVerifyFile))
 }
 
-func writeString(file *os.File, str string) error {
+func writeString(w io.Writer, str string) error {
 	strBytes := []byte(str)
 	// Write the length of the string (as a varint)
 	length := uint64(len(strBytes))
-	if err := binary.Write(file, binary.LittleEndian, length); err != nil {
+	if err := binary.Write(w, binary.LittleEndian, length); err != nil {
 		return err
 	}
-	_, err := file.Write(strBytes)
+	_, err := w.Write(strBytes)
 	return err
 }
 
-func readString(file *os.File) (string, error) {
-	var length uint64
-	if err := binary.Read(file, binary.LittleEndian, &length); err != nil {
+func readString(r io.Reader) (string, error) {
+	length, err := readUint64(r)
+	if err != nil {
 		return "", err
 	}
+	if length > maxFieldSize {
+		return "", ErrFieldTooLarge
+	}
+	// In-memory readers know up front whether the field is complete
+	if sized, ok := r.(interface{ Len() int }); ok && length > uint64(sized.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
 	strBytes := make([]byte, length)
-	if _, err := io.ReadFull(file, strBytes); err != nil {
+	if _, err := io.ReadFull(r, strBytes); err != nil {
 		return "", err
 	}
 	return string(strBytes), nil
 }
 
+// readCount reads the length of a list, bounded like a field so a damaged count
+// can't make a read allocate huge amounts of memory.
+func readCount(r io.Reader) (int, error) {
+	count, err := readUint64(r)
+	if err != nil {
+		return 0, err
+	}
+	if count > maxListSize {
+		return 0, ErrFieldTooLarge
+	}
+	return int(count), nil
+}
+
+// readTime reads a time stored as Unix seconds, where 0 is the zero time.
+func readTime(r io.Reader) (time.Time, error) {
+	seconds, err := readUint64(r)
+	if err != nil || seconds == 0 {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(seconds), 0), nil
+}
+
+func writeTime(w io.Writer, t time.Time) error {
+	if t.IsZero() {
+		return writeUint64(w, 0)
+	}
+	return writeUint64(w, uint64(t.Unix()))
+}
+
+func writeUint64(w io.Writer, value uint64) error {
+	_, err := w.Write(binary.LittleEndian.AppendUint64(nil, value))
+	return err
+}
+
+func readUint64(r io.Reader) (uint64, error) {
+	var raw [8]byte
+	if _, err := io.ReadFull(r, raw[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(raw[:]), nil
+}
+
 func fileExists(filename string) bool {
 	_, err := os.Stat(filename)
 	if os.IsNotExist(err) {
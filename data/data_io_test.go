@@ -1,24 +1,24 @@
 package data
 
 import (
-	"encoding/binary"
+	"bufio"
 	"fmt"
-	"io"
-	"os"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestSaveState(t *testing.T) {
-	defer os.Remove("state.bin")
+	v := testVault(t)
 
 	state := State{
 		LastId: 0,
 		Count:  0,
 	}
 
-	SaveState(state)
+	SaveState(v, state)
 
-	loadedState, err := LoadState()
+	loadedState, err := LoadState(v)
 
 	if err != nil {
 		t.Errorf("Test failed %v", err)
@@ -31,7 +31,7 @@ func TestSaveState(t *testing.T) {
 }
 
 func TestSaveEntry(t *testing.T) {
-	defer os.Remove("data.bin")
+	v := testVault(t)
 
 	entry := Entry{
 		Id:       10,
@@ -42,22 +42,230 @@ func TestSaveEntry(t *testing.T) {
 		Notes:    "note bla bla bla",
 	}
 
-	SaveEntry(entry)
+	SaveEntry(v, entry)
 
-	loadedEntry, _ := LoadEntry(10)
+	loadedEntry, _ := LoadEntry(v, 10)
 
-	if loadedEntry != entry {
+	if !reflect.DeepEqual(loadedEntry, entry) {
 		t.Errorf("%v is not equal to %v", entry, loadedEntry)
 	}
 }
 
-func TestCountEntries(t *testing.T) {
-	// Defer the removal of the test file after the test completes
-	defer func() {
-		if err := os.Remove("data.bin"); err != nil {
-			t.Errorf("Failed to remove test file: %v", err)
+func TestPasswordHistory(t *testing.T) {
+	v := testVault(t)
+
+	replaced := time.Unix(1700000000, 0)
+	entry := Entry{
+		Id:       1,
+		Title:    "some title",
+		Password: "third",
+		History: []PasswordChange{
+			{Password: "first", Replaced: replaced},
+			{Password: "second", Replaced: replaced.Add(time.Hour)},
+		},
+	}
+
+	if err := SaveEntry(v, entry); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+	if err := SaveEntry(v, Entry{Id: 2, Title: "other"}); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+
+	loaded, err := LoadEntry(v, 1)
+	if err != nil || !reflect.DeepEqual(loaded, entry) {
+		t.Errorf("Expected %+v, but got %+v (%v)", entry, loaded, err)
+	}
+
+	if loaded, err := LoadEntry(v, 2); err != nil || loaded.History != nil {
+		t.Errorf("Expected no history, but got %+v (%v)", loaded, err)
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	v := testVault(t)
+
+	now := time.Unix(1700000000, 0)
+	entry := Entry{
+		Id:              1,
+		Title:           "some title",
+		Created:         now,
+		Modified:        now.Add(time.Hour),
+		Accessed:        now.Add(2 * time.Hour),
+		PasswordChanged: now.Add(time.Minute),
+	}
+
+	if err := SaveEntry(v, entry); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+	if loaded, err := LoadEntry(v, 1); err != nil || !reflect.DeepEqual(loaded, entry) {
+		t.Errorf("Expected %+v, but got %+v (%v)", entry, loaded, err)
+	}
+}
+
+func TestCustomFields(t *testing.T) {
+	v := testVault(t)
+
+	entry := Entry{
+		Id:    1,
+		Title: "some title",
+		Fields: []CustomField{
+			{Name: "PIN", Value: "1234", Secret: true},
+			{Name: "Host", Value: "db.example.com"},
+		},
+	}
+
+	if err := SaveEntry(v, entry); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+	if err := SaveEntry(v, Entry{Id: 2, Title: "other"}); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+
+	if loaded, err := LoadEntry(v, 1); err != nil || !reflect.DeepEqual(loaded, entry) {
+		t.Errorf("Expected %+v, but got %+v (%v)", entry, loaded, err)
+	}
+	if loaded, err := LoadEntry(v, 2); err != nil || loaded.Fields != nil {
+		t.Errorf("Expected no custom fields, but got %+v (%v)", loaded, err)
+	}
+}
+
+func TestFoldersAndTags(t *testing.T) {
+	v := testVault(t)
+
+	entry := Entry{Id: 1, Title: "some title", Folder: "work/aws", Tags: []string{"prod", "shared"}}
+	if err := SaveEntry(v, entry); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+	if err := SaveEntry(v, Entry{Id: 2, Title: "other"}); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+
+	if loaded, err := LoadEntry(v, 1); err != nil || !reflect.DeepEqual(loaded, entry) {
+		t.Errorf("Expected %+v, but got %+v (%v)", entry, loaded, err)
+	}
+	if loaded, err := LoadEntry(v, 2); err != nil || loaded.Folder != "" || loaded.Tags != nil {
+		t.Errorf("Expected no folder or tags, but got %+v (%v)", loaded, err)
+	}
+}
+
+func TestOTPSecret(t *testing.T) {
+	v := testVault(t)
+
+	entry := Entry{Id: 1, Title: "some title", OTP: "otpauth://totp/x?secret=GEZDGNBV"}
+	if err := SaveEntry(v, entry); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+
+	if loaded, err := LoadEntry(v, 1); err != nil || !reflect.DeepEqual(loaded, entry) {
+		t.Errorf("Expected %+v, but got %+v (%v)", entry, loaded, err)
+	}
+}
+
+func TestEntryType(t *testing.T) {
+	v := testVault(t)
+
+	if err := SaveEntry(v, Entry{Id: 1, Type: SSHKey, Title: "server"}); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+	if loaded, err := LoadEntry(v, 1); err != nil || loaded.Type != SSHKey {
+		t.Errorf("Expected an SSH key, but got %+v (%v)", loaded, err)
+	}
+
+	for _, entryType := range EntryTypes() {
+		if parsed, err := EntryTypeFromString(entryType.String()); err != nil || parsed != entryType {
+			t.Errorf("Expected %v to parse back, but got %v (%v)", entryType, parsed, err)
 		}
-	}()
+	}
+	if _, err := EntryTypeFromString("car"); err == nil {
+		t.Errorf("Expected an unknown type to fail")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	v := testVault(t)
+
+	entry := Entry{Id: 1, Title: "some title", Expires: time.Unix(1800000000, 0), RotationDays: 90}
+	if err := SaveEntry(v, entry); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+	if loaded, err := LoadEntry(v, 1); err != nil || !reflect.DeepEqual(loaded, entry) {
+		t.Errorf("Expected %+v, but got %+v (%v)", entry, loaded, err)
+	}
+
+	if err := UpdateEntry(v, 1, Entry{Title: "some title", RotationDays: MaxRotationDays + 1}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
+	if _, err := LoadEntry(v, 1); err == nil {
+		t.Errorf("Expected an implausible rotation interval to fail")
+	}
+}
+
+func TestTimestampsMigration(t *testing.T) {
+	v := testVault(t)
+
+	// A data file from before timestamps
+	header := newDataHeader(v)
+	header.Flags &^= FlagTimestamps
+	replaced := time.Unix(1700000000, 0)
+	err := writeDataFile(v, func(w *bufio.Writer) error {
+		return writeAllEntries(v, w, header, []Entry{
+			{Id: 1, Title: "changed", History: []PasswordChange{{Password: "old", Replaced: replaced}}},
+			{Id: 2, Title: "unknown"},
+		})
+	})
+	if err != nil {
+		t.Fatalf("Writing the data file failed: %v", err)
+	}
+
+	if err := UpgradeDataFile(v); err != nil {
+		t.Fatalf("UpgradeDataFile failed: %v", err)
+	}
+
+	if header, _ := LoadDataHeader(v); !header.Flags.Has(FlagTimestamps) {
+		t.Errorf("Expected the timestamps flag to be set, but got %+v", header)
+	}
+	if entry, _ := LoadEntry(v, 1); !entry.PasswordChanged.Equal(replaced) || !entry.Created.IsZero() {
+		t.Errorf("Expected the password change to be taken from the history, but got %+v", entry)
+	}
+	if entry, _ := LoadEntry(v, 2); !entry.PasswordChanged.IsZero() || !entry.Modified.IsZero() {
+		t.Errorf("Expected unknown times to stay zero, but got %+v", entry)
+	}
+}
+
+func TestHistoryIsAddedToOldDataFiles(t *testing.T) {
+	v := testVault(t)
+
+	// A data file from before password history
+	header := newDataHeader(v)
+	header.Flags &^= FlagPasswordHistory
+	err := writeDataFile(v, func(w *bufio.Writer) error {
+		return writeAllEntries(v, w, header, []Entry{{Id: 1, Title: "old"}})
+	})
+	if err != nil {
+		t.Fatalf("Writing the data file failed: %v", err)
+	}
+
+	if err := UpgradeDataFile(v); err != nil {
+		t.Fatalf("UpgradeDataFile failed: %v", err)
+	}
+
+	if header, _ := LoadDataHeader(v); !header.Flags.Has(FlagPasswordHistory) {
+		t.Errorf("Expected the history flag to be set, but got %+v", header)
+	}
+
+	history := []PasswordChange{{Password: "before", Replaced: time.Unix(1700000000, 0)}}
+	if err := UpdateEntry(v, 1, Entry{Title: "old", Password: "now", History: history}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
+	if loaded, err := LoadEntry(v, 1); err != nil || !reflect.DeepEqual(loaded.History, history) {
+		t.Errorf("Expected the history to be kept, but got %+v (%v)", loaded, err)
+	}
+}
+
+func TestCountEntries(t *testing.T) {
+	// Use a fresh vault directory that is removed after the test completes
+	v := testVault(t)
 
 	// Initialize entry with a test data structure
 	entry := Entry{
@@ -70,24 +278,24 @@ func TestCountEntries(t *testing.T) {
 	}
 
 	// Save the first entry
-	if err := SaveEntry(entry); err != nil {
+	if err := SaveEntry(v, entry); err != nil {
 		t.Fatalf("SaveEntry failed: %v", err)
 	}
 
 	// Save the second entry with a different ID
 	entry.Id = 2
-	if err := SaveEntry(entry); err != nil {
+	if err := SaveEntry(v, entry); err != nil {
 		t.Fatalf("SaveEntry failed: %v", err)
 	}
 
 	// Save the third entry with a different ID
 	entry.Id = 3
-	if err := SaveEntry(entry); err != nil {
+	if err := SaveEntry(v, entry); err != nil {
 		t.Fatalf("SaveEntry failed: %v", err)
 	}
 
 	// Count the entries in the file
-	count, err := CountEntries()
+	count, err := CountEntries(v)
 	if err != nil {
 		t.Fatalf("CountEntries failed: %v", err)
 	}
@@ -99,7 +307,7 @@ func TestCountEntries(t *testing.T) {
 }
 
 func TestDeleteEntryWithLargeFile(t *testing.T) {
-	defer os.Remove("data.bin")
+	v := testVault(t)
 
 	// Add multiple entries to the file
 	for i := int64(1); i <= 100; i++ {
@@ -111,20 +319,20 @@ func TestDeleteEntryWithLargeFile(t *testing.T) {
 			Address:  "http://address" + fmt.Sprint(i) + ".com",
 			Notes:    "Notes " + fmt.Sprint(i),
 		}
-		err := SaveEntry(entry)
+		err := SaveEntry(v, entry)
 		if err != nil {
 			t.Fatalf("SaveEntry failed for ID %v: %v", i, err)
 		}
 	}
 
 	// Now delete an entry with Id 50
-	err := DeleteEntry(50)
+	err := DeleteEntry(v, 50)
 	if err != nil {
 		t.Errorf("DeleteEntry failed: %v", err)
 	}
 
 	// Count remaining entries, should be 99
-	count, err := CountEntries()
+	count, err := CountEntries(v)
 	if err != nil {
 		t.Errorf("CountEntries failed: %v", err)
 	}
@@ -133,14 +341,14 @@ func TestDeleteEntryWithLargeFile(t *testing.T) {
 	}
 
 	// Verify that entry with ID 50 is no longer present
-	_, err = LoadEntry(50)
+	_, err = LoadEntry(v, 50)
 	if err == nil {
 		t.Errorf("Expected error loading entry with ID 50, but got none")
 	}
 }
 
 func TestDeleteEntryInMemory(t *testing.T) {
-	defer os.Remove("data.bin")
+	v := testVault(t)
 
 	// Add multiple entries to the file
 	for i := int64(1); i <= 10; i++ {
@@ -152,20 +360,20 @@ func TestDeleteEntryInMemory(t *testing.T) {
 			Address:  "http://address" + fmt.Sprint(i) + ".com",
 			Notes:    "Notes " + fmt.Sprint(i),
 		}
-		err := SaveEntry(entry)
+		err := SaveEntry(v, entry)
 		if err != nil {
 			t.Fatalf("SaveEntry failed for ID %v: %v", i, err)
 		}
 	}
 
 	// Now delete an entry with Id 50
-	err := DeleteEntryInMemory(5)
+	err := DeleteEntryInMemory(v, 5)
 	if err != nil {
 		t.Errorf("DeleteEntry failed: %v", err)
 	}
 
 	// Count remaining entries, should be 99
-	count, err := CountEntries()
+	count, err := CountEntries(v)
 	if err != nil {
 		t.Errorf("CountEntries failed: %v", err)
 	}
@@ -174,7 +382,7 @@ func TestDeleteEntryInMemory(t *testing.T) {
 	}
 
 	// Verify that entry with ID 50 is no longer present
-	_, err = LoadEntry(5)
+	_, err = LoadEntry(v, 5)
 	if err == nil {
 		t.Errorf("Expected error loading entry with ID 50, but got none")
 	}
@@ -182,12 +390,11 @@ func TestDeleteEntryInMemory(t *testing.T) {
 
 func TestUpdateEntry(t *testing.T) {
 	// Clean up test files after the test
-	defer os.Remove("data.bin")
-	defer os.Remove("temp_data.bin")
+	v := testVault(t)
 
 	// Helper function to add an entry to the file
 	addEntry := func(entry Entry) error {
-		return SaveEntry(entry)
+		return SaveEntry(v, entry)
 	}
 
 	// Add some entries
@@ -236,12 +443,12 @@ func TestUpdateEntry(t *testing.T) {
 		Notes:    "Updated notes 2",
 	}
 
-	if err := UpdateEntry(2, updatedEntry); err != nil {
+	if err := UpdateEntry(v, 2, updatedEntry); err != nil {
 		t.Fatalf("Failed to update entry 2: %v", err)
 	}
 
 	// Verify the entry was updated
-	entries, err := readAllEntries()
+	entries, err := readAllEntries(v)
 	if err != nil {
 		t.Fatalf("Failed to read all entries: %v", err)
 	}
@@ -270,11 +477,11 @@ func TestUpdateEntry(t *testing.T) {
 
 func TestUpdateEntryInMemory(t *testing.T) {
 	// Clean up test files after the test
-	defer os.Remove("data.bin")
+	v := testVault(t)
 
 	// Helper function to add an entry to the file
 	addEntry := func(entry Entry) error {
-		return SaveEntry(entry)
+		return SaveEntry(v, entry)
 	}
 
 	// Add some entries
@@ -323,12 +530,12 @@ func TestUpdateEntryInMemory(t *testing.T) {
 		Notes:    "Updated notes 2",
 	}
 
-	if err := UpdateEntryInMemory(2, updatedEntry); err != nil {
+	if err := UpdateEntryInMemory(v, 2, updatedEntry); err != nil {
 		t.Fatalf("Failed to update entry 2: %v", err)
 	}
 
 	// Verify the entry was updated
-	entries, err := readAllEntries()
+	entries, err := readAllEntries(v)
 	if err != nil {
 		t.Fatalf("Failed to read all entries: %v", err)
 	}
@@ -355,50 +562,197 @@ func TestUpdateEntryInMemory(t *testing.T) {
 	t.Errorf("Entry with ID 2 was not found after update")
 }
 
-// Helper function to read all entries from the file
-func readAllEntries() ([]Entry, error) {
-	file, err := os.Open(dataFile)
+func TestRewriteEntries(t *testing.T) {
+	v := testVault(t)
+
+	for i := int64(1); i <= 5; i++ {
+		entry := Entry{
+			Id:       i,
+			Title:    "Title " + fmt.Sprint(i),
+			Username: "user" + fmt.Sprint(i),
+		}
+		if err := SaveEntry(v, entry); err != nil {
+			t.Fatalf("SaveEntry failed for ID %v: %v", i, err)
+		}
+	}
+
+	err := RewriteEntries(v, func(entry *Entry) error {
+		entry.Username = "rewritten " + entry.Username
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		t.Fatalf("RewriteEntries failed: %v", err)
 	}
-	defer file.Close()
 
-	var entries []Entry
+	entries, err := readAllEntries(v)
+	if err != nil {
+		t.Fatalf("Failed to read all entries: %v", err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("Expected 5 entries, but got %d", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Username != "rewritten user"+fmt.Sprint(entry.Id) {
+			t.Errorf("Entry %d was not rewritten: %+v", entry.Id, entry)
+		}
+	}
+}
 
-	for {
-		var entry Entry
+func TestIndexFollowsChanges(t *testing.T) {
+	v := testVault(t)
 
-		err = binary.Read(file, binary.LittleEndian, &entry.Id)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, err
+	for i := int64(1); i <= 5; i++ {
+		if err := SaveEntry(v, Entry{Id: i, Title: "Title " + fmt.Sprint(i)}); err != nil {
+			t.Fatalf("SaveEntry failed for ID %v: %v", i, err)
 		}
+	}
+
+	if err := UpdateEntry(v, 3, Entry{Id: 3, Title: "a much longer updated title"}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
+	if err := DeleteEntry(v, 2); err != nil {
+		t.Fatalf("DeleteEntry failed: %v", err)
+	}
+	if err := SaveEntry(v, Entry{Id: 9, Title: "Title 9"}); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+
+	// Another vault value writing the same directory replaces the file behind v's back
+	other := &Vault{Dir: v.Dir}
+	other.SetKey(testKey)
+	if err := UpdateEntry(other, 4, Entry{Id: 4, Title: "changed elsewhere"}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
 
-		entry.Title, err = readString(file)
+	expected := map[int64]string{1: "Title 1", 3: "a much longer updated title", 4: "changed elsewhere", 5: "Title 5", 9: "Title 9"}
+	for id, title := range expected {
+		entry, err := LoadEntry(v, id)
 		if err != nil {
-			return nil, err
+			t.Fatalf("LoadEntry failed for ID %v: %v", id, err)
 		}
-		entry.Username, err = readString(file)
-		if err != nil {
-			return nil, err
+		if entry.Title != title {
+			t.Errorf("Expected title %q for ID %v, but got %q", title, id, entry.Title)
 		}
-		entry.Password, err = readString(file)
-		if err != nil {
-			return nil, err
+	}
+
+	if _, err := LoadEntry(v, 2); err != ErrEntryNotFound {
+		t.Errorf("Expected ErrEntryNotFound for a deleted entry, but got %v", err)
+	}
+
+	if count, _ := CountEntries(v); count != 5 {
+		t.Errorf("Expected 5 entries, but got %v", count)
+	}
+	if largest, _ := GetLargestId(v); largest != 9 {
+		t.Errorf("Expected 9 as the largest ID, but got %v", largest)
+	}
+}
+
+const benchmarkEntries = 100_000
+
+// benchmarkVault returns a vault with n entries, written in one go.
+func benchmarkVault(b *testing.B, n int64) *Vault {
+	v := &Vault{Dir: b.TempDir()}
+	v.SetKey(testKey)
+
+	entries := make([]Entry, 0, n)
+	for i := int64(1); i <= n; i++ {
+		entries = append(entries, Entry{
+			Id:       i,
+			Title:    "Title " + fmt.Sprint(i),
+			Username: "user" + fmt.Sprint(i),
+			Password: "pass" + fmt.Sprint(i),
+			Address:  "http://address" + fmt.Sprint(i) + ".com",
+			Notes:    "Notes " + fmt.Sprint(i),
+		})
+	}
+
+	err := writeDataFile(v, func(w *bufio.Writer) error {
+		return writeAllEntries(v, w, newDataHeader(v), entries)
+	})
+	if err != nil {
+		b.Fatalf("Writing the data file failed: %v", err)
+	}
+
+	return v
+}
+
+func BenchmarkLoadIndex(b *testing.B) {
+	v := benchmarkVault(b, benchmarkEntries)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		v.index = nil
+		if err := LoadIndex(v); err != nil {
+			b.Fatalf("LoadIndex failed: %v", err)
 		}
-		entry.Address, err = readString(file)
-		if err != nil {
-			return nil, err
+	}
+}
+
+func BenchmarkLoadEntry(b *testing.B) {
+	v := benchmarkVault(b, benchmarkEntries)
+	if err := LoadIndex(v); err != nil {
+		b.Fatalf("LoadIndex failed: %v", err)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := LoadEntry(v, int64(i%benchmarkEntries)+1); err != nil {
+			b.Fatalf("LoadEntry failed: %v", err)
 		}
-		entry.Notes, err = readString(file)
-		if err != nil {
-			return nil, err
+	}
+}
+
+func BenchmarkCountEntries(b *testing.B) {
+	v := benchmarkVault(b, benchmarkEntries)
+	if err := LoadIndex(v); err != nil {
+		b.Fatalf("LoadIndex failed: %v", err)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := CountEntries(v); err != nil {
+			b.Fatalf("CountEntries failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkGetLargestId(b *testing.B) {
+	v := benchmarkVault(b, benchmarkEntries)
+	if err := LoadIndex(v); err != nil {
+		b.Fatalf("LoadIndex failed: %v", err)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := GetLargestId(v); err != nil {
+			b.Fatalf("GetLargestId failed: %v", err)
 		}
+	}
+}
+
+func BenchmarkEntries(b *testing.B) {
+	v := benchmarkVault(b, benchmarkEntries)
+	identity := func(value string) (string, error) { return value, nil }
+	b.ResetTimer()
 
-		entries = append(entries, entry)
+	for i := 0; i < b.N; i++ {
+		if _, err := Entries(v, ByTitle, 50, identity); err != nil {
+			b.Fatalf("Entries failed: %v", err)
+		}
 	}
+}
+
+// testVault returns an unlocked vault in a temporary directory
+func testVault(t *testing.T) *Vault {
+	v := &Vault{Dir: t.TempDir()}
+	v.SetKey(testKey)
+	return v
+}
 
-	return entries, nil
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+// Helper function to read all entries from the file
+func readAllEntries(v *Vault) ([]Entry, error) {
+	_, entries, err := loadAllEntries(v)
+	return entries, err
 }
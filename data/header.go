@@ -0,0 +1,464 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"squirrel/secure"
+)
+
+var ErrUnsupportedVaultVersion = errors.New("vault header was written by a newer version of squirrel")
+var ErrUnsupportedDataVersion = errors.New("data file was written by a newer version of squirrel")
+
+// vaultMagic starts every vault header. Files without it are headerless legacy vaults.
+var vaultMagic = [4]byte{'S', 'Q', 'V', 'H'}
+
+// dataMagic starts every data file. Files without it are headerless legacy data files.
+var dataMagic = [4]byte{'S', 'Q', 'D', 'B'}
+
+const vaultHeaderVersion uint16 = 4
+
+// sealedVaultVersion is the first vault header version whose data file must be
+// sealed, see FlagSealed.
+const sealedVaultVersion uint16 = 2
+
+// vaultFlagsVersion is the first vault header version with flags after the
+// verifier.
+const vaultFlagsVersion uint16 = 3
+
+// argon2VaultVersion is the first vault header version with the Argon2id costs
+// after the other costs.
+const argon2VaultVersion uint16 = 4
+
+// vaultFlagKeyFile marks vaults whose key is derived from a key file as well as
+// the master password.
+const vaultFlagKeyFile uint32 = 1 << 0
+
+// dataFormatVersion is the record format written to the data file.
+const dataFormatVersion uint16 = 1
+
+// dataHeaderSize is magic, version, cipher, KDF and flags.
+const dataHeaderSize = 4 + 2 + 1 + 1 + 4
+
+const stagedSuffix = ".new"
+
+// VaultHeader describes how the vault key is derived and holds the password verifier.
+type VaultHeader struct {
+	Version  uint16
+	KDF      secure.KDFParams
+	Verifier string
+	// KeyFile is set when the vault can only be unlocked with its key file
+	KeyFile bool
+}
+
+// SaveVaultHeader writes the vault header, replacing the password verifier file.
+func SaveVaultHeader(v *Vault, header VaultHeader) error {
+	release, err := v.lock(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return writeVaultHeader(v.path(passwordVerifyFile), header)
+}
+
+// LoadVaultHeader reads the vault header. Old verifier files without a header are
+// returned as version 0 with the legacy key derivation.
+func LoadVaultHeader(v *Vault) (VaultHeader, error) {
+	release, err := v.lock(false)
+	if err != nil {
+		return VaultHeader{}, err
+	}
+	defer release()
+
+	return readVaultHeader(v.path(passwordVerifyFile))
+}
+
+// StageVaultHeader saves a header next to the current one without activating it.
+// It is used while the vault is re-encrypted under a new key.
+func StageVaultHeader(v *Vault, header VaultHeader) error {
+	release, err := v.lock(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return writeVaultHeader(v.path(passwordVerifyFile+stagedSuffix), header)
+}
+
+// LoadStagedVaultHeader returns the staged header, if there is one.
+func LoadStagedVaultHeader(v *Vault) (VaultHeader, bool, error) {
+	release, err := v.lock(false)
+	if err != nil {
+		return VaultHeader{}, false, err
+	}
+	defer release()
+
+	if !fileExists(v.path(passwordVerifyFile + stagedSuffix)) {
+		return VaultHeader{}, false, nil
+	}
+
+	header, err := readVaultHeader(v.path(passwordVerifyFile + stagedSuffix))
+	if err != nil {
+		return VaultHeader{}, false, err
+	}
+
+	return header, true, nil
+}
+
+// CommitVaultHeader makes the staged header the active one.
+func CommitVaultHeader(v *Vault) error {
+	release, err := v.lock(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return renameAtomic(v.path(passwordVerifyFile+stagedSuffix), v.path(passwordVerifyFile))
+}
+
+// DiscardVaultHeader removes the staged header.
+func DiscardVaultHeader(v *Vault) error {
+	release, err := v.lock(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	err = os.Remove(v.path(passwordVerifyFile + stagedSuffix))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// ReKey moves the vault to a new header and key as one transaction. The header is
+// staged, rewrite re-encrypts the data file under key, and then the header becomes
+// active. When rewrite fails before the data file is replaced, the vault is left
+// as it was. A crash in between leaves the staged header next to a data file
+// sealed with either key, which the seal tells apart.
+func ReKey(v *Vault, header VaultHeader, key []byte, rewrite func() error) error {
+	release, err := v.lock(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := StageVaultHeader(v, header); err != nil {
+		return err
+	}
+
+	oldKey := v.macKey
+	v.SetKey(key)
+
+	if err := rewrite(); err != nil {
+		// The data file may have been replaced just before the error
+		if HasDataFile(v) {
+			if report, verifyErr := VerifyDataFile(v); verifyErr == nil && report.OK() {
+				return CommitVaultHeader(v)
+			}
+		}
+
+		v.macKey = oldKey
+		v.index = nil
+		if discardErr := DiscardVaultHeader(v); discardErr != nil {
+			return fmt.Errorf("%w, and removing the staged header failed: %v", err, discardErr)
+		}
+		return err
+	}
+
+	if err := CommitVaultHeader(v); err != nil {
+		return err
+	}
+
+	// The attachment files under the old key go once no snapshot refers to them
+	v.sweep()
+	return nil
+}
+
+// Flags are optional features enabled in a data file.
+type Flags uint32
+
+const (
+	// FlagEncryptedTitles is set once entry titles are stored encrypted.
+	FlagEncryptedTitles Flags = 1 << iota
+	// FlagSealed is set when every record carries a chained MAC tag and the file
+	// ends with a trailer, see integrity.go.
+	FlagSealed
+	// FlagPasswordHistory is set when records carry the previous passwords of
+	// their entry.
+	FlagPasswordHistory
+	// FlagTrash is set when records carry the time their entry was moved to the
+	// trash.
+	FlagTrash
+	// FlagTimestamps is set when records carry when their entry was created,
+	// modified, last used and when its password changed.
+	FlagTimestamps
+	// FlagCustomFields is set when records carry the custom fields of their entry.
+	FlagCustomFields
+	// FlagFolders is set when records carry the folder and the tags of their entry.
+	FlagFolders
+	// FlagOTP is set when records carry the one-time password secret of their entry.
+	FlagOTP
+	// FlagAttachments is set when records carry the attachments of their entry.
+	FlagAttachments
+	// FlagTypes is set when records carry the type of their entry.
+	FlagTypes
+	// FlagExpiry is set when records carry when their entry expires or how often
+	// it must be rotated.
+	FlagExpiry
+)
+
+// defaultFlags are enabled in every new data file.
+const defaultFlags = FlagEncryptedTitles | FlagTimestamps | recordFlags
+
+// recordFlags add sections to the records whose empty values need no migration,
+// so every rewrite of the data file enables them.
+const recordFlags = FlagPasswordHistory | FlagTrash | FlagCustomFields | FlagFolders | FlagOTP | FlagAttachments | FlagTypes | FlagExpiry
+
+// knownFlags are the flags this version can read and write. A file with any other
+// flag has records this version would misread, and rewriting it would lose them.
+const knownFlags = FlagEncryptedTitles | FlagSealed | FlagTimestamps | recordFlags
+
+func (f Flags) Has(flag Flags) bool {
+	return f&flag == flag
+}
+
+// DataHeader starts the data file and describes how its records are stored.
+type DataHeader struct {
+	Version uint16
+	Cipher  byte
+	KDF     secure.KDF
+	Flags   Flags
+}
+
+// LoadDataHeader reads the header of the data file. Legacy files without a header
+// are returned as version 0.
+func LoadDataHeader(v *Vault) (DataHeader, error) {
+	release, err := v.lock(false)
+	if err != nil {
+		return DataHeader{}, err
+	}
+	defer release()
+
+	file, header, err := openDataFile(v)
+	if err != nil {
+		return DataHeader{}, err
+	}
+	file.Close()
+
+	return header, nil
+}
+
+// openDataFile opens the data file for reading, positioned at the first record.
+func openDataFile(v *Vault) (*os.File, DataHeader, error) {
+	file, err := os.Open(v.path(dataFile))
+	if err != nil {
+		return nil, DataHeader{}, err
+	}
+
+	header, err := readDataHeader(file)
+	if err != nil {
+		file.Close()
+		return nil, DataHeader{}, err
+	}
+
+	return file, header, nil
+}
+
+func readDataHeader(file io.ReadSeeker) (DataHeader, error) {
+	raw := make([]byte, dataHeaderSize)
+	n, err := io.ReadFull(file, raw)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return DataHeader{}, err
+	}
+
+	if n < len(dataMagic) || !bytes.Equal(raw[:len(dataMagic)], dataMagic[:]) {
+		// Legacy data file, records start at the beginning
+		_, err := file.Seek(0, io.SeekStart)
+		return DataHeader{}, err
+	}
+
+	if n < dataHeaderSize {
+		return DataHeader{}, fmt.Errorf("data file header is truncated: %w", io.ErrUnexpectedEOF)
+	}
+
+	header := DataHeader{
+		Version: binary.LittleEndian.Uint16(raw[4:6]),
+		Cipher:  raw[6],
+		KDF:     secure.KDF(raw[7]),
+		Flags:   Flags(binary.LittleEndian.Uint32(raw[8:12])),
+	}
+
+	if header.Version > dataFormatVersion {
+		return DataHeader{}, fmt.Errorf("%w (version %d)", ErrUnsupportedDataVersion, header.Version)
+	}
+	if unknown := header.Flags &^ knownFlags; unknown != 0 {
+		return DataHeader{}, fmt.Errorf("%w (features %#x)", ErrUnsupportedDataVersion, uint32(unknown))
+	}
+
+	return header, nil
+}
+
+func writeDataHeader(w io.Writer, header DataHeader) error {
+	_, err := w.Write(encodeDataHeader(header))
+	return err
+}
+
+func encodeDataHeader(header DataHeader) []byte {
+	raw := make([]byte, dataHeaderSize)
+	copy(raw, dataMagic[:])
+	binary.LittleEndian.PutUint16(raw[4:6], header.Version)
+	raw[6] = header.Cipher
+	raw[7] = byte(header.KDF)
+	binary.LittleEndian.PutUint32(raw[8:12], uint32(header.Flags))
+
+	return raw
+}
+
+// newDataHeader returns the header for a new data file, with descriptors taken
+// from the vault header. The file is sealed whenever the vault key is known.
+func newDataHeader(v *Vault) DataHeader {
+	header := DataHeader{
+		Version: dataFormatVersion,
+		Cipher:  secure.CipherVersionGCM,
+		Flags:   defaultFlags,
+	}
+
+	if v.macKey != nil {
+		header.Flags |= FlagSealed
+	}
+
+	if vault, err := LoadVaultHeader(v); err == nil {
+		header.KDF = vault.KDF.Algorithm
+	}
+
+	return header
+}
+
+// upgradeDataHeader returns the header to write when rewriting a file that had
+// the given header. Feature flags are kept, sealing follows the vault key and
+// record sections are added.
+func upgradeDataHeader(v *Vault, header DataHeader) DataHeader {
+	upgraded := newDataHeader(v)
+	upgraded.Flags = header.Flags&knownFlags&^FlagSealed | upgraded.Flags&(FlagSealed|recordFlags)
+	return upgraded
+}
+
+func writeVaultHeader(fileName string, header VaultHeader) error {
+	var buf bytes.Buffer
+
+	buf.Write(vaultMagic[:])
+	binary.Write(&buf, binary.LittleEndian, vaultHeaderVersion)
+	buf.WriteByte(byte(header.KDF.Algorithm))
+	writeBytes(&buf, header.KDF.Salt)
+	binary.Write(&buf, binary.LittleEndian, []uint32{
+		header.KDF.Iterations, header.KDF.N, header.KDF.R, header.KDF.P,
+		header.KDF.Time, header.KDF.Memory, header.KDF.Parallelism,
+	})
+	writeBytes(&buf, []byte(header.Verifier))
+
+	var flags uint32
+	if header.KeyFile {
+		flags |= vaultFlagKeyFile
+	}
+	binary.Write(&buf, binary.LittleEndian, flags)
+
+	return writeFileAtomic(fileName, filePerm, func(file *os.File) error {
+		_, err := file.Write(buf.Bytes())
+		return err
+	})
+}
+
+func readVaultHeader(fileName string) (VaultHeader, error) {
+	raw, err := os.ReadFile(fileName)
+	if err != nil {
+		return VaultHeader{}, err
+	}
+
+	r := bytes.NewReader(raw)
+
+	if !bytes.HasPrefix(raw, vaultMagic[:]) {
+		// Legacy verifier file: a single length-prefixed string
+		verifier, err := readBytes(r)
+		if err != nil {
+			return VaultHeader{}, err
+		}
+		return VaultHeader{KDF: secure.KDFParams{Algorithm: secure.KDFLegacy}, Verifier: string(verifier)}, nil
+	}
+
+	var header VaultHeader
+	r.Seek(int64(len(vaultMagic)), io.SeekStart)
+
+	if err := binary.Read(r, binary.LittleEndian, &header.Version); err != nil {
+		return VaultHeader{}, err
+	}
+	if header.Version > vaultHeaderVersion {
+		return VaultHeader{}, fmt.Errorf("%w (version %d)", ErrUnsupportedVaultVersion, header.Version)
+	}
+
+	algorithm, err := r.ReadByte()
+	if err != nil {
+		return VaultHeader{}, err
+	}
+	header.KDF.Algorithm = secure.KDF(algorithm)
+
+	if header.KDF.Salt, err = readBytes(r); err != nil {
+		return VaultHeader{}, err
+	}
+
+	costs := make([]uint32, 4, 7)
+	if header.Version >= argon2VaultVersion {
+		costs = costs[:7]
+	}
+	if err := binary.Read(r, binary.LittleEndian, costs); err != nil {
+		return VaultHeader{}, err
+	}
+	header.KDF.Iterations, header.KDF.N, header.KDF.R, header.KDF.P = costs[0], costs[1], costs[2], costs[3]
+	if header.Version >= argon2VaultVersion {
+		header.KDF.Time, header.KDF.Memory, header.KDF.Parallelism = costs[4], costs[5], costs[6]
+	}
+
+	verifier, err := readBytes(r)
+	if err != nil {
+		return VaultHeader{}, err
+	}
+	header.Verifier = string(verifier)
+
+	if header.Version >= vaultFlagsVersion {
+		var flags uint32
+		if err := binary.Read(r, binary.LittleEndian, &flags); err != nil {
+			return VaultHeader{}, err
+		}
+		header.KeyFile = flags&vaultFlagKeyFile != 0
+	}
+
+	return header, nil
+}
+
+func writeBytes(w io.Writer, b []byte) error {
+	if err := binary.Write(w, binary.LittleEndian, uint64(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readBytes reads a length-prefixed byte slice, refusing lengths longer than the data left.
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	var length uint64
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	if length > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,451 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"squirrel/secure"
+	"testing"
+)
+
+func TestSaveVaultHeader(t *testing.T) {
+	v := testVault(t)
+
+	header := VaultHeader{
+		KDF: secure.KDFParams{
+			Algorithm:   secure.KDFArgon2id,
+			Salt:        []byte("0123456789abcdef"),
+			Time:        3,
+			Memory:      64 << 10,
+			Parallelism: 4,
+		},
+		Verifier: "verifier",
+	}
+
+	if err := SaveVaultHeader(v, header); err != nil {
+		t.Fatalf("SaveVaultHeader failed: %v", err)
+	}
+
+	loaded, err := LoadVaultHeader(v)
+	if err != nil {
+		t.Fatalf("LoadVaultHeader failed: %v", err)
+	}
+
+	if loaded.Version != vaultHeaderVersion {
+		t.Errorf("Expected version %d, but got %d", vaultHeaderVersion, loaded.Version)
+	}
+	if loaded.KDF.Algorithm != header.KDF.Algorithm ||
+		!bytes.Equal(loaded.KDF.Salt, header.KDF.Salt) ||
+		loaded.KDF.Time != header.KDF.Time ||
+		loaded.KDF.Memory != header.KDF.Memory ||
+		loaded.KDF.Parallelism != header.KDF.Parallelism ||
+		loaded.Verifier != header.Verifier {
+		t.Errorf("%+v is not equal to %+v", loaded, header)
+	}
+}
+
+func TestLoadLegacyVaultHeader(t *testing.T) {
+	v := testVault(t)
+
+	// Old versions wrote the verifier as a single length-prefixed string
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint64(len("legacy verifier")))
+	buf.WriteString("legacy verifier")
+	if err := os.WriteFile(v.path(passwordVerifyFile), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Writing legacy file failed: %v", err)
+	}
+
+	header, err := LoadVaultHeader(v)
+	if err != nil {
+		t.Fatalf("LoadVaultHeader failed: %v", err)
+	}
+
+	if header.Version != 0 || header.KDF.Algorithm != secure.KDFLegacy {
+		t.Errorf("Expected a legacy header, but got %+v", header)
+	}
+	if header.Verifier != "legacy verifier" {
+		t.Errorf("Expected the legacy verifier, but got %v", header.Verifier)
+	}
+}
+
+func TestVaultHeaderKeyFile(t *testing.T) {
+	v := testVault(t)
+
+	header := VaultHeader{
+		KDF:      secure.KDFParams{Algorithm: secure.KDFPBKDF2, Salt: []byte("salt"), Iterations: 10},
+		Verifier: "verifier",
+		KeyFile:  true,
+	}
+	if err := SaveVaultHeader(v, header); err != nil {
+		t.Fatalf("SaveVaultHeader failed: %v", err)
+	}
+
+	loaded, err := LoadVaultHeader(v)
+	if err != nil {
+		t.Fatalf("LoadVaultHeader failed: %v", err)
+	}
+	if !loaded.KeyFile || loaded.Verifier != "verifier" {
+		t.Errorf("Expected a vault that needs a key file, but got %+v", loaded)
+	}
+
+	// Headers from before flags end with the verifier
+	var buf bytes.Buffer
+	buf.Write(vaultMagic[:])
+	binary.Write(&buf, binary.LittleEndian, vaultFlagsVersion-1)
+	buf.WriteByte(byte(secure.KDFPBKDF2))
+	writeBytes(&buf, []byte("salt"))
+	binary.Write(&buf, binary.LittleEndian, []uint32{10, 0, 0, 0})
+	writeBytes(&buf, []byte("verifier"))
+	if err := os.WriteFile(v.path(passwordVerifyFile), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Writing header failed: %v", err)
+	}
+
+	loaded, err = LoadVaultHeader(v)
+	if err != nil {
+		t.Fatalf("LoadVaultHeader failed: %v", err)
+	}
+	if loaded.KeyFile || loaded.Verifier != "verifier" || loaded.KDF.Iterations != 10 {
+		t.Errorf("Expected an older header without key file, but got %+v", loaded)
+	}
+
+	// Headers from before Argon2id have four costs
+	buf.Reset()
+	buf.Write(vaultMagic[:])
+	binary.Write(&buf, binary.LittleEndian, argon2VaultVersion-1)
+	buf.WriteByte(byte(secure.KDFScrypt))
+	writeBytes(&buf, []byte("salt"))
+	binary.Write(&buf, binary.LittleEndian, []uint32{0, 1024, 8, 1})
+	writeBytes(&buf, []byte("verifier"))
+	binary.Write(&buf, binary.LittleEndian, vaultFlagKeyFile)
+	if err := os.WriteFile(v.path(passwordVerifyFile), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Writing header failed: %v", err)
+	}
+
+	loaded, err = LoadVaultHeader(v)
+	if err != nil {
+		t.Fatalf("LoadVaultHeader failed: %v", err)
+	}
+	if !loaded.KeyFile || loaded.Verifier != "verifier" || loaded.KDF.N != 1024 || loaded.KDF.Memory != 0 {
+		t.Errorf("Expected an older scrypt header with key file, but got %+v", loaded)
+	}
+}
+
+func TestLoadNewerVaultHeader(t *testing.T) {
+	v := testVault(t)
+
+	var buf bytes.Buffer
+	buf.Write(vaultMagic[:])
+	binary.Write(&buf, binary.LittleEndian, vaultHeaderVersion+1)
+	if err := os.WriteFile(v.path(passwordVerifyFile), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Writing header failed: %v", err)
+	}
+
+	_, err := LoadVaultHeader(v)
+	if !errors.Is(err, ErrUnsupportedVaultVersion) {
+		t.Fatalf("Expected ErrUnsupportedVaultVersion, but got %v", err)
+	}
+}
+
+func TestStageAndCommitVaultHeader(t *testing.T) {
+	v := testVault(t)
+
+	if err := SaveVaultHeader(v, VaultHeader{Verifier: "old"}); err != nil {
+		t.Fatalf("SaveVaultHeader failed: %v", err)
+	}
+
+	if _, found, _ := LoadStagedVaultHeader(v); found {
+		t.Fatal("Expected no staged header")
+	}
+
+	staged := VaultHeader{
+		KDF:      secure.KDFParams{Algorithm: secure.KDFPBKDF2, Salt: []byte("salt"), Iterations: 10},
+		Verifier: "new",
+	}
+	if err := StageVaultHeader(v, staged); err != nil {
+		t.Fatalf("StageVaultHeader failed: %v", err)
+	}
+
+	active, err := LoadVaultHeader(v)
+	if err != nil {
+		t.Fatalf("LoadVaultHeader failed: %v", err)
+	}
+	if active.Verifier != "old" {
+		t.Errorf("Staging must not change the active header, got %+v", active)
+	}
+
+	if err := CommitVaultHeader(v); err != nil {
+		t.Fatalf("CommitVaultHeader failed: %v", err)
+	}
+
+	active, err = LoadVaultHeader(v)
+	if err != nil {
+		t.Fatalf("LoadVaultHeader failed: %v", err)
+	}
+	if active.Verifier != "new" || active.KDF.Iterations != 10 {
+		t.Errorf("Expected the staged header to be active, got %+v", active)
+	}
+
+	if _, found, _ := LoadStagedVaultHeader(v); found {
+		t.Fatal("Expected no staged header after commit")
+	}
+}
+
+func TestReKey(t *testing.T) {
+	newKey := []byte("fedcba9876543210fedcba9876543210")
+	rewrite := func(v *Vault) func() error {
+		return func() error {
+			return RewriteEntries(v, func(e *Entry) error { e.Notes = "re-encrypted"; return nil })
+		}
+	}
+
+	t.Run("commits", func(t *testing.T) {
+		v := testVault(t)
+		seedEntries(t, v, 3)
+		if err := SaveVaultHeader(v, VaultHeader{Verifier: "old"}); err != nil {
+			t.Fatalf("SaveVaultHeader failed: %v", err)
+		}
+
+		if err := ReKey(v, VaultHeader{Verifier: "new"}, newKey, rewrite(v)); err != nil {
+			t.Fatalf("ReKey failed: %v", err)
+		}
+
+		if header, err := LoadVaultHeader(v); err != nil || header.Verifier != "new" {
+			t.Errorf("Expected the new header to be active, but got %+v (%v)", header, err)
+		}
+		if _, found, _ := LoadStagedVaultHeader(v); found {
+			t.Error("Expected no staged header")
+		}
+
+		reopened := &Vault{Dir: v.Dir}
+		reopened.SetKey(newKey)
+		if report, err := VerifyDataFile(reopened); err != nil || !report.OK() {
+			t.Errorf("Expected the data file sealed with the new key, but got %+v (%v)", report, err)
+		}
+	})
+
+	t.Run("rolls back", func(t *testing.T) {
+		v := testVault(t)
+		original := seedEntries(t, v, 3)
+		if err := SaveVaultHeader(v, VaultHeader{Verifier: "old"}); err != nil {
+			t.Fatalf("SaveVaultHeader failed: %v", err)
+		}
+
+		restore := crashAt(stepRename)
+		err := ReKey(v, VaultHeader{Verifier: "new"}, newKey, rewrite(v))
+		restore()
+		if !errors.Is(err, errCrash) {
+			t.Fatalf("Expected the simulated crash, but got %v", err)
+		}
+
+		if header, err := LoadVaultHeader(v); err != nil || header.Verifier != "old" {
+			t.Errorf("Expected the old header to stay active, but got %+v (%v)", header, err)
+		}
+		if _, found, _ := LoadStagedVaultHeader(v); found {
+			t.Error("Expected the staged header to be removed")
+		}
+		if after, _ := os.ReadFile(v.path(dataFile)); !bytes.Equal(after, original) {
+			t.Error("The data file was modified")
+		}
+		if report, err := VerifyDataFile(v); err != nil || !report.OK() {
+			t.Errorf("Expected the vault to keep its old key, but got %+v (%v)", report, err)
+		}
+	})
+
+	t.Run("commits after the data file is replaced", func(t *testing.T) {
+		v := testVault(t)
+		seedEntries(t, v, 3)
+		if err := SaveVaultHeader(v, VaultHeader{Verifier: "old"}); err != nil {
+			t.Fatalf("SaveVaultHeader failed: %v", err)
+		}
+
+		// Only the rewrite of the data file is interrupted
+		err := ReKey(v, VaultHeader{Verifier: "new"}, newKey, func() error {
+			restore := crashAt(stepSyncDir)
+			defer restore()
+			return rewrite(v)()
+		})
+		if err != nil {
+			t.Fatalf("Expected the rewritten data file to be kept, but got %v", err)
+		}
+
+		if header, err := LoadVaultHeader(v); err != nil || header.Verifier != "new" {
+			t.Errorf("Expected the new header to be active, but got %+v (%v)", header, err)
+		}
+	})
+}
+
+func TestSaveEntryWritesDataHeader(t *testing.T) {
+	v := testVault(t)
+
+	if err := SaveEntry(v, Entry{Id: 1, Title: "title"}); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+	if err := SaveEntry(v, Entry{Id: 2, Title: "title"}); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+
+	raw, err := os.ReadFile(v.path(dataFile))
+	if err != nil {
+		t.Fatalf("Reading data file failed: %v", err)
+	}
+	if !bytes.HasPrefix(raw, dataMagic[:]) {
+		t.Fatal("Data file does not start with the magic bytes")
+	}
+	if bytes.Count(raw, dataMagic[:]) != 1 {
+		t.Fatal("Expected exactly one data header")
+	}
+
+	header, err := LoadDataHeader(v)
+	if err != nil {
+		t.Fatalf("LoadDataHeader failed: %v", err)
+	}
+	if header.Version != dataFormatVersion || header.Cipher != secure.CipherVersionGCM {
+		t.Errorf("Unexpected header %+v", header)
+	}
+}
+
+func TestLegacyDataFileIsUpgraded(t *testing.T) {
+	v := testVault(t)
+
+	// Old versions wrote the records without any header
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, int64(7))
+	for _, field := range []string{"title", "username", "password", "address", "notes"} {
+		binary.Write(&buf, binary.LittleEndian, uint64(len(field)))
+		buf.WriteString(field)
+	}
+	if err := os.WriteFile(v.path(dataFile), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Writing legacy file failed: %v", err)
+	}
+
+	entry, err := LoadEntry(v, 7)
+	if err != nil {
+		t.Fatalf("LoadEntry failed on a legacy file: %v", err)
+	}
+	if entry.Title != "title" || entry.Notes != "notes" {
+		t.Errorf("Legacy entry was misread: %+v", entry)
+	}
+
+	if err := SaveEntry(v, Entry{Id: 8, Title: "new"}); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+
+	header, err := LoadDataHeader(v)
+	if err != nil {
+		t.Fatalf("LoadDataHeader failed: %v", err)
+	}
+	if header.Version != dataFormatVersion {
+		t.Errorf("Expected the file to be upgraded to version %d, but got %d", dataFormatVersion, header.Version)
+	}
+
+	count, err := CountEntries(v)
+	if err != nil {
+		t.Fatalf("CountEntries failed: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 entries, but got %v", count)
+	}
+}
+
+func TestNewerDataFileIsRejected(t *testing.T) {
+	v := testVault(t)
+
+	file, err := os.Create(v.path(dataFile))
+	if err != nil {
+		t.Fatalf("Creating data file failed: %v", err)
+	}
+	writeDataHeader(file, DataHeader{Version: dataFormatVersion + 1})
+	file.Write([]byte("records in a format we do not know"))
+	file.Close()
+
+	if _, err := LoadEntry(v, 1); !errors.Is(err, ErrUnsupportedDataVersion) {
+		t.Errorf("LoadEntry: expected ErrUnsupportedDataVersion, but got %v", err)
+	}
+	if _, err := CountEntries(v); !errors.Is(err, ErrUnsupportedDataVersion) {
+		t.Errorf("CountEntries: expected ErrUnsupportedDataVersion, but got %v", err)
+	}
+	if err := SaveEntry(v, Entry{Id: 1}); !errors.Is(err, ErrUnsupportedDataVersion) {
+		t.Errorf("SaveEntry: expected ErrUnsupportedDataVersion, but got %v", err)
+	}
+}
+
+func TestUnknownDataFlagsAreRejected(t *testing.T) {
+	v := testVault(t)
+	seedEntries(t, v, 2)
+
+	// A newer version added a section to the records
+	raw, err := os.ReadFile(v.path(dataFile))
+	if err != nil {
+		t.Fatalf("Reading data file failed: %v", err)
+	}
+	flags := binary.LittleEndian.Uint32(raw[8:12])
+	binary.LittleEndian.PutUint32(raw[8:12], flags|1<<20)
+	if err := os.WriteFile(v.path(dataFile), raw, 0600); err != nil {
+		t.Fatalf("Writing data file failed: %v", err)
+	}
+
+	if _, err := LoadDataHeader(v); !errors.Is(err, ErrUnsupportedDataVersion) {
+		t.Errorf("LoadDataHeader: expected ErrUnsupportedDataVersion, but got %v", err)
+	}
+	if _, err := LoadEntry(v, 1); !errors.Is(err, ErrUnsupportedDataVersion) {
+		t.Errorf("LoadEntry: expected ErrUnsupportedDataVersion, but got %v", err)
+	}
+	if err := SaveEntry(v, Entry{Id: 3}); !errors.Is(err, ErrUnsupportedDataVersion) {
+		t.Errorf("SaveEntry: expected ErrUnsupportedDataVersion, but got %v", err)
+	}
+	if err := RewriteEntries(v, func(*Entry) error { return nil }); !errors.Is(err, ErrUnsupportedDataVersion) {
+		t.Errorf("RewriteEntries: expected ErrUnsupportedDataVersion, but got %v", err)
+	}
+
+	if after, _ := os.ReadFile(v.path(dataFile)); !bytes.Equal(after, raw) {
+		t.Error("The data file was modified")
+	}
+}
+
+func TestMigrateFeature(t *testing.T) {
+	v := testVault(t)
+
+	// A file written before titles were encrypted
+	file, err := os.Create(v.path(dataFile))
+	if err != nil {
+		t.Fatalf("Creating data file failed: %v", err)
+	}
+	writeAllEntries(v, file, DataHeader{Version: dataFormatVersion}, []Entry{{Id: 1, Title: "plain"}})
+	file.Close()
+
+	calls := 0
+	migrate := func(entry *Entry) error {
+		calls++
+		entry.Title = "encrypted " + entry.Title
+		return nil
+	}
+
+	if err := MigrateFeature(v, FlagEncryptedTitles, migrate); err != nil {
+		t.Fatalf("MigrateFeature failed: %v", err)
+	}
+	// The second run must not transform the entries again
+	if err := MigrateFeature(v, FlagEncryptedTitles, migrate); err != nil {
+		t.Fatalf("MigrateFeature failed: %v", err)
+	}
+
+	if calls != 1 {
+		t.Errorf("Expected the transform to run once, but it ran %d times", calls)
+	}
+
+	header, err := LoadDataHeader(v)
+	if err != nil {
+		t.Fatalf("LoadDataHeader failed: %v", err)
+	}
+	if !header.Flags.Has(FlagEncryptedTitles) {
+		t.Error("Expected FlagEncryptedTitles to be set")
+	}
+
+	entry, err := LoadEntry(v, 1)
+	if err != nil {
+		t.Fatalf("LoadEntry failed: %v", err)
+	}
+	if entry.Title != "encrypted plain" {
+		t.Errorf("Unexpected title %v", entry.Title)
+	}
+}
@@ -0,0 +1,143 @@
+package data
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// entryIndex maps entry ids to the offset of their record in the data file, so
+// lookups don't have to scan the file.
+type entryIndex struct {
+	header    DataHeader
+	offsets   map[int64]int64
+	count     int64
+	trashed   int64
+	largestId int64
+	// end is the offset right after the last record
+	end int64
+	// info identifies the data file the index was built from
+	info os.FileInfo
+}
+
+// LoadIndex builds the index of the data file in one buffered pass. Lookups keep
+// using it until the data file changes.
+func LoadIndex(v *Vault) error {
+	release, err := v.lock(false)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, err = v.loadIndex()
+	return err
+}
+
+// loadIndex returns the index of the data file, building it again if the file was
+// replaced since.
+func (v *Vault) loadIndex() (*entryIndex, error) {
+	info, err := os.Stat(v.path(dataFile))
+	if err != nil {
+		v.index = nil
+		return nil, err
+	}
+
+	if v.index != nil && v.index.matches(info) {
+		return v.index, nil
+	}
+
+	index, err := buildIndex(v)
+	if err != nil {
+		v.index = nil
+		return nil, err
+	}
+
+	v.index = index
+	return index, nil
+}
+
+func buildIndex(v *Vault) (*entryIndex, error) {
+	file, header, err := openDataFile(v)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &entryIndex{
+		header:  header,
+		offsets: make(map[int64]int64),
+		info:    info,
+	}
+
+	source, err := recordsReader(file, header)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &offsetReader{r: bufio.NewReader(source), offset: start}
+	for {
+		offset := reader.offset
+		entry, err := readEntry(reader, header)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		index.add(entry, offset)
+	}
+	index.end = reader.offset
+
+	return index, nil
+}
+
+func (index *entryIndex) add(entry Entry, offset int64) {
+	index.offsets[entry.Id] = offset
+	index.count++
+	if entry.Trashed() {
+		index.trashed++
+	}
+	// Trashed entries keep their id, so it is not handed out again
+	if entry.Id > index.largestId {
+		index.largestId = entry.Id
+	}
+}
+
+// recordsEnd returns the offset right after the last record of a data file of the
+// given size.
+func recordsEnd(size int64, header DataHeader) int64 {
+	if header.Flags.Has(FlagSealed) {
+		return size - trailerSize
+	}
+	return size
+}
+
+// matches reports whether info describes the same, unchanged data file.
+func (index *entryIndex) matches(info os.FileInfo) bool {
+	return os.SameFile(index.info, info) &&
+		index.info.Size() == info.Size() &&
+		index.info.ModTime().Equal(info.ModTime())
+}
+
+// offsetReader keeps track of how far into the file the reader is.
+type offsetReader struct {
+	r      io.Reader
+	offset int64
+}
+
+func (r *offsetReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
@@ -0,0 +1,381 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"squirrel/secure"
+)
+
+// A sealed data file protects the order and completeness of its records, which the
+// per-field encryption alone can't. Every record ends with a tag over the record and
+// the tag of the record before it:
+//
+//	tag[i] = MAC(key, tag[i-1] | record[i])   with tag[0] = MAC(key, zeros | record[0])
+//
+// After the last record comes the trailer: the record count and
+// MAC(key, "trailer" | header | count | tag of the last record).
+// Changing, dropping, duplicating or moving a record breaks the tag of that record
+// or of the one after it, and cutting records off the end breaks the trailer.
+
+var ErrVaultLocked = errors.New("the vault key is not set")
+var ErrNotSealed = errors.New("data file is not sealed")
+var ErrIntegrity = errors.New("data file failed its integrity check")
+
+// macPurpose derives the MAC key from the vault key.
+const macPurpose = "squirrel data file mac"
+
+// trailerSize is the record count and the trailer MAC.
+const trailerSize = 8 + secure.MACSize
+
+// IntegrityProblem is a part of the data file that fails the integrity check.
+type IntegrityProblem struct {
+	Offset int64
+	// Record is the position of the record in the file, starting at 1, or 0 when
+	// the problem is not about a single record
+	Record int64
+	Id     int64
+	Reason string
+}
+
+// IntegrityReport is the result of VerifyDataFile.
+type IntegrityReport struct {
+	Records  int64
+	Problems []IntegrityProblem
+}
+
+func (r IntegrityReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// CheckIntegrity verifies the seal of the data file when a vault is unlocked. Data
+// files of vaults from before sealing existed are sealed instead, once, and vaults
+// without a data file get an empty one. After that the vault refuses data files
+// that are missing or not sealed. sealed tells from the password verifier that the
+// vault is past that point: unlike the header version, which anyone can roll back,
+// the verifier can't be rewritten without the key.
+func CheckIntegrity(v *Vault, sealed bool) (IntegrityReport, error) {
+	// Other readers may go on, sealing takes the write lock when it is needed
+	release, err := v.lock(false)
+	if err != nil {
+		return IntegrityReport{}, err
+	}
+	defer release()
+
+	if v.macKey == nil {
+		return IntegrityReport{}, ErrVaultLocked
+	}
+
+	vaultHeader, err := LoadVaultHeader(v)
+	if err != nil {
+		return IntegrityReport{}, err
+	}
+
+	if !HasDataFile(v) && !sealed {
+		if err := createDataFile(v); err != nil {
+			return IntegrityReport{}, err
+		}
+	}
+
+	if HasDataFile(v) {
+		header, err := LoadDataHeader(v)
+		if err != nil {
+			return IntegrityReport{}, err
+		}
+
+		if !header.Flags.Has(FlagSealed) {
+			// Someone stripped the seal to hide changes
+			if sealed || vaultHeader.Version >= sealedVaultVersion {
+				return IntegrityReport{}, ErrNotSealed
+			}
+			if err := RewriteEntries(v, func(*Entry) error { return nil }); err != nil {
+				return IntegrityReport{}, err
+			}
+		}
+	}
+
+	// A missing data file fails the check too, removing it hides every entry
+	report, err := VerifyDataFile(v)
+	if err != nil || !report.OK() {
+		return report, err
+	}
+
+	if vaultHeader.Version < sealedVaultVersion {
+		if err := SaveVaultHeader(v, vaultHeader); err != nil {
+			return IntegrityReport{}, err
+		}
+	}
+
+	return report, nil
+}
+
+// VerifyDataFile checks the seal of the data file and reports every part of the
+// file that fails it.
+func VerifyDataFile(v *Vault) (IntegrityReport, error) {
+	release, err := v.lock(false)
+	if err != nil {
+		return IntegrityReport{}, err
+	}
+	defer release()
+
+	if v.macKey == nil {
+		return IntegrityReport{}, ErrVaultLocked
+	}
+
+	if !HasDataFile(v) {
+		return IntegrityReport{Problems: []IntegrityProblem{{Reason: "the data file is missing, it was removed outside squirrel"}}}, nil
+	}
+
+	raw, err := os.ReadFile(v.path(dataFile))
+	if err != nil {
+		return IntegrityReport{}, err
+	}
+
+	reader := bytes.NewReader(raw)
+	header, err := readDataHeader(reader)
+	if err != nil {
+		return IntegrityReport{}, err
+	}
+	if !header.Flags.Has(FlagSealed) {
+		return IntegrityReport{}, ErrNotSealed
+	}
+	start := int64(len(raw) - reader.Len())
+
+	var report IntegrityReport
+	end := int64(len(raw)) - trailerSize
+	if end < start {
+		report.Problems = append(report.Problems, IntegrityProblem{
+			Offset: int64(len(raw)),
+			Reason: "the file ends before its trailer, it was cut off",
+		})
+		return report, nil
+	}
+
+	records, problem := splitRecords(raw[start:end], start, header)
+	report.Records = int64(len(records))
+	if problem != nil {
+		report.Problems = append(report.Problems, *problem)
+		return report, nil
+	}
+
+	previous := make([]byte, secure.MACSize)
+	for i, record := range records {
+		if !bytes.Equal(secure.MAC(v.macKey, previous, record.body), record.tag) {
+			report.Problems = append(report.Problems, IntegrityProblem{
+				Offset: record.offset,
+				Record: int64(i + 1),
+				Id:     record.id,
+				Reason: diagnoseRecord(v.macKey, records, i),
+			})
+		}
+		// Compare the next record with the tag that is stored, so a changed record
+		// is reported once and not again for every record after it
+		previous = record.tag
+	}
+
+	count := binary.LittleEndian.Uint64(raw[end : end+8])
+	expected := secure.MAC(v.macKey, []byte("trailer"), encodeDataHeader(header), raw[end:end+8], previous)
+	if !bytes.Equal(expected, raw[end+8:]) || count != uint64(len(records)) {
+		report.Problems = append(report.Problems, IntegrityProblem{
+			Offset: end,
+			Reason: diagnoseTrailer(count, len(records), len(report.Problems) > 0),
+		})
+	}
+
+	return report, nil
+}
+
+// sealedRecord is a record of a sealed data file as it is stored.
+type sealedRecord struct {
+	offset int64
+	id     int64
+	body   []byte
+	tag    []byte
+}
+
+// splitRecords cuts the records region of a sealed file into records. offset is
+// where the region starts in the file.
+func splitRecords(raw []byte, offset int64, header DataHeader) ([]sealedRecord, *IntegrityProblem) {
+	var records []sealedRecord
+
+	reader := bytes.NewReader(raw)
+	for reader.Len() > 0 {
+		start := len(raw) - reader.Len()
+		entry, err := readEntry(reader, header)
+		if err != nil {
+			return records, &IntegrityProblem{
+				Offset: offset + int64(start),
+				Record: int64(len(records) + 1),
+				Reason: fmt.Sprintf("the record can't be read (%v)", err),
+			}
+		}
+
+		record := raw[start : len(raw)-reader.Len()]
+		records = append(records, sealedRecord{
+			offset: offset + int64(start),
+			id:     entry.Id,
+			body:   record[:len(record)-secure.MACSize],
+			tag:    record[len(record)-secure.MACSize:],
+		})
+	}
+
+	return records, nil
+}
+
+// diagnoseRecord explains why the tag of records[i] doesn't follow the record
+// before it, by looking for the record it was written after.
+func diagnoseRecord(key []byte, records []sealedRecord, i int) string {
+	if bytes.Equal(secure.MAC(key, make([]byte, secure.MACSize), records[i].body), records[i].tag) {
+		return "the record was moved, it was written as the first record"
+	}
+
+	for j, other := range records {
+		if j != i-1 && bytes.Equal(secure.MAC(key, other.tag, records[i].body), records[i].tag) {
+			return fmt.Sprintf("the record was moved or copied, it was written after the record at offset %d", other.offset)
+		}
+	}
+
+	return "the record was changed, comes from another vault, or the record before it was removed"
+}
+
+func diagnoseTrailer(count uint64, found int, recordProblems bool) string {
+	switch {
+	case count > uint64(found) && !recordProblems:
+		return fmt.Sprintf("%d records were written but %d are left, records were cut off the end", count, found)
+	case count > uint64(found):
+		return fmt.Sprintf("%d records were written but %d are left, records were removed", count, found)
+	case count < uint64(found):
+		return fmt.Sprintf("%d records were written but there are %d, records were added", count, found)
+	default:
+		return "the file header or trailer was changed"
+	}
+}
+
+// recordsReader returns a reader for the records of a data file positioned at its
+// first record, leaving out the trailer of sealed files.
+func recordsReader(file *os.File, header DataHeader) (io.Reader, error) {
+	if !header.Flags.Has(FlagSealed) {
+		return file, nil
+	}
+
+	start, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size()-start < trailerSize {
+		return nil, fmt.Errorf("data file trailer is truncated: %w", io.ErrUnexpectedEOF)
+	}
+
+	return io.LimitReader(file, info.Size()-trailerSize-start), nil
+}
+
+// readChain returns the record count and the tag of the last record of a sealed
+// data file, so more records can be appended.
+func readChain(file *os.File) (uint64, []byte, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	raw := make([]byte, secure.MACSize+trailerSize)
+	offset := info.Size() - int64(len(raw))
+	if offset < dataHeaderSize {
+		// No records, only the header and the trailer
+		raw = raw[secure.MACSize:]
+		offset += secure.MACSize
+	}
+
+	if _, err := file.ReadAt(raw, offset); err != nil {
+		return 0, nil, err
+	}
+
+	count := binary.LittleEndian.Uint64(raw[len(raw)-trailerSize:])
+	if count == 0 {
+		return 0, make([]byte, secure.MACSize), nil
+	}
+	if len(raw) < secure.MACSize+trailerSize {
+		return 0, nil, fmt.Errorf("data file trailer is truncated: %w", io.ErrUnexpectedEOF)
+	}
+
+	return count, raw[:secure.MACSize], nil
+}
+
+// recordWriter writes the records of a data file, and for sealed files chains a tag
+// to every record and ends the file with the trailer.
+type recordWriter struct {
+	w      io.Writer
+	header DataHeader
+	key    []byte
+	count  uint64
+	last   []byte
+	buf    bytes.Buffer
+}
+
+// newRecordWriter writes header and returns a writer for the records after it.
+func newRecordWriter(v *Vault, w io.Writer, header DataHeader) (*recordWriter, error) {
+	if header.Flags.Has(FlagSealed) && v.macKey == nil {
+		return nil, ErrVaultLocked
+	}
+
+	if err := writeDataHeader(w, header); err != nil {
+		return nil, err
+	}
+
+	return &recordWriter{w: w, header: header, key: v.macKey, last: make([]byte, secure.MACSize)}, nil
+}
+
+func (rw *recordWriter) write(entry Entry) error {
+	if !rw.header.Flags.Has(FlagSealed) {
+		return writeEntry(rw.w, rw.header, entry)
+	}
+
+	rw.buf.Reset()
+	if err := writeEntry(&rw.buf, rw.header, entry); err != nil {
+		return err
+	}
+
+	rw.last = secure.MAC(rw.key, rw.last, rw.buf.Bytes())
+	rw.buf.Write(rw.last)
+	rw.count++
+
+	_, err := rw.w.Write(rw.buf.Bytes())
+	return err
+}
+
+// close ends a sealed file with its trailer.
+func (rw *recordWriter) close() error {
+	if !rw.header.Flags.Has(FlagSealed) {
+		return nil
+	}
+
+	count := binary.LittleEndian.AppendUint64(nil, rw.count)
+	_, err := rw.w.Write(append(count, secure.MAC(rw.key, []byte("trailer"), encodeDataHeader(rw.header), count, rw.last)...))
+	return err
+}
+
+// createDataFile writes a data file without entries, so that from then on a vault
+// without one stands out.
+func createDataFile(v *Vault) error {
+	release, err := v.lock(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if HasDataFile(v) {
+		return nil
+	}
+	return writeDataFile(v, func(w *bufio.Writer) error {
+		return writeAllEntries(v, w, newDataHeader(v), nil)
+	})
+}
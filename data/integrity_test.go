@@ -0,0 +1,320 @@
+package data
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+// sealedVault returns a vault with n sealed entries and the offsets of their records.
+func sealedVault(t *testing.T, n int64) (*Vault, map[int64]int64) {
+	v := testVault(t)
+	seedEntries(t, v, n)
+
+	index, err := v.loadIndex()
+	if err != nil {
+		t.Fatalf("Building the index failed: %v", err)
+	}
+
+	return v, index.offsets
+}
+
+// editDataFile replaces the data file with what edit makes of it.
+func editDataFile(t *testing.T, v *Vault, edit func(raw []byte) []byte) {
+	raw, err := os.ReadFile(v.path(dataFile))
+	if err != nil {
+		t.Fatalf("Reading data file failed: %v", err)
+	}
+	if err := os.WriteFile(v.path(dataFile), edit(raw), filePerm); err != nil {
+		t.Fatalf("Writing data file failed: %v", err)
+	}
+}
+
+func verify(t *testing.T, v *Vault) IntegrityReport {
+	report, err := VerifyDataFile(v)
+	if err != nil {
+		t.Fatalf("VerifyDataFile failed: %v", err)
+	}
+	return report
+}
+
+func TestSealedVaultVerifies(t *testing.T) {
+	v, _ := sealedVault(t, 5)
+
+	if err := UpdateEntry(v, 2, Entry{Id: 2, Title: "updated"}); err != nil {
+		t.Fatalf("UpdateEntry failed: %v", err)
+	}
+	if err := DeleteEntry(v, 4); err != nil {
+		t.Fatalf("DeleteEntry failed: %v", err)
+	}
+	if err := SaveEntry(v, Entry{Id: 6, Title: "appended"}); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+
+	header, _ := LoadDataHeader(v)
+	if !header.Flags.Has(FlagSealed) {
+		t.Fatal("Expected the data file to be sealed")
+	}
+
+	report := verify(t, v)
+	if !report.OK() || report.Records != 5 {
+		t.Errorf("Expected 5 intact records, but got %+v", report)
+	}
+
+	if entry, err := LoadEntry(v, 6); err != nil || entry.Title != "appended" {
+		t.Errorf("Loading the appended entry failed: %+v (%v)", entry, err)
+	}
+}
+
+func TestVerifyFindsChangedRecord(t *testing.T) {
+	v, offsets := sealedVault(t, 5)
+
+	editDataFile(t, v, func(raw []byte) []byte {
+		// The last byte of the title of entry 3
+		raw[offsets[3]+8+8+int64(len("Title 3"))-1] = '9'
+		return raw
+	})
+
+	report := verify(t, v)
+	if len(report.Problems) != 1 {
+		t.Fatalf("Expected exactly one problem, but got %+v", report.Problems)
+	}
+
+	problem := report.Problems[0]
+	if problem.Offset != offsets[3] || problem.Record != 3 || problem.Id != 3 || !strings.Contains(problem.Reason, "changed") {
+		t.Errorf("Expected entry 3 to be reported as changed, but got %+v", problem)
+	}
+}
+
+func TestVerifyFindsMovedRecords(t *testing.T) {
+	v, offsets := sealedVault(t, 4)
+
+	editDataFile(t, v, func(raw []byte) []byte {
+		// Swap entries 2 and 3
+		swapped := append([]byte(nil), raw[:offsets[2]]...)
+		swapped = append(swapped, raw[offsets[3]:offsets[4]]...)
+		swapped = append(swapped, raw[offsets[2]:offsets[3]]...)
+		return append(swapped, raw[offsets[4]:]...)
+	})
+
+	report := verify(t, v)
+	if len(report.Problems) < 2 {
+		t.Fatalf("Expected the moved records to be reported, but got %+v", report.Problems)
+	}
+	if report.Problems[0].Id != 3 || !strings.Contains(report.Problems[0].Reason, "moved") {
+		t.Errorf("Expected entry 3 to be reported as moved, but got %+v", report.Problems[0])
+	}
+}
+
+func TestVerifyFindsRemovedRecord(t *testing.T) {
+	v, offsets := sealedVault(t, 4)
+
+	editDataFile(t, v, func(raw []byte) []byte {
+		return append(raw[:offsets[2]:offsets[2]], raw[offsets[3]:]...)
+	})
+
+	report := verify(t, v)
+	if len(report.Problems) != 2 {
+		t.Fatalf("Expected two problems, but got %+v", report.Problems)
+	}
+	if report.Problems[0].Id != 3 || report.Problems[0].Offset != offsets[2] {
+		t.Errorf("Expected the record after the gap to be reported, but got %+v", report.Problems[0])
+	}
+	if !strings.Contains(report.Problems[1].Reason, "4 records were written but 3 are left") {
+		t.Errorf("Expected the missing record to be counted, but got %+v", report.Problems[1])
+	}
+}
+
+func TestVerifyFindsDuplicatedRecord(t *testing.T) {
+	v, offsets := sealedVault(t, 3)
+
+	editDataFile(t, v, func(raw []byte) []byte {
+		end := int64(len(raw)) - trailerSize
+		duplicated := append([]byte(nil), raw[:end]...)
+		duplicated = append(duplicated, raw[offsets[1]:offsets[2]]...)
+		return append(duplicated, raw[end:]...)
+	})
+
+	report := verify(t, v)
+	if len(report.Problems) != 2 {
+		t.Fatalf("Expected two problems, but got %+v", report.Problems)
+	}
+	if report.Problems[0].Record != 4 || report.Problems[0].Id != 1 || !strings.Contains(report.Problems[0].Reason, "moved") {
+		t.Errorf("Expected the copy of entry 1 to be reported, but got %+v", report.Problems[0])
+	}
+}
+
+func TestVerifyFindsCutOffRecords(t *testing.T) {
+	v, offsets := sealedVault(t, 3)
+
+	editDataFile(t, v, func(raw []byte) []byte {
+		return append(raw[:offsets[3]:offsets[3]], raw[len(raw)-trailerSize:]...)
+	})
+
+	report := verify(t, v)
+	if len(report.Problems) != 1 || !strings.Contains(report.Problems[0].Reason, "cut off the end") {
+		t.Errorf("Expected the cut off record to be reported, but got %+v", report.Problems)
+	}
+}
+
+func TestVerifyFindsChangedHeader(t *testing.T) {
+	v, _ := sealedVault(t, 2)
+
+	editDataFile(t, v, func(raw []byte) []byte {
+		// Pretend the titles were never encrypted
+		raw[8] &^= byte(FlagEncryptedTitles)
+		return raw
+	})
+
+	report := verify(t, v)
+	if len(report.Problems) != 1 || report.Problems[0].Reason != "the file header or trailer was changed" {
+		t.Errorf("Expected the header change to be reported, but got %+v", report.Problems)
+	}
+}
+
+func TestVerifyWithWrongKey(t *testing.T) {
+	v, _ := sealedVault(t, 3)
+
+	other := &Vault{Dir: v.Dir}
+	other.SetKey([]byte("another key"))
+
+	report := verify(t, other)
+	if len(report.Problems) != 4 {
+		t.Errorf("Expected every record and the trailer to fail, but got %+v", report.Problems)
+	}
+}
+
+func TestCheckIntegritySealsOldVault(t *testing.T) {
+	v := testVault(t)
+
+	// A vault from before sealing existed
+	unlocked := v.macKey
+	v.macKey = nil
+	seedEntries(t, v, 3)
+	writeVersion1Header(t, v)
+	v.macKey = unlocked
+
+	report, err := CheckIntegrity(v, false)
+	if err != nil || !report.OK() || report.Records != 3 {
+		t.Fatalf("Expected the data file to be sealed, but got %+v (%v)", report, err)
+	}
+
+	header, _ := LoadDataHeader(v)
+	vaultHeader, _ := LoadVaultHeader(v)
+	if !header.Flags.Has(FlagSealed) || vaultHeader.Version != vaultHeaderVersion {
+		t.Errorf("Expected a sealed data file and a current vault header, but got %+v and %+v", header, vaultHeader)
+	}
+}
+
+func TestCheckIntegrityRefusesStrippedSeal(t *testing.T) {
+	v, _ := sealedVault(t, 2)
+	if err := SaveVaultHeader(v, VaultHeader{Verifier: "verifier"}); err != nil {
+		t.Fatalf("SaveVaultHeader failed: %v", err)
+	}
+
+	// Rewrite the data file without a seal, as a vault without the key would
+	v.macKey = nil
+	if err := RewriteEntries(v, func(*Entry) error { return nil }); err != nil {
+		t.Fatalf("RewriteEntries failed: %v", err)
+	}
+	v.SetKey(testKey)
+
+	if _, err := CheckIntegrity(v, false); err != ErrNotSealed {
+		t.Errorf("Expected ErrNotSealed, but got %v", err)
+	}
+}
+
+func TestCheckIntegrityRefusesRolledBackHeader(t *testing.T) {
+	v, _ := sealedVault(t, 2)
+
+	// Strip the seal and put back a vault header from before sealing existed
+	v.macKey = nil
+	if err := RewriteEntries(v, func(*Entry) error { return nil }); err != nil {
+		t.Fatalf("RewriteEntries failed: %v", err)
+	}
+	v.SetKey(testKey)
+	writeVersion1Header(t, v)
+	original, _ := os.ReadFile(v.path(dataFile))
+
+	if _, err := CheckIntegrity(v, true); err != ErrNotSealed {
+		t.Errorf("Expected ErrNotSealed, but got %v", err)
+	}
+	if after, _ := os.ReadFile(v.path(dataFile)); !bytes.Equal(after, original) {
+		t.Error("The data file must not be sealed again")
+	}
+}
+
+func TestCheckIntegrityCreatesDataFile(t *testing.T) {
+	v := testVault(t)
+	if err := SaveVaultHeader(v, VaultHeader{Verifier: "verifier"}); err != nil {
+		t.Fatalf("SaveVaultHeader failed: %v", err)
+	}
+
+	report, err := CheckIntegrity(v, false)
+	if err != nil || !report.OK() || report.Records != 0 {
+		t.Fatalf("Expected an empty sealed data file, but got %+v (%v)", report, err)
+	}
+	if header, err := LoadDataHeader(v); err != nil || !header.Flags.Has(FlagSealed) {
+		t.Errorf("Expected a sealed data file, but got %+v (%v)", header, err)
+	}
+}
+
+func TestCheckIntegrityRefusesMissingDataFile(t *testing.T) {
+	v, _ := sealedVault(t, 2)
+	if err := SaveVaultHeader(v, VaultHeader{Verifier: "verifier"}); err != nil {
+		t.Fatalf("SaveVaultHeader failed: %v", err)
+	}
+	if err := os.Remove(v.path(dataFile)); err != nil {
+		t.Fatalf("Removing the data file failed: %v", err)
+	}
+
+	if report := verify(t, v); report.OK() {
+		t.Errorf("Expected VerifyDataFile to report the missing data file, but got %+v", report)
+	}
+
+	report, err := CheckIntegrity(v, true)
+	if err != nil || report.OK() {
+		t.Errorf("Expected the missing data file to be reported, but got %+v (%v)", report, err)
+	}
+	if HasDataFile(v) {
+		t.Error("The missing data file must not be replaced by an empty one")
+	}
+}
+
+func TestCheckIntegrityReportsDamage(t *testing.T) {
+	v, offsets := sealedVault(t, 2)
+	if err := SaveVaultHeader(v, VaultHeader{Verifier: "verifier"}); err != nil {
+		t.Fatalf("SaveVaultHeader failed: %v", err)
+	}
+
+	original, _ := os.ReadFile(v.path(dataFile))
+	editDataFile(t, v, func(raw []byte) []byte {
+		raw[offsets[2]+20] ^= 1
+		return raw
+	})
+
+	report, err := CheckIntegrity(v, false)
+	if err != nil || report.OK() {
+		t.Errorf("Expected the damage to be reported, but got %+v (%v)", report, err)
+	}
+
+	if after, _ := os.ReadFile(v.path(dataFile)); bytes.Equal(after, original) {
+		t.Error("The damaged data file must be left as it is")
+	}
+}
+
+// writeVersion1Header writes a vault header the way versions before sealing did.
+func writeVersion1Header(t *testing.T, v *Vault) {
+	if err := SaveVaultHeader(v, VaultHeader{Verifier: "verifier"}); err != nil {
+		t.Fatalf("SaveVaultHeader failed: %v", err)
+	}
+	editVaultHeader := func(raw []byte) []byte {
+		raw[4], raw[5] = 1, 0
+		return raw
+	}
+	raw, _ := os.ReadFile(v.path(passwordVerifyFile))
+	if err := os.WriteFile(v.path(passwordVerifyFile), editVaultHeader(raw), filePerm); err != nil {
+		t.Fatalf("Writing vault header failed: %v", err)
+	}
+}
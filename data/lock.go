@@ -0,0 +1,114 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+var ErrVaultInUse = errors.New("vault is in use by another squirrel process")
+
+// lockFile is locked instead of the data file itself, which is replaced on every
+// write.
+const lockFile = ".lock"
+
+// lockTimeout is how long an operation waits for another process to finish its own
+// before giving up. Writes take milliseconds, so a longer wait means the other
+// process is stuck or holds the vault on purpose.
+var lockTimeout = 2 * time.Second
+
+const lockRetry = 20 * time.Millisecond
+
+// vaultLock is the advisory lock held on the lock file of a vault.
+type vaultLock struct {
+	file      *os.File
+	exclusive bool
+}
+
+// lock takes the advisory lock of the vault, exclusive for operations that change
+// it and shared for those that only read it, so readers never see a write half
+// done and writers never interleave. It returns the function that releases the
+// lock. Operations called while the vault is already locked reuse that lock.
+func (v *Vault) lock(exclusive bool) (func(), error) {
+	if held := v.held; held != nil {
+		if !exclusive || held.exclusive {
+			return func() {}, nil
+		}
+
+		if err := acquireLock(held.file, true); err != nil {
+			v.relock(held)
+			return nil, err
+		}
+		held.exclusive = true
+		return func() {
+			// A shared lock is still held by the caller, get it back
+			v.relock(held)
+		}, nil
+	}
+
+	file, err := openLockFile(v, exclusive)
+	if err != nil {
+		return nil, err
+	}
+	// There is no vault to read yet
+	if file == nil {
+		return func() {}, nil
+	}
+
+	if err := acquireLock(file, exclusive); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	v.held = &vaultLock{file: file, exclusive: exclusive}
+	return func() {
+		v.held = nil
+		// Closing the file releases the lock
+		file.Close()
+	}, nil
+}
+
+// relock gets the shared lock of held back after an upgrade ended or failed.
+// flock converts a lock by dropping it first, so another process may take the
+// vault in between. If the shared lock can't be had back, the vault counts as
+// unlocked, so later operations of the caller lock it again instead of running
+// unlocked.
+func (v *Vault) relock(held *vaultLock) {
+	held.exclusive = false
+	if err := acquireLock(held.file, false); err != nil && v.held == held {
+		v.held = nil
+	}
+}
+
+func openLockFile(v *Vault, exclusive bool) (*os.File, error) {
+	file, err := os.OpenFile(v.path(lockFile), os.O_RDWR|os.O_CREATE, filePerm)
+	if err == nil || exclusive {
+		return file, err
+	}
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	// Readers may look at a vault they can't write to
+	if file, err := os.Open(v.path(lockFile)); err == nil {
+		return file, nil
+	}
+	return nil, err
+}
+
+// acquireLock waits up to lockTimeout for the lock.
+func acquireLock(file *os.File, exclusive bool) error {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		locked, err := lockFileNow(file, exclusive)
+		if err != nil || locked {
+			return err
+		}
+
+		if time.Now().After(deadline) {
+			return ErrVaultInUse
+		}
+		time.Sleep(lockRetry)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package data
+
+import "os"
+
+// There is no flock here, concurrent squirrel processes are not kept apart.
+const lockingSupported = false
+
+func lockFileNow(file *os.File, exclusive bool) (bool, error) {
+	return true, nil
+}
@@ -0,0 +1,125 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// otherProcess returns a second handle on the vault, which locks it like another
+// squirrel process would.
+func otherProcess(t *testing.T, v *Vault) *Vault {
+	if !lockingSupported {
+		t.Skip("file locking is not supported on this platform")
+	}
+
+	lockTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { lockTimeout = 2 * time.Second })
+
+	other := &Vault{Dir: v.Dir}
+	other.SetKey(testKey)
+	return other
+}
+
+func TestSecondWriterIsRefused(t *testing.T) {
+	v := testVault(t)
+	seedEntries(t, v, 2)
+	other := otherProcess(t, v)
+
+	release, err := other.lock(true)
+	if err != nil {
+		t.Fatalf("Locking the vault failed: %v", err)
+	}
+
+	if err := SaveEntry(v, Entry{Id: 3, Title: "Title 3"}); !errors.Is(err, ErrVaultInUse) {
+		t.Errorf("Expected ErrVaultInUse for a write, but got %v", err)
+	}
+	if _, err := LoadEntry(v, 1); !errors.Is(err, ErrVaultInUse) {
+		t.Errorf("Expected ErrVaultInUse for a read during a write, but got %v", err)
+	}
+
+	release()
+
+	if err := SaveEntry(v, Entry{Id: 3, Title: "Title 3"}); err != nil {
+		t.Errorf("Expected the write to succeed once the lock is released, but got %v", err)
+	}
+}
+
+func TestConcurrentReaders(t *testing.T) {
+	v := testVault(t)
+	seedEntries(t, v, 2)
+	other := otherProcess(t, v)
+
+	release, err := other.lock(false)
+	if err != nil {
+		t.Fatalf("Locking the vault failed: %v", err)
+	}
+	defer release()
+
+	if entry, err := LoadEntry(v, 2); err != nil || entry.Title != "Title 2" {
+		t.Errorf("Expected to read while another reader holds the vault, but got %+v (%v)", entry, err)
+	}
+	if err := DeleteEntry(v, 1); !errors.Is(err, ErrVaultInUse) {
+		t.Errorf("Expected ErrVaultInUse for a write during a read, but got %v", err)
+	}
+}
+
+func TestNestedLocks(t *testing.T) {
+	v := testVault(t)
+	other := otherProcess(t, v)
+
+	release, err := v.lock(false)
+	if err != nil {
+		t.Fatalf("Locking the vault failed: %v", err)
+	}
+
+	// Upgrades the shared lock for the write, then hands it back
+	if err := SaveEntry(v, Entry{Id: 1, Title: "Title 1"}); err != nil {
+		t.Fatalf("SaveEntry failed under a shared lock: %v", err)
+	}
+	if v.held == nil || v.held.exclusive {
+		t.Errorf("Expected the shared lock to be held again, but got %+v", v.held)
+	}
+
+	if _, err := LoadEntry(other, 1); err != nil {
+		t.Errorf("Expected another reader to get in, but got %v", err)
+	}
+
+	release()
+	if v.held != nil {
+		t.Error("Expected the lock to be released")
+	}
+}
+
+func TestFailedUpgradeKeepsSharedLock(t *testing.T) {
+	v := testVault(t)
+	seedEntries(t, v, 1)
+	other := otherProcess(t, v)
+
+	release, err := v.lock(false)
+	if err != nil {
+		t.Fatalf("Locking the vault failed: %v", err)
+	}
+	defer release()
+
+	releaseOther, err := other.lock(false)
+	if err != nil {
+		t.Fatalf("Locking the vault failed: %v", err)
+	}
+	if err := SaveEntry(v, Entry{Id: 2, Title: "Title 2"}); !errors.Is(err, ErrVaultInUse) {
+		t.Fatalf("Expected ErrVaultInUse for a write during a read, but got %v", err)
+	}
+	releaseOther()
+
+	// flock drops the shared lock when the upgrade fails, it must be back
+	if v.held == nil || v.held.exclusive {
+		t.Errorf("Expected the shared lock to be held, but got %+v", v.held)
+	}
+	releaseWrite, err := other.lock(true)
+	if err == nil {
+		releaseWrite()
+	}
+	if !errors.Is(err, ErrVaultInUse) {
+		t.Errorf("Expected ErrVaultInUse for a write while the shared lock is held, but got %v", err)
+	}
+}
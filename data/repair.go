@@ -0,0 +1,250 @@
+package data
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+)
+
+var ErrRepairTargetExists = errors.New("a repaired vault already exists")
+
+// repairedSuffix names the repaired vault directory next to the original one.
+const repairedSuffix = ".repaired"
+
+// damagedFile holds the bytes of the damaged records in the repaired vault.
+const damagedFile = dataFile + ".damaged"
+
+// maxSaneId bounds entry ids. Ids are handed out one by one, so a larger one is
+// almost certainly garbage.
+const maxSaneId = 1 << 32
+
+// DamagedRange is a part of the data file that didn't hold an intact record.
+type DamagedRange struct {
+	Offset int64
+	Length int64
+	Reason string
+}
+
+// RepairReport describes what RepairDataFile salvaged.
+type RepairReport struct {
+	// Dir is the repaired vault, empty when the data file had no damage.
+	Dir       string
+	Recovered []int64
+	Damaged   []DamagedRange
+	// Integrity lists what fails the seal of the data file, when the vault key is set
+	Integrity []IntegrityProblem
+}
+
+// LostBytes returns how many bytes of the data file could not be recovered.
+func (r RepairReport) LostBytes() int64 {
+	var lost int64
+	for _, damaged := range r.Damaged {
+		lost += damaged.Length
+	}
+	return lost
+}
+
+// RepairDataFile walks the record stream of the data file and salvages every intact
+// entry. Damaged records are skipped by looking for the next offset where a sane
+// record starts. If anything was damaged, the salvaged entries are written to a new
+// vault next to v together with a copy of its vault header, and the damaged bytes
+// are kept there for manual inspection. v itself is never modified.
+//
+// With the vault key set, records that are readable but fail the seal are reported
+// too, and the repaired vault is sealed again so it can be checked and used.
+func RepairDataFile(v *Vault) (RepairReport, error) {
+	release, err := v.lock(false)
+	if err != nil {
+		return RepairReport{}, err
+	}
+	defer release()
+
+	raw, err := os.ReadFile(v.path(dataFile))
+	if err != nil {
+		return RepairReport{}, err
+	}
+
+	reader := bytes.NewReader(raw)
+	header, err := readDataHeader(reader)
+	if err != nil {
+		return RepairReport{}, err
+	}
+	start := len(raw) - reader.Len()
+
+	// The trailer of a sealed file is no record
+	end := len(raw)
+	if header.Flags.Has(FlagSealed) && end-start >= trailerSize {
+		end -= trailerSize
+	}
+
+	var report RepairReport
+	var entries []Entry
+	var damaged bytes.Buffer
+	seen := make(map[int64]bool)
+
+	damageStart, damageReason := -1, ""
+	endDamage := func(end int) {
+		if damageStart < 0 {
+			return
+		}
+		report.Damaged = append(report.Damaged, DamagedRange{
+			Offset: int64(damageStart),
+			Length: int64(end - damageStart),
+			Reason: damageReason,
+		})
+		damaged.Write(raw[damageStart:end])
+		damageStart = -1
+	}
+
+	for pos := start; pos < end; {
+		entry, n, err := parseRecord(raw[pos:end], header)
+		if err == nil && seen[entry.Id] {
+			err = fmt.Errorf("duplicate of entry %d", entry.Id)
+		}
+
+		if err != nil {
+			if damageStart < 0 {
+				damageStart, damageReason = pos, err.Error()
+			}
+			pos++
+			continue
+		}
+
+		endDamage(pos)
+		seen[entry.Id] = true
+		entries = append(entries, entry)
+		report.Recovered = append(report.Recovered, entry.Id)
+		pos += n
+	}
+	endDamage(end)
+
+	if header.Flags.Has(FlagSealed) && v.macKey != nil {
+		integrity, err := VerifyDataFile(v)
+		if err != nil {
+			return RepairReport{}, err
+		}
+		report.Integrity = integrity.Problems
+	}
+
+	if len(report.Damaged) == 0 && len(report.Integrity) == 0 {
+		return report, nil
+	}
+
+	report.Dir = v.Dir + repairedSuffix
+	if err := writeRepairedVault(v, report.Dir, header, entries, damaged.Bytes()); err != nil {
+		return RepairReport{}, err
+	}
+
+	return report, nil
+}
+
+// parseRecord reads the record at the start of raw and checks it is sane. It returns
+// the number of bytes the record takes.
+func parseRecord(raw []byte, header DataHeader) (Entry, int, error) {
+	reader := bytes.NewReader(raw)
+	entry, err := readEntry(reader, header)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return Entry{}, 0, errors.New("truncated record")
+		}
+		return Entry{}, 0, err
+	}
+
+	if entry.Id < 1 || entry.Id > maxSaneId {
+		return Entry{}, 0, fmt.Errorf("implausible entry id %d", entry.Id)
+	}
+
+	fields := []string{entry.Username, entry.Password, entry.Address, entry.Notes}
+	for _, change := range entry.History {
+		fields = append(fields, change.Password)
+	}
+	for _, field := range entry.Fields {
+		fields = append(fields, field.Name, field.Value)
+	}
+	fields = append(fields, entry.Tags...)
+	for _, attachment := range entry.Attachments {
+		if !attachmentFilePattern.MatchString(attachment.File) {
+			return Entry{}, 0, errors.New("attachment file name is not valid")
+		}
+		fields = append(fields, attachment.Name, attachment.Sum)
+	}
+	for _, optional := range []string{entry.Folder, entry.OTP} {
+		if optional != "" {
+			fields = append(fields, optional)
+		}
+	}
+	if header.Flags.Has(FlagEncryptedTitles) {
+		fields = append(fields, entry.Title)
+	} else if !utf8.ValidString(entry.Title) {
+		return Entry{}, 0, errors.New("title is not valid text")
+	}
+
+	for _, field := range fields {
+		if !isCipherText(field) {
+			return Entry{}, 0, errors.New("field is not encrypted data")
+		}
+	}
+
+	return entry, len(raw) - reader.Len(), nil
+}
+
+// isCipherText reports whether value looks like the hex encoding of encrypted data.
+func isCipherText(value string) bool {
+	if value == "" {
+		return false
+	}
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+func writeRepairedVault(v *Vault, dir string, header DataHeader, entries []Entry, damaged []byte) error {
+	if fileExists(dir) {
+		return fmt.Errorf("%w: %v", ErrRepairTargetExists, dir)
+	}
+
+	repaired, err := OpenVault(dir)
+	if err != nil {
+		return err
+	}
+	repaired.macKey = v.macKey
+
+	// The key is the same, so the repaired vault unlocks with the same password
+	for _, name := range []string{passwordVerifyFile, passwordVerifyFile + stagedSuffix} {
+		if err := copyVaultFile(v, repaired, name); err != nil {
+			return err
+		}
+	}
+
+	err = writeDataFile(repaired, func(w *bufio.Writer) error {
+		return writeAllEntries(repaired, w, upgradeDataHeader(v, header), entries)
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(repaired.path(damagedFile), filePerm, func(file *os.File) error {
+		_, err := file.Write(damaged)
+		return err
+	})
+}
+
+// copyVaultFile copies the named file from one vault to another, if it exists.
+func copyVaultFile(from, to *Vault, name string) error {
+	raw, err := os.ReadFile(from.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(to.path(name), filePerm, func(file *os.File) error {
+		_, err := file.Write(raw)
+		return err
+	})
+}
@@ -0,0 +1,180 @@
+package data
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// seedCipherEntries saves n entries whose fields look like encrypted data.
+func seedCipherEntries(t *testing.T, v *Vault, n int64) {
+	for i := int64(1); i <= n; i++ {
+		field := func(name string) string {
+			return hex.EncodeToString([]byte(name + " " + fmt.Sprint(i)))
+		}
+		entry := Entry{
+			Id:       i,
+			Title:    field("title"),
+			Username: field("user"),
+			Password: field("pass"),
+			Address:  field("address"),
+			Notes:    field("notes"),
+		}
+		if err := SaveEntry(v, entry); err != nil {
+			t.Fatalf("SaveEntry failed for ID %v: %v", i, err)
+		}
+	}
+
+	if err := SaveVaultHeader(v, VaultHeader{Verifier: "verifier"}); err != nil {
+		t.Fatalf("SaveVaultHeader failed: %v", err)
+	}
+}
+
+func TestRepairIntactDataFile(t *testing.T) {
+	v := testVault(t)
+	seedCipherEntries(t, v, 3)
+
+	report, err := RepairDataFile(v)
+	if err != nil {
+		t.Fatalf("RepairDataFile failed: %v", err)
+	}
+
+	if report.Dir != "" || len(report.Damaged) != 0 {
+		t.Errorf("Expected no damage, but got %+v", report)
+	}
+	if len(report.Recovered) != 3 {
+		t.Errorf("Expected 3 recovered entries, but got %v", report.Recovered)
+	}
+	if fileExists(v.Dir + repairedSuffix) {
+		t.Error("Expected no repaired vault for an intact data file")
+	}
+}
+
+func TestRepairDamagedRecord(t *testing.T) {
+	v := testVault(t)
+	seedCipherEntries(t, v, 5)
+
+	index, err := v.loadIndex()
+	if err != nil {
+		t.Fatalf("Building the index failed: %v", err)
+	}
+
+	raw, _ := os.ReadFile(v.path(dataFile))
+	original := append([]byte(nil), raw...)
+
+	// Scribble over the title length of entry 3
+	copy(raw[index.offsets[3]+8:], bytes.Repeat([]byte{0xff}, 8))
+	if err := os.WriteFile(v.path(dataFile), raw, filePerm); err != nil {
+		t.Fatalf("Writing the damaged file failed: %v", err)
+	}
+
+	report, err := RepairDataFile(v)
+	if err != nil {
+		t.Fatalf("RepairDataFile failed: %v", err)
+	}
+
+	if fmt.Sprint(report.Recovered) != "[1 2 4 5]" {
+		t.Errorf("Expected entries 1, 2, 4 and 5, but got %v", report.Recovered)
+	}
+	if len(report.Damaged) != 1 || report.Damaged[0].Offset != index.offsets[3] ||
+		report.Damaged[0].Length != index.offsets[4]-index.offsets[3] {
+		t.Errorf("Expected exactly entry 3 to be damaged, but got %+v", report.Damaged)
+	}
+
+	repaired := &Vault{Dir: report.Dir}
+	if count, err := CountEntries(repaired); err != nil || count != 4 {
+		t.Errorf("Expected 4 entries in the repaired vault, but got %v (%v)", count, err)
+	}
+	if entry, err := LoadEntry(repaired, 4); err != nil || entry.Title != hex.EncodeToString([]byte("title 4")) {
+		t.Errorf("Entry 4 was not recovered intact: %+v (%v)", entry, err)
+	}
+	if header, err := LoadVaultHeader(repaired); err != nil || header.Verifier != "verifier" {
+		t.Errorf("Expected the vault header to be copied, but got %+v (%v)", header, err)
+	}
+
+	quarantined, _ := os.ReadFile(repaired.path(damagedFile))
+	if int64(len(quarantined)) != report.LostBytes() {
+		t.Errorf("Expected %v damaged bytes to be kept, but got %v", report.LostBytes(), len(quarantined))
+	}
+
+	after, _ := os.ReadFile(v.path(dataFile))
+	if !bytes.Equal(after, raw) || bytes.Equal(after, original) {
+		t.Error("The original data file was modified")
+	}
+}
+
+func TestRepairTruncatedDataFile(t *testing.T) {
+	v := testVault(t)
+	seedCipherEntries(t, v, 3)
+
+	raw, _ := os.ReadFile(v.path(dataFile))
+	if err := os.WriteFile(v.path(dataFile), raw[:len(raw)-5], filePerm); err != nil {
+		t.Fatalf("Truncating the data file failed: %v", err)
+	}
+
+	report, err := RepairDataFile(v)
+	if err != nil {
+		t.Fatalf("RepairDataFile failed: %v", err)
+	}
+
+	if fmt.Sprint(report.Recovered) != "[1 2]" {
+		t.Errorf("Expected entries 1 and 2, but got %v", report.Recovered)
+	}
+	if len(report.Damaged) != 1 || report.Damaged[0].Reason != "truncated record" {
+		t.Errorf("Expected a truncated record, but got %+v", report.Damaged)
+	}
+}
+
+func TestRepairKeepsExistingRepairedVault(t *testing.T) {
+	v := testVault(t)
+	seedCipherEntries(t, v, 2)
+
+	raw, _ := os.ReadFile(v.path(dataFile))
+	os.WriteFile(v.path(dataFile), raw[:len(raw)-1], filePerm)
+
+	if _, err := RepairDataFile(v); err != nil {
+		t.Fatalf("RepairDataFile failed: %v", err)
+	}
+
+	if _, err := RepairDataFile(v); !errors.Is(err, ErrRepairTargetExists) {
+		t.Errorf("Expected ErrRepairTargetExists, but got %v", err)
+	}
+}
+
+func TestRepairReportsBrokenSeal(t *testing.T) {
+	v := testVault(t)
+	seedCipherEntries(t, v, 3)
+
+	index, err := v.loadIndex()
+	if err != nil {
+		t.Fatalf("Building the index failed: %v", err)
+	}
+
+	// Drop entry 2, every record left is still readable
+	raw, _ := os.ReadFile(v.path(dataFile))
+	raw = append(raw[:index.offsets[2]:index.offsets[2]], raw[index.offsets[3]:]...)
+	if err := os.WriteFile(v.path(dataFile), raw, filePerm); err != nil {
+		t.Fatalf("Writing the modified file failed: %v", err)
+	}
+
+	report, err := RepairDataFile(v)
+	if err != nil {
+		t.Fatalf("RepairDataFile failed: %v", err)
+	}
+
+	if fmt.Sprint(report.Recovered) != "[1 3]" || len(report.Damaged) != 0 {
+		t.Errorf("Expected entries 1 and 3 without damage, but got %+v", report)
+	}
+	if len(report.Integrity) == 0 || report.Dir == "" {
+		t.Fatalf("Expected the broken seal to be reported, but got %+v", report)
+	}
+
+	repaired := &Vault{Dir: report.Dir}
+	repaired.SetKey(testKey)
+	if verified, err := VerifyDataFile(repaired); err != nil || !verified.OK() {
+		t.Errorf("Expected the repaired vault to be sealed again, but got %+v (%v)", verified, err)
+	}
+}
@@ -0,0 +1,283 @@
+package data
+
+import (
+	"fmt"
+	"squirrel/types"
+	"time"
+)
+
+// Store keeps the entries of a vault. Commands work on a Store so they don't
+// depend on where the entries live.
+type Store interface {
+	// Load returns the entry with id, or ErrEntryNotFound, or ErrEntryTrashed when
+	// it is in the trash.
+	Load(id int64) (Entry, error)
+	// Save adds a new entry, or returns ErrEntryExists if its id is taken.
+	Save(entry Entry) error
+	// Update replaces the entry with id, or returns ErrEntryNotFound or
+	// ErrEntryTrashed.
+	Update(id int64, entry Entry) error
+	// Delete removes the entry with id for good, or returns ErrEntryNotFound.
+	Delete(id int64) error
+	// List returns up to limit entries with decrypted titles, usernames, folders and
+	// tags, sorted by o.
+	List(o Order, limit int, d types.Decryptor) ([]Entry, error)
+	// Count returns the number of entries.
+	Count() (int64, error)
+	// LargestId returns the largest entry id, or 0 when there are no entries.
+	// Entries in the trash count, so their ids are not handed out again.
+	LargestId() (int64, error)
+
+	// Trash moves the entry with id to the trash, or returns ErrEntryNotFound or
+	// ErrEntryTrashed.
+	Trash(id int64, now time.Time) error
+	// Undelete takes the entry with id out of the trash, or returns
+	// ErrEntryNotFound or ErrEntryNotTrashed.
+	Undelete(id int64) error
+	// ListTrash returns the entries in the trash like List does, the most recently
+	// deleted first.
+	ListTrash(d types.Decryptor) ([]Entry, error)
+	// PurgeTrash removes the entries moved to the trash before the given time for
+	// good, and returns how many there were.
+	PurgeTrash(before time.Time) (int, error)
+
+	// SaveAttachment keeps the encrypted contents of an attachment and returns the
+	// file they are kept in.
+	SaveAttachment(contents string) (string, error)
+	// LoadAttachment returns the encrypted contents kept in file, or
+	// ErrAttachmentNotFound.
+	LoadAttachment(file string) (string, error)
+}
+
+// FileStore keeps the entries in the data file of a vault.
+type FileStore struct {
+	vault *Vault
+}
+
+func NewFileStore(v *Vault) *FileStore {
+	return &FileStore{vault: v}
+}
+
+func (s *FileStore) Load(id int64) (Entry, error) {
+	if !HasDataFile(s.vault) {
+		return Entry{}, ErrEntryNotFound
+	}
+
+	entry, err := LoadEntry(s.vault, id)
+	if err == nil && entry.Trashed() {
+		return Entry{}, ErrEntryTrashed
+	}
+	return entry, err
+}
+
+func (s *FileStore) Save(entry Entry) error {
+	return SaveEntry(s.vault, entry)
+}
+
+func (s *FileStore) Update(id int64, entry Entry) error {
+	if _, err := s.Load(id); err != nil {
+		return err
+	}
+	return UpdateEntry(s.vault, id, entry)
+}
+
+func (s *FileStore) Delete(id int64) error {
+	if !HasDataFile(s.vault) {
+		return ErrEntryNotFound
+	}
+	return DeleteEntry(s.vault, id)
+}
+
+func (s *FileStore) List(o Order, limit int, d types.Decryptor) ([]Entry, error) {
+	if !HasDataFile(s.vault) {
+		return nil, nil
+	}
+	return Entries(s.vault, o, limit, d)
+}
+
+func (s *FileStore) Count() (int64, error) {
+	if !HasDataFile(s.vault) {
+		return 0, nil
+	}
+	return CountEntries(s.vault)
+}
+
+func (s *FileStore) LargestId() (int64, error) {
+	return GetLargestId(s.vault)
+}
+
+func (s *FileStore) Trash(id int64, now time.Time) error {
+	if !HasDataFile(s.vault) {
+		return ErrEntryNotFound
+	}
+	return TrashEntry(s.vault, id, now)
+}
+
+func (s *FileStore) Undelete(id int64) error {
+	if !HasDataFile(s.vault) {
+		return ErrEntryNotFound
+	}
+	return UndeleteEntry(s.vault, id)
+}
+
+func (s *FileStore) ListTrash(d types.Decryptor) ([]Entry, error) {
+	if !HasDataFile(s.vault) {
+		return nil, nil
+	}
+	return TrashedEntries(s.vault, d)
+}
+
+func (s *FileStore) PurgeTrash(before time.Time) (int, error) {
+	if !HasDataFile(s.vault) {
+		return 0, nil
+	}
+	return PurgeTrash(s.vault, before)
+}
+
+func (s *FileStore) SaveAttachment(contents string) (string, error) {
+	return SaveAttachment(s.vault, contents)
+}
+
+func (s *FileStore) LoadAttachment(file string) (string, error) {
+	return LoadAttachment(s.vault, file)
+}
+
+// MemoryStore keeps the entries in memory, in the order they were saved.
+type MemoryStore struct {
+	entries     []Entry
+	attachments map[string]string
+}
+
+func NewMemoryStore(entries ...Entry) *MemoryStore {
+	return &MemoryStore{entries: append([]Entry(nil), entries...)}
+}
+
+func (s *MemoryStore) Load(id int64) (Entry, error) {
+	i := s.find(id)
+	if i < 0 {
+		return Entry{}, ErrEntryNotFound
+	}
+	if s.entries[i].Trashed() {
+		return Entry{}, ErrEntryTrashed
+	}
+	return s.entries[i], nil
+}
+
+func (s *MemoryStore) Save(entry Entry) error {
+	if s.find(entry.Id) >= 0 {
+		return ErrEntryExists
+	}
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *MemoryStore) Update(id int64, entry Entry) error {
+	if _, err := s.Load(id); err != nil {
+		return err
+	}
+	i := s.find(id)
+	entry.Id = id
+	s.entries[i] = entry
+	return nil
+}
+
+func (s *MemoryStore) Delete(id int64) error {
+	i := s.find(id)
+	if i < 0 {
+		return ErrEntryNotFound
+	}
+	s.entries = append(s.entries[:i], s.entries[i+1:]...)
+	return nil
+}
+
+func (s *MemoryStore) List(o Order, limit int, d types.Decryptor) ([]Entry, error) {
+	entries := withoutTrashed(s.entries)
+	if err := decryptListed(entries, d); err != nil {
+		return nil, err
+	}
+	return sortEntries(entries, o, limit)
+}
+
+func (s *MemoryStore) Count() (int64, error) {
+	return int64(len(withoutTrashed(s.entries))), nil
+}
+
+func (s *MemoryStore) LargestId() (int64, error) {
+	var largestId int64
+	for _, entry := range s.entries {
+		if entry.Id > largestId {
+			largestId = entry.Id
+		}
+	}
+	return largestId, nil
+}
+
+func (s *MemoryStore) Trash(id int64, now time.Time) error {
+	if _, err := s.Load(id); err != nil {
+		return err
+	}
+	s.entries[s.find(id)].Deleted = now
+	return nil
+}
+
+func (s *MemoryStore) Undelete(id int64) error {
+	i := s.find(id)
+	if i < 0 {
+		return ErrEntryNotFound
+	}
+	if !s.entries[i].Trashed() {
+		return ErrEntryNotTrashed
+	}
+	s.entries[i].Deleted = time.Time{}
+	return nil
+}
+
+func (s *MemoryStore) ListTrash(d types.Decryptor) ([]Entry, error) {
+	entries := sortTrashed(s.entries)
+	if err := decryptListed(entries, d); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (s *MemoryStore) PurgeTrash(before time.Time) (int, error) {
+	var kept []Entry
+	for _, entry := range s.entries {
+		if !entry.Trashed() || !entry.Deleted.Before(before) {
+			kept = append(kept, entry)
+		}
+	}
+
+	purged := len(s.entries) - len(kept)
+	s.entries = kept
+	return purged, nil
+}
+
+func (s *MemoryStore) SaveAttachment(contents string) (string, error) {
+	if len(contents) > maxStoredAttachmentSize {
+		return "", ErrAttachmentTooLarge
+	}
+	if s.attachments == nil {
+		s.attachments = map[string]string{}
+	}
+	file := fmt.Sprintf("%032x", len(s.attachments)+1)
+	s.attachments[file] = contents
+	return file, nil
+}
+
+func (s *MemoryStore) LoadAttachment(file string) (string, error) {
+	contents, ok := s.attachments[file]
+	if !ok {
+		return "", ErrAttachmentNotFound
+	}
+	return contents, nil
+}
+
+func (s *MemoryStore) find(id int64) int {
+	for i, entry := range s.entries {
+		if entry.Id == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,186 @@
+package data
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func identity(value string) (string, error) {
+	return value, nil
+}
+
+// testStore checks the behavior every Store must share.
+func testStore(t *testing.T, s Store) {
+	if count, err := s.Count(); err != nil || count != 0 {
+		t.Fatalf("Expected an empty store, but got %v entries (%v)", count, err)
+	}
+	if largest, err := s.LargestId(); err != nil || largest != 0 {
+		t.Fatalf("Expected 0 as the largest ID of an empty store, but got %v (%v)", largest, err)
+	}
+	if _, err := s.Load(1); err != ErrEntryNotFound {
+		t.Errorf("Expected ErrEntryNotFound from an empty store, but got %v", err)
+	}
+	if entries, err := s.List(ByTitle, 10, identity); err != nil || len(entries) != 0 {
+		t.Errorf("Expected no entries, but got %v (%v)", entries, err)
+	}
+
+	for _, entry := range []Entry{
+		{Id: 1, Title: "gmail", Username: "bob"},
+		{Id: 3, Title: "aws", Username: "alice"},
+		{Id: 2, Title: "bank", Username: "carol"},
+	} {
+		if err := s.Save(entry); err != nil {
+			t.Fatalf("Save failed for ID %v: %v", entry.Id, err)
+		}
+	}
+
+	if err := s.Save(Entry{Id: 3, Title: "duplicate"}); err != ErrEntryExists {
+		t.Errorf("Expected ErrEntryExists, but got %v", err)
+	}
+
+	if count, _ := s.Count(); count != 3 {
+		t.Errorf("Expected 3 entries, but got %v", count)
+	}
+	if largest, _ := s.LargestId(); largest != 3 {
+		t.Errorf("Expected 3 as the largest ID, but got %v", largest)
+	}
+
+	if err := s.Update(2, Entry{Id: 2, Title: "bank", Username: "dave"}); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if entry, _ := s.Load(2); entry.Username != "dave" {
+		t.Errorf("Expected the updated username, but got %+v", entry)
+	}
+	if err := s.Update(42, Entry{Id: 42}); err != ErrEntryNotFound {
+		t.Errorf("Expected ErrEntryNotFound, but got %v", err)
+	}
+
+	entries, err := s.List(ByUsername, 2, identity)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Username != "alice" || entries[1].Username != "bob" {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+
+	if err := s.Delete(1); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := s.Delete(1); err != ErrEntryNotFound {
+		t.Errorf("Expected ErrEntryNotFound, but got %v", err)
+	}
+	if _, err := s.Load(1); err != ErrEntryNotFound {
+		t.Errorf("Expected ErrEntryNotFound for a deleted entry, but got %v", err)
+	}
+
+	entries, _ = s.List(ById, 10, identity)
+	if len(entries) != 2 || entries[0].Id != 2 || entries[1].Id != 3 {
+		t.Errorf("Unexpected entries %+v", entries)
+	}
+
+	testTrash(t, s)
+	testAttachments(t, s)
+}
+
+// testTrash expects entries 2 and 3 in s.
+func testTrash(t *testing.T, s Store) {
+	deleted := time.Unix(1700000000, 0)
+
+	if err := s.Trash(3, deleted); err != nil {
+		t.Fatalf("Trash failed: %v", err)
+	}
+	if err := s.Trash(3, deleted); err != ErrEntryTrashed {
+		t.Errorf("Expected ErrEntryTrashed, but got %v", err)
+	}
+	if _, err := s.Load(3); err != ErrEntryTrashed {
+		t.Errorf("Expected ErrEntryTrashed from Load, but got %v", err)
+	}
+	if err := s.Update(3, Entry{Id: 3}); err != ErrEntryTrashed {
+		t.Errorf("Expected ErrEntryTrashed from Update, but got %v", err)
+	}
+
+	if count, _ := s.Count(); count != 1 {
+		t.Errorf("Expected 1 entry outside the trash, but got %v", count)
+	}
+	if largest, _ := s.LargestId(); largest != 3 {
+		t.Errorf("Expected the trashed ID to stay taken, but got %v", largest)
+	}
+	if entries, _ := s.List(ById, 10, identity); len(entries) != 1 || entries[0].Id != 2 {
+		t.Errorf("Expected only entry 2 to be listed, but got %+v", entries)
+	}
+
+	trashed, err := s.ListTrash(identity)
+	if err != nil || len(trashed) != 1 || trashed[0].Title != "aws" || !trashed[0].Deleted.Equal(deleted) {
+		t.Errorf("Expected entry 3 in the trash, but got %+v (%v)", trashed, err)
+	}
+
+	if err := s.Undelete(2); err != ErrEntryNotTrashed {
+		t.Errorf("Expected ErrEntryNotTrashed, but got %v", err)
+	}
+	if err := s.Undelete(3); err != nil {
+		t.Fatalf("Undelete failed: %v", err)
+	}
+	if entry, err := s.Load(3); err != nil || entry.Title != "aws" {
+		t.Errorf("Expected entry 3 to be back, but got %+v (%v)", entry, err)
+	}
+
+	s.Trash(2, deleted)
+	s.Trash(3, deleted.Add(time.Hour))
+
+	if purged, err := s.PurgeTrash(deleted.Add(time.Minute)); err != nil || purged != 1 {
+		t.Errorf("Expected 1 entry to be purged, but got %v (%v)", purged, err)
+	}
+	if trashed, _ := s.ListTrash(identity); len(trashed) != 1 || trashed[0].Id != 3 {
+		t.Errorf("Expected entry 3 left in the trash, but got %+v", trashed)
+	}
+	if err := s.Undelete(2); err != ErrEntryNotFound {
+		t.Errorf("Expected ErrEntryNotFound for a purged entry, but got %v", err)
+	}
+}
+
+func testAttachments(t *testing.T, s Store) {
+	file, err := s.SaveAttachment("encrypted contents")
+	if err != nil {
+		t.Fatalf("SaveAttachment failed: %v", err)
+	}
+	other, err := s.SaveAttachment("other contents")
+	if err != nil || other == file {
+		t.Fatalf("Expected a second attachment file, but got %q (%v)", other, err)
+	}
+
+	if contents, err := s.LoadAttachment(file); err != nil || contents != "encrypted contents" {
+		t.Errorf("Expected the contents back, but got %q (%v)", contents, err)
+	}
+	if _, err := s.LoadAttachment(strings.Repeat("0", 32)); !errors.Is(err, ErrAttachmentNotFound) {
+		t.Errorf("Expected ErrAttachmentNotFound, but got %v", err)
+	}
+	if _, err := s.SaveAttachment(strings.Repeat("a", maxStoredAttachmentSize+1)); err != ErrAttachmentTooLarge {
+		t.Errorf("Expected ErrAttachmentTooLarge, but got %v", err)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	testStore(t, NewFileStore(testVault(t)))
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestMemoryStoreListDoesNotDecryptInPlace(t *testing.T) {
+	s := NewMemoryStore(Entry{Id: 1, Title: "encrypted", Folder: "encrypted", Tags: []string{"encrypted"}})
+
+	listed, err := s.List(ByTitle, 10, func(string) (string, error) { return "plain", nil })
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if listed[0].Folder != "plain" || listed[0].Tags[0] != "plain" {
+		t.Errorf("Expected the folder and tags to be decrypted, but got %+v", listed[0])
+	}
+
+	if entry, _ := s.Load(1); entry.Title != "encrypted" || entry.Tags[0] != "encrypted" {
+		t.Errorf("The stored entry was modified: %+v", entry)
+	}
+}
@@ -0,0 +1,126 @@
+package data
+
+import (
+	"bufio"
+	"errors"
+	"sort"
+	"squirrel/types"
+	"time"
+)
+
+var ErrEntryTrashed = errors.New("entry is in the trash")
+var ErrEntryNotTrashed = errors.New("entry is not in the trash")
+
+// The trash keeps deleted entries in the data file with the time they were deleted,
+// until they are undeleted or purged. Stores hide them from everything else.
+
+// TrashEntry moves the entry with id to the trash.
+func TrashEntry(v *Vault, id int64, now time.Time) error {
+	return setDeleted(v, id, now)
+}
+
+// UndeleteEntry takes the entry with id out of the trash.
+func UndeleteEntry(v *Vault, id int64) error {
+	return setDeleted(v, id, time.Time{})
+}
+
+func setDeleted(v *Vault, id int64, deleted time.Time) error {
+	release, err := v.lock(true)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	entry, err := LoadEntry(v, id)
+	if err != nil {
+		return err
+	}
+
+	if entry.Trashed() == !deleted.IsZero() {
+		if entry.Trashed() {
+			return ErrEntryTrashed
+		}
+		return ErrEntryNotTrashed
+	}
+
+	entry.Deleted = deleted
+	return UpdateEntry(v, id, entry)
+}
+
+// TrashedEntries returns the entries in the trash with decrypted titles and
+// usernames, the most recently deleted first.
+func TrashedEntries(v *Vault, d types.Decryptor) ([]Entry, error) {
+	release, err := v.lock(false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	all, err := entries(v, d)
+	if err != nil {
+		return nil, err
+	}
+
+	return sortTrashed(all), nil
+}
+
+// PurgeTrash removes the entries that were moved to the trash before the given time
+// for good, and returns how many there were.
+func PurgeTrash(v *Vault, before time.Time) (int, error) {
+	release, err := v.lock(true)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	header, all, err := loadAllEntries(v)
+	if err != nil {
+		return 0, err
+	}
+
+	kept := all[:0]
+	for _, entry := range all {
+		if !entry.Trashed() || !entry.Deleted.Before(before) {
+			kept = append(kept, entry)
+		}
+	}
+
+	purged := len(all) - len(kept)
+	if purged == 0 {
+		return 0, nil
+	}
+
+	err = writeDataFile(v, func(w *bufio.Writer) error {
+		return writeAllEntries(v, w, upgradeDataHeader(v, header), kept)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	v.sweep()
+	return purged, nil
+}
+
+func withoutTrashed(entries []Entry) []Entry {
+	var live []Entry
+	for _, entry := range entries {
+		if !entry.Trashed() {
+			live = append(live, entry)
+		}
+	}
+	return live
+}
+
+func sortTrashed(entries []Entry) []Entry {
+	var trashed []Entry
+	for _, entry := range entries {
+		if entry.Trashed() {
+			trashed = append(trashed, entry)
+		}
+	}
+
+	sort.SliceStable(trashed, func(i, j int) bool {
+		return trashed[i].Deleted.After(trashed[j].Deleted)
+	})
+	return trashed
+}
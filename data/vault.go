@@ -0,0 +1,112 @@
+package data
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"squirrel/secure"
+)
+
+// HomeEnv overrides the vault directory when no --vault flag is given.
+const HomeEnv = "SQUIRREL_HOME"
+
+// DefaultVault is the vault stored directly in the resolved vault directory.
+// Other named vaults live in its vaults/ subdirectory.
+const DefaultVault = "default"
+
+const namedVaultsDir = "vaults"
+
+var ErrInvalidVaultName = errors.New("vault names may only contain letters, digits, '-' and '_'")
+
+var vaultNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Vault is the directory that holds the files of one vault.
+type Vault struct {
+	Dir string
+
+	index *entryIndex
+	// macKey seals the data file, it is nil until SetKey is called
+	macKey []byte
+	// held is the lock of the operation in progress, see lock.go
+	held *vaultLock
+	// Warn is told about problems that don't fail the operation at hand. It may
+	// be nil.
+	Warn func(err error)
+}
+
+// OpenVault returns the vault in dir, creating the directory if it doesn't exist.
+func OpenVault(dir string) (*Vault, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	return &Vault{Dir: dir}, nil
+}
+
+// ResolveVaultDir returns the directory of the default vault: dir when it is set,
+// then $SQUIRREL_HOME, then $XDG_DATA_HOME/squirrel, which defaults to
+// ~/.local/share/squirrel.
+func ResolveVaultDir(dir string) (string, error) {
+	if dir == "" {
+		dir = os.Getenv(HomeEnv)
+	}
+
+	if dir == "" {
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		// The XDG spec says relative paths must be ignored
+		if dataHome == "" || !filepath.IsAbs(dataHome) {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+		dir = filepath.Join(dataHome, "squirrel")
+	}
+
+	return filepath.Abs(dir)
+}
+
+// VaultDir returns the directory of the named vault under home.
+func VaultDir(home, name string) (string, error) {
+	if name == DefaultVault {
+		return home, nil
+	}
+
+	if !vaultNamePattern.MatchString(name) {
+		return "", ErrInvalidVaultName
+	}
+
+	return filepath.Join(home, namedVaultsDir, name), nil
+}
+
+// ListVaults returns the names of the vaults under home, the default vault first.
+func ListVaults(home string) ([]string, error) {
+	dirs, err := os.ReadDir(filepath.Join(home, namedVaultsDir))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var names []string
+	for _, dir := range dirs {
+		if dir.IsDir() && vaultNamePattern.MatchString(dir.Name()) && dir.Name() != DefaultVault {
+			names = append(names, dir.Name())
+		}
+	}
+	sort.Strings(names)
+
+	return append([]string{DefaultVault}, names...), nil
+}
+
+// SetKey tells the vault its key once it is unlocked. From then on the data file is
+// sealed on every write.
+func (v *Vault) SetKey(key []byte) {
+	v.macKey = secure.DeriveSubkey(key, macPurpose)
+	v.index = nil
+}
+
+func (v *Vault) path(name string) string {
+	return filepath.Join(v.Dir, name)
+}
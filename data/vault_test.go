@@ -0,0 +1,117 @@
+package data
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveVaultDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(HomeEnv, "")
+	t.Setenv("XDG_DATA_HOME", "")
+
+	dir, err := ResolveVaultDir("")
+	if err != nil {
+		t.Fatalf("ResolveVaultDir failed: %v", err)
+	}
+	if expected := filepath.Join(home, ".local", "share", "squirrel"); dir != expected {
+		t.Errorf("Expected the XDG default %v, but got %v", expected, dir)
+	}
+
+	// A relative XDG_DATA_HOME is invalid and ignored
+	t.Setenv("XDG_DATA_HOME", "relative")
+	if dir, _ := ResolveVaultDir(""); dir != filepath.Join(home, ".local", "share", "squirrel") {
+		t.Errorf("Expected a relative XDG_DATA_HOME to be ignored, but got %v", dir)
+	}
+
+	xdg := filepath.Join(home, "xdg")
+	t.Setenv("XDG_DATA_HOME", xdg)
+	if dir, _ := ResolveVaultDir(""); dir != filepath.Join(xdg, "squirrel") {
+		t.Errorf("Expected %v, but got %v", filepath.Join(xdg, "squirrel"), dir)
+	}
+
+	squirrelHome := filepath.Join(home, "vault")
+	t.Setenv(HomeEnv, squirrelHome)
+	if dir, _ := ResolveVaultDir(""); dir != squirrelHome {
+		t.Errorf("Expected %v to win over XDG_DATA_HOME, but got %v", squirrelHome, dir)
+	}
+
+	flag := filepath.Join(home, "flag")
+	if dir, _ := ResolveVaultDir(flag); dir != flag {
+		t.Errorf("Expected the flag %v to win, but got %v", flag, dir)
+	}
+}
+
+func TestOpenVaultCreatesDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested", "vault")
+
+	v, err := OpenVault(dir)
+	if err != nil {
+		t.Fatalf("OpenVault failed: %v", err)
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil || !info.IsDir() {
+		t.Fatalf("Expected %v to be created", dir)
+	}
+
+	if err := SaveEntry(v, Entry{Id: 1, Title: "title"}); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+	if !fileExists(filepath.Join(dir, dataFile)) {
+		t.Error("Expected the data file inside the vault directory")
+	}
+}
+
+func TestVaultDir(t *testing.T) {
+	home := t.TempDir()
+
+	if dir, err := VaultDir(home, DefaultVault); err != nil || dir != home {
+		t.Errorf("Expected the default vault in %v, but got %v (%v)", home, dir, err)
+	}
+
+	if dir, err := VaultDir(home, "work"); err != nil || dir != filepath.Join(home, "vaults", "work") {
+		t.Errorf("Unexpected directory %v (%v)", dir, err)
+	}
+
+	for _, name := range []string{"", "..", "a/b", "work space"} {
+		if _, err := VaultDir(home, name); err != ErrInvalidVaultName {
+			t.Errorf("Expected ErrInvalidVaultName for %q, but got %v", name, err)
+		}
+	}
+}
+
+func TestListVaults(t *testing.T) {
+	home := t.TempDir()
+
+	names, err := ListVaults(home)
+	if err != nil {
+		t.Fatalf("ListVaults failed: %v", err)
+	}
+	if len(names) != 1 || names[0] != DefaultVault {
+		t.Errorf("Expected only the default vault, but got %v", names)
+	}
+
+	for _, name := range []string{"work", "personal"} {
+		dir, _ := VaultDir(home, name)
+		if _, err := OpenVault(dir); err != nil {
+			t.Fatalf("OpenVault failed: %v", err)
+		}
+	}
+
+	names, err = ListVaults(home)
+	if err != nil {
+		t.Fatalf("ListVaults failed: %v", err)
+	}
+	expected := []string{DefaultVault, "personal", "work"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected %v, but got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("Expected %v, but got %v", expected, names)
+		}
+	}
+}
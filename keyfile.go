@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"squirrel/app"
+	"squirrel/data"
+	l "squirrel/log"
+	"squirrel/secure"
+)
+
+var ErrKeyFileNeeded = errors.New("this vault can only be unlocked with its key file, run squirrel with --keyfile <path>")
+
+// keyFile is the key file given with --keyfile. It is used by the vaults that
+// need one and by the vaults created in this session.
+var keyFile string
+
+// readKeyFile returns the hash of the key file that was given.
+func readKeyFile() ([]byte, error) {
+	if keyFile == "" {
+		return nil, ErrKeyFileNeeded
+	}
+
+	hash, err := secure.ReadKeyFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't read the key file: %w", err)
+	}
+	return hash, nil
+}
+
+// deriveKey derives the key of the vault with the given header from pass, and
+// from the key file when the vault needs one.
+func deriveKey(pass []byte, header data.VaultHeader) ([]byte, error) {
+	if !header.KeyFile {
+		return secure.DeriveKey(pass, header.KDF)
+	}
+
+	hash, err := readKeyFile()
+	if err != nil {
+		return nil, err
+	}
+
+	secret := secure.CombineKeyFile(pass, hash)
+	defer clear(secret)
+	return secure.DeriveKey(secret, header.KDF)
+}
+
+// checkKeyFile tells, before the password is asked for, when the vault needs a key
+// file that can't be read, or when the key file that was given isn't needed.
+func checkKeyFile(header data.VaultHeader) error {
+	if !header.KeyFile {
+		if keyFile != "" {
+			l.Println("{yellow}This vault doesn't use a key file, {0} is not needed to unlock it.{/yellow}", keyFile)
+		}
+		return nil
+	}
+
+	_, err := readKeyFile()
+	return err
+}
+
+// prepareKeyFile makes sure the key file for a new vault exists, creating it after
+// asking, and reports whether the vault will need it.
+func prepareKeyFile() (bool, error) {
+	if keyFile == "" {
+		return false, nil
+	}
+
+	if _, err := os.Stat(keyFile); errors.Is(err, os.ErrNotExist) {
+		if !app.GetYesNoInput(l.Print, "There is no key file at "+keyFile+". Create it?") {
+			return false, ErrVaultNotCreated
+		}
+		if err := secure.NewKeyFile(keyFile); err != nil {
+			return false, fmt.Errorf("can't create the key file: %w", err)
+		}
+	}
+
+	if _, err := readKeyFile(); err != nil {
+		return false, err
+	}
+
+	l.Println("{magenta}The vault will need the key file {0} as well as the password to unlock. Keep a copy of it somewhere safe, without it the vault can't be opened.{/magenta}", keyFile)
+	return true, nil
+}
+
+// createKeyFile writes a new random key file and reports how it went.
+func createKeyFile(path string) {
+	if err := secure.NewKeyFile(path); err != nil {
+		l.Println("{red}Can't create the key file!{/red} {0}", err)
+		os.Exit(1)
+	}
+
+	l.Println("{green}Created key file {0}.{/green} Open or create a vault with {brightWhite}--keyfile {0}{/brightWhite} to use it.", path)
+}
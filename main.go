@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"squirrel/app"
@@ -15,14 +16,18 @@ import (
 const appName = "Squirrel"
 const appVersion = "0.1.0"
 
+// maxPasswordAttempts is how often a wrong master password may be entered.
+const maxPasswordAttempts = 3
+
 var (
 	ErrWrongPassword error = errors.New("wrong password")
 )
 
 var (
-	state         data.State = data.State{}
-	password      []byte     = nil
-	encryptionKey []byte     = nil
+	state         data.State  = data.State{}
+	vault         *data.Vault = nil
+	password      []byte      = nil
+	encryptionKey []byte      = nil
 )
 
 type RunMode int8
@@ -32,63 +37,137 @@ const (
 	Safe
 )
 
-var commands = map[string]app.Command{
-	"version": app.VersionCommand(l.Println, appName, appVersion),
+var commands map[string]app.Command
+
+// trashDays is how long deleted entries stay in the trash.
+var trashDays = app.DefaultTrashDays
+
+// expiryDays is how many days ahead the startup banner warns about expiring entries.
+var expiryDays = app.DefaultExpiryWarningDays
+
+// exitStatus is the status squirrel exits with after running a single command.
+var exitStatus = 0
+
+// newCommands returns the commands working on the given store.
+func newCommands(v *data.Vault, s data.Store) map[string]app.Command {
+	return map[string]app.Command{
+		"list": app.ListCommand(l.Print, s, decryptor),
+		"ls":   app.ListCommand(l.Print, s, decryptor),
 
-	"list": app.ListCommand(l.Print, decryptor),
-	"ls":   app.ListCommand(l.Print, decryptor),
+		"new":    withBackup(app.NewCommand(l.Print, s, encryptor)),
+		"add":    withBackup(app.NewCommand(l.Print, s, encryptor)),
+		"create": withBackup(app.NewCommand(l.Print, s, encryptor)),
 
-	"new":    app.NewCommand(l.Print, encryptor),
-	"add":    app.NewCommand(l.Print, encryptor),
-	"create": app.NewCommand(l.Print, encryptor),
+		"delete": withBackup(app.DeleteCommand(l.Print, s, decryptor)),
+		"del":    withBackup(app.DeleteCommand(l.Print, s, decryptor)),
+		"remove": withBackup(app.DeleteCommand(l.Print, s, decryptor)),
 
-	"delete": app.DeleteCommand(l.Print),
-	"del":    app.DeleteCommand(l.Print),
-	"remove": app.DeleteCommand(l.Print),
+		"show": app.ShowCommand(l.Print, s, decryptor),
 
-	"show": app.ShowCommand(l.Print, decryptor),
+		// otp takes no snapshot for the HOTP counter, see the README
+		"otp": app.OTPCommand(l.Print, s, encryptor, decryptor),
 
-	"search": app.SearchCommand(l.Print, decryptor),
+		"attach":      withBackup(app.AttachCommand(l.Print, s, encryptor, decryptor)),
+		"attachments": app.AttachmentsCommand(l.Print, s, decryptor),
+		"extract":     app.ExtractCommand(l.Print, s, decryptor),
 
-	"edit": app.EditCommand(l.Print, encryptor, decryptor),
+		"tree": app.TreeCommand(l.Print, s, decryptor),
+		"mv":   withBackup(app.MoveCommand(l.Print, s, encryptor, decryptor)),
+		"tag":  withBackup(app.TagCommand(l.Print, s, encryptor, decryptor)),
+
+		"search": app.SearchCommand(l.Print, s, decryptor),
+
+		"edit": withBackup(app.EditCommand(l.Print, s, encryptor, decryptor)),
+
+		"trash":    app.TrashCommand(l.Print, s, decryptor, func() int { return trashDays }),
+		"undelete": withBackup(app.UndeleteCommand(l.Print, s)),
+		"purge":    withBackup(app.PurgeCommand(l.Print, s, decryptor)),
+
+		"expiring": app.ExpiringCommand(l.Print, s, decryptor, func(due int) {
+			if due > 0 {
+				exitStatus = 1
+			}
+		}),
 
-	"help": app.HelpCommand(l.Print),
+		"history": withBackup(app.HistoryCommand(l.Print, s, encryptor, decryptor)),
+
+		"verify": app.VerifyCommand(l.Print, v),
+
+		"backups": app.BackupsCommand(l.Print, v),
+		"backup":  app.BackupCommand(l.Print, backupVault),
+		"restore": app.RestoreCommand(l.Print, v, func() int { return keepBackups }, restoreBackup),
+
+		"passwd": app.PasswdCommand(l.Print, func(current []byte) error {
+			key, _, err := checkPassword(current)
+			clear(key)
+			return err
+		}, changePassword),
+		"rekey":         app.RekeyCommand(l.Print, vaultKDF, rekeyVault),
+		"kdf-benchmark": app.KDFBenchmarkCommand(l.Print, secure.TuneArgon2, vaultKDF, rekeyVault),
+	}
 }
 
 func main() {
+	vaultDir := flag.String("vault", "", "vault directory (default: $"+data.HomeEnv+", then $XDG_DATA_HOME/squirrel)")
+	flag.IntVar(&keepBackups, "keep-backups", data.DefaultKeepBackups, "how many backups to keep of each vault, 0 turns off backups before changes")
+	flag.IntVar(&trashDays, "trash-days", app.DefaultTrashDays, "how many days deleted entries stay in the trash, 0 keeps them until purged")
+	flag.IntVar(&expiryDays, "expiry-days", app.DefaultExpiryWarningDays, "how many days ahead to warn about expiring entries when a vault opens")
+	flag.StringVar(&keyFile, "keyfile", "", "key file needed to unlock the vault as well as the master password; new vaults will need it")
+	createKeyFilePath := flag.String("create-keyfile", "", "write a new random key file to the given path and exit")
+	fixDataFile := flag.Bool("fix-data-file", false, "salvage the entries of a damaged data file into a repaired vault")
+	flag.Parse()
+
 	app.Logo()
 	l.Println("{brightWhite}{0} v{1}{/brightWhite}", appName, appVersion)
 	printLow("Loading...\n")
 
-	key, err := signInOrInitialize()
+	if *createKeyFilePath != "" {
+		createKeyFile(*createKeyFilePath)
+		return
+	}
+
+	dir, err := data.ResolveVaultDir(*vaultDir)
 	if err != nil {
+		l.Println("{red}Can't locate the vault directory!{/red} {0}", err)
+		os.Exit(1)
+	}
+	home = dir
+
+	if *fixDataFile {
+		name := data.DefaultVault
+		if flag.NArg() > 0 {
+			name = flag.Arg(0)
+		}
+		repairMode(name)
+		return
+	}
+
+	if err := openVault(data.DefaultVault); err != nil {
 		switch err {
 		case ErrWrongPassword:
 			l.Println("{bgRed}WRONG PASSWORD!{/bgRed}")
 			os.Exit(1)
 		default:
-			l.Println("{red}Uknown error. {0}{/red}")
+			l.Println("{red}Can't open the vault!{/red} {0}", err)
 			os.Exit(1)
 		}
 	}
 
-	encryptionKey = key
-
-	runMode(os.Args)
+	runMode(flag.Args())
 }
 
+// runMode starts the prompt, or runs the command given as arguments and exits
+// with its status.
 func runMode(args []string) {
-	if len(args) == 1 {
+	if len(args) == 0 {
 		interactiveMode(int8(Normal))
 	} else {
-		os.Exit(1)
+		processInput(strings.Join(args, " "))
+		os.Exit(exitStatus)
 	}
 }
 
 func interactiveMode(mode int8) {
-	state = app.ReadState()
-	printLow("There are {0} entries.\n", state.Count)
-
 	reader := bufio.NewReader(os.Stdin)
 	for {
 		prompt()
@@ -116,13 +195,29 @@ func interactiveMode(mode int8) {
 }
 
 func prompt() {
-	l.Print("{brightGreen}🐿️ ❯{/brightGreen} ")
+	if vaultName == "" {
+		l.Print("{brightGreen}🐿️ {gray}(no vault){/gray} ❯{/brightGreen} ")
+		return
+	}
+	l.Print("{brightGreen}🐿️ {0} ❯{/brightGreen} ", vaultName)
 }
 
-func signInOrInitialize() ([]byte, error) {
-	firstRun := !data.HasPassVerifyFile()
+// signInOrInitialize unlocks the open vault, or chooses its master password when
+// the vault is new. hintCwd asks to look for a vault in the current directory.
+func signInOrInitialize(hintCwd bool) ([]byte, error) {
+	firstRun := !data.HasPassVerifyFile(vault)
 
 	if firstRun {
+		// Older versions kept the vault in the directory they were started from
+		if cwd, err := os.Getwd(); hintCwd && err == nil && cwd != vault.Dir && data.HasPassVerifyFile(&data.Vault{Dir: cwd}) {
+			l.Println("{yellow}There is a vault in the current directory. Run with {brightWhite}--vault .{/brightWhite} to keep using it.{/yellow}")
+		}
+
+		needsKeyFile, err := prepareKeyFile()
+		if err != nil {
+			return nil, err
+		}
+
 		l.Println("Initializing master password...")
 		l.Println("{magenta}Choose a secure password and make sure to remember it. Without this password, your data will not be recoverable, and there will be no way to reset it.{/magenta}")
 
@@ -138,7 +233,7 @@ func signInOrInitialize() ([]byte, error) {
 				show := app.GetYesNoInput(l.Print, "Do you need to see your password for 5 seconds?")
 
 				if show {
-					app.PrintSecret(l.Print, pass, 5)
+					app.PrintSecret(l.Print, "Your chosen master password", pass, 5)
 				}
 				break
 			}
@@ -146,60 +241,124 @@ func signInOrInitialize() ([]byte, error) {
 
 		password = []byte(pass)
 
-		salt, err := secure.DeriveKeyScrypt(password, []byte(""))
+		params, err := secure.NewKDFParams()
+		if err != nil {
+			return nil, fmt.Errorf("can't generate a salt: %w", err)
+		}
+		header := data.VaultHeader{KDF: params, KeyFile: needsKeyFile}
 
+		key, err := deriveKey(password, header)
 		if err != nil {
-			l.Println("{red}Can't generate a salt!{/red} {0}", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("can't derive the encryption key: %w", err)
 		}
 
-		key := secure.DeriveKeyPBKDF2(password, salt)
-		e, err := secure.EncryptAES("squirrel", key)
+		// checkIntegrity writes the data file, then marks the verifier sealed
+		e, err := secure.EncryptAES(verifierText, key)
 		if err != nil {
-			l.Println("{red}Can't encrypt sample text with given password!{/red} {0}", e)
-			os.Exit(1)
+			return nil, fmt.Errorf("can't encrypt sample text with given password: %w", err)
 		}
 
-		err = data.SavePassVerify(e)
+		vault.SetKey(key)
+
+		header.Verifier = e
+		err = data.SaveVaultHeader(vault, header)
 		if err != nil {
-			l.Println("{red}Can't write to disk!{/red} {0}", e)
-			os.Exit(1)
+			return nil, fmt.Errorf("can't write to disk: %w", err)
+		}
+
+		if err := checkIntegrity(key); err != nil {
+			return nil, err
 		}
 
 		return key, nil
 	} else {
-		var pass string
-		for {
-			app.ReadSecret("Enter password", "", false, l.Print, &pass)
-			password = []byte(pass)
+		header, err := data.LoadVaultHeader(vault)
+		if err != nil {
+			return nil, fmt.Errorf("can't read from disk: %w", err)
+		}
+
+		if err := checkKeyFile(header); err != nil {
+			return nil, err
+		}
 
-			salt, err := secure.DeriveKeyScrypt(password, []byte(""))
+		staged, hasStaged, err := data.LoadStagedVaultHeader(vault)
+		if err != nil {
+			return nil, fmt.Errorf("can't read from disk: %w", err)
+		}
 
+		headers := []data.VaultHeader{header}
+		if hasStaged {
+			// An interrupted password change may have left the vault under the new one
+			headers = append(headers, staged)
+		}
+
+		key, pass, legacyCipher, matched, err := unlockAny(headers...)
+		if err != nil {
+			return nil, err
+		}
+		password = pass
+
+		if hasStaged {
+			key, err = resumeKeyChange(staged, key, matched == 1)
 			if err != nil {
-				l.Println("{red}Can't generate a salt!{/red} {0}", err)
-				os.Exit(1)
+				return nil, err
 			}
+		}
 
-			key := secure.DeriveKeyPBKDF2(password, salt)
+		vault.SetKey(key)
+		if err := checkIntegrity(key); err != nil {
+			return nil, err
+		}
 
-			data, err := data.LoadPassVerify()
+		key, err = upgradeIfNeeded(header, key, legacyCipher)
+		if err != nil {
+			return nil, fmt.Errorf("upgrading the vault failed: %w", err)
+		}
+
+		return key, nil
+	}
+}
+
+// unlock asks for the master password until it decrypts the verifier in header,
+// and returns it with the key derived from it.
+func unlock(header data.VaultHeader) (key []byte, pass []byte, legacyCipher bool, err error) {
+	key, pass, legacyCipher, _, err = unlockAny(header)
+	return key, pass, legacyCipher, err
+}
+
+// unlockAny asks for the master password until it decrypts the verifier in one of
+// headers, and returns it with the key derived from it and the index of the header.
+func unlockAny(headers ...data.VaultHeader) (key []byte, pass []byte, legacyCipher bool, matched int, err error) {
+	var input string
+	for attempt := 1; ; attempt++ {
+		app.ReadSecret("Enter password", "", false, l.Print, &input)
+		pass = []byte(input)
+
+		for i, header := range headers {
+			key, err := deriveKey(pass, header)
 			if err != nil {
-				l.Println("{red}Can't read from disk!{/red} {0}", err)
-				os.Exit(1)
+				return nil, nil, false, 0, fmt.Errorf("can't derive the encryption key: %w", err)
 			}
 
-			d, err := secure.DecryptAES(data, key)
+			ok, legacyCipher, err := checkVerifier(header.Verifier, key)
 			if err != nil {
-				l.Println("{red}Can't decrypt!{/red} {0}", err)
-				os.Exit(1)
+				return nil, nil, false, 0, fmt.Errorf("can't decrypt: %w", err)
 			}
 
-			if d == "squirrel" {
-				return key, nil
-			} else {
-				l.Println("{brightWhite}Wrong password!{/white}")
+			if ok {
+				return key, pass, legacyCipher, i, nil
 			}
 		}
+
+		clear(pass)
+		if attempt == maxPasswordAttempts {
+			return nil, nil, false, 0, ErrWrongPassword
+		}
+		if headers[0].KeyFile {
+			l.Println("{brightWhite}Wrong password, or not the key file of this vault!{/white}")
+		} else {
+			l.Println("{brightWhite}Wrong password!{/white}")
+		}
 	}
 }
 
@@ -210,11 +369,23 @@ func printLow(template string, values ...interface{}) {
 func processInput(input string) {
 	parts := strings.Split(input, " ")
 
+	if command, exists := sessionCommands[parts[0]]; exists {
+		command(parts[1:]...)
+		return
+	}
+
+	if vault == nil {
+		exitStatus = 1
+		l.Println("No vault is open. Type {green}vaults{/green} to list them and {green}open <name>{/green} to open one.")
+		return
+	}
+
 	command, exists := commands[parts[0]]
 	if exists {
 		command(parts[1:]...)
 	} else {
 		command := strings.Split(input, " ")[0]
+		exitStatus = 1
 		l.Println("No {magenta}{0}{/magenta} command.Type {green}help{/green} for available commands.", command)
 	}
 
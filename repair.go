@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"squirrel/app"
+	"squirrel/data"
+	l "squirrel/log"
+)
+
+// repairMode salvages what it can from the data file of the named vault into a
+// repaired vault next to it, and reports what was recovered and what was lost.
+func repairMode(name string) {
+	dir, err := data.VaultDir(home, name)
+	if err != nil {
+		l.Println("{red}Can't locate vault {0}!{/red} {1}", name, err)
+		os.Exit(1)
+	}
+
+	v := &data.Vault{Dir: dir}
+	if !data.HasDataFile(v) {
+		l.Println("There is no data file in {0}.", dir)
+		os.Exit(1)
+	}
+
+	// The repaired vault is sealed with the key, so it passes the integrity check
+	header, err := data.LoadVaultHeader(v)
+	if err != nil {
+		l.Println("{red}Can't read the vault header!{/red} {0}", err)
+		os.Exit(1)
+	}
+
+	key, pass, _, err := unlock(header)
+	if err != nil {
+		l.Println("{red}Can't unlock vault {0}!{/red} {1}", name, err)
+		os.Exit(1)
+	}
+	v.SetKey(key)
+	clear(key)
+	clear(pass)
+
+	printLow("Checking {0}...\n", dir)
+
+	report, err := data.RepairDataFile(v)
+	if err != nil {
+		l.Println("{red}Repairing the data file failed!{/red} {0}", err)
+		os.Exit(1)
+	}
+
+	if report.Dir == "" {
+		l.Println("{green}The data file is intact. All {0} entries are readable.{/green}", len(report.Recovered))
+		return
+	}
+
+	l.Println("{yellow}Recovered {0} entries.{/yellow} IDs: {1}", len(report.Recovered), report.Recovered)
+	if len(report.Damaged) > 0 {
+		l.Println("{red}Lost {0} bytes in {1} damaged parts:{/red}", report.LostBytes(), len(report.Damaged))
+		for _, damaged := range report.Damaged {
+			l.Println("  offset {0}, {1} bytes: {2}", damaged.Offset, damaged.Length, damaged.Reason)
+		}
+	}
+	if len(report.Integrity) > 0 {
+		app.PrintIntegrityReport(l.Print, data.IntegrityReport{Problems: report.Integrity})
+		l.Println("{yellow}The recovered entries are sealed again in the repaired vault. Check them carefully.{/yellow}")
+	}
+
+	l.Println("The repaired vault is in {brightWhite}{0}{/brightWhite}. The original was not changed.", report.Dir)
+	l.Println("Check it with {green}squirrel --vault {0}{/green}, then replace the original with it.", report.Dir)
+}
@@ -0,0 +1,60 @@
+package secure
+
+import (
+	"runtime"
+	"time"
+)
+
+// MinArgon2Memory is the least memory, in KiB, that TuneArgon2 picks. It is the
+// minimum OWASP recommends for Argon2id.
+const MinArgon2Memory = 19 << 10
+
+// maxArgon2Time bounds the passes TuneArgon2 tries, for machines that are fast
+// enough to make no target reachable, and the passes a vault header can ask for.
+const maxArgon2Time = 64
+
+// TuneArgon2 returns the Argon2id parameters that take the longest to derive a
+// key on this machine without going over target, and how long they took. Memory
+// is doubled first, up to maxMemory KiB, then passes are added. Parameters below
+// the minimum are never picked, even when they take longer than target.
+func TuneArgon2(target time.Duration, maxMemory uint32) (KDFParams, time.Duration) {
+	return tuneArgon2(target, maxMemory, argon2Parallelism(), measureArgon2)
+}
+
+func tuneArgon2(target time.Duration, maxMemory uint32, parallelism uint32, measure func(KDFParams) time.Duration) (KDFParams, time.Duration) {
+	maxMemory = max(min(maxMemory, MaxArgon2Memory), MinArgon2Memory)
+
+	best := KDFParams{Algorithm: KDFArgon2id, Time: 2, Memory: MinArgon2Memory, Parallelism: parallelism}
+	took := measure(best)
+
+	for took <= target {
+		next := best
+		switch {
+		case next.Memory < maxMemory:
+			next.Memory = min(next.Memory*2, maxMemory)
+		case next.Time < maxArgon2Time:
+			next.Time++
+		default:
+			return best, took
+		}
+
+		d := measure(next)
+		if d > target {
+			break
+		}
+		best, took = next, d
+	}
+	return best, took
+}
+
+// argon2Parallelism uses as many lanes as there are cores, up to the default.
+func argon2Parallelism() uint32 {
+	return uint32(min(runtime.NumCPU(), DefaultArgon2Parallelism))
+}
+
+func measureArgon2(params KDFParams) time.Duration {
+	params.Salt = make([]byte, SaltSize)
+	start := time.Now()
+	DeriveKey([]byte("benchmark"), params)
+	return time.Since(start)
+}
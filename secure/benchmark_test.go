@@ -0,0 +1,70 @@
+package secure
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeArgon2 takes a millisecond per MiB and pass.
+func fakeArgon2(params KDFParams) time.Duration {
+	return time.Duration(params.Memory>>10*params.Time) * time.Millisecond
+}
+
+func TestTuneArgon2(t *testing.T) {
+	tests := []struct {
+		target    time.Duration
+		maxMemory uint32
+		memory    uint32
+		time      uint32
+	}{
+		// Memory grows first
+		{200 * time.Millisecond, 1 << 20, 76 << 10, 2},
+		// Then passes, once memory is at its maximum
+		{500 * time.Millisecond, 64 << 10, 64 << 10, 7},
+		// The minimum is kept even when it takes too long
+		{time.Millisecond, 1 << 20, MinArgon2Memory, 2},
+		// The maximum memory is never below the minimum
+		{time.Second, 1 << 10, MinArgon2Memory, 52},
+	}
+
+	for _, test := range tests {
+		params, took := tuneArgon2(test.target, test.maxMemory, 2, fakeArgon2)
+		if params.Algorithm != KDFArgon2id || params.Memory != test.memory || params.Time != test.time || params.Parallelism != 2 {
+			t.Errorf("%v: expected %v KiB and %v passes, but got %+v", test.target, test.memory, test.time, params)
+		}
+		if took != fakeArgon2(params) {
+			t.Errorf("%v: expected the time of the parameters, but got %v", test.target, took)
+		}
+	}
+}
+
+func TestDeriveKeyArgon2id(t *testing.T) {
+	params := KDFParams{Algorithm: KDFArgon2id, Salt: []byte("0123456789abcdef"), Time: 1, Memory: 64, Parallelism: 1}
+
+	key, err := DeriveKey([]byte("password"), params)
+	if err != nil {
+		t.Fatalf("DeriveKey failed: %v", err)
+	}
+	if len(key) != KeySize {
+		t.Fatalf("Expected key length of %d, but got %d", KeySize, len(key))
+	}
+
+	params.Time = 2
+	if other, _ := DeriveKey([]byte("password"), params); string(other) == string(key) {
+		t.Error("Expected a different key for different costs")
+	}
+
+	for _, bad := range []KDFParams{
+		{Algorithm: KDFArgon2id, Salt: params.Salt, Time: 0, Memory: 64, Parallelism: 1},
+		{Algorithm: KDFArgon2id, Salt: params.Salt, Time: 1 << 31, Memory: 64, Parallelism: 1},
+		{Algorithm: KDFArgon2id, Salt: params.Salt, Time: maxArgon2Time + 1, Memory: 64, Parallelism: 1},
+		{Algorithm: KDFArgon2id, Salt: params.Salt, Time: 1, Memory: 64, Parallelism: 0},
+		{Algorithm: KDFArgon2id, Salt: params.Salt, Time: 1, Memory: 64, Parallelism: 256},
+		{Algorithm: KDFArgon2id, Salt: params.Salt, Time: 1, Memory: 4, Parallelism: 1},
+		{Algorithm: KDFArgon2id, Salt: params.Salt, Time: 1, Memory: MaxArgon2Memory + 1, Parallelism: 1},
+	} {
+		if _, err := DeriveKey([]byte("password"), bad); err != ErrBadKDFParams {
+			t.Errorf("Expected ErrBadKDFParams for %+v, but got %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package secure
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+)
+
+// KeyFileSize is how many random bytes a new key file holds.
+const KeyFileSize = 64
+
+var ErrEmptyKeyFile = errors.New("key file is empty")
+
+// NewKeyFile writes a key file of random bytes, hex encoded so it survives being
+// copied around as text. It never replaces an existing file.
+func NewKeyFile(path string) error {
+	secret, err := GenerateSalt(KeyFileSize)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(file, hex.EncodeToString(secret)+"\n")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// ReadKeyFile returns the SHA-256 of the contents of a key file. Any file can be a
+// key file, what matters is that it never changes.
+func ReadKeyFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, file)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrEmptyKeyFile
+	}
+	return hash.Sum(nil), nil
+}
+
+// CombineKeyFile returns what the vault key is derived from when the vault needs
+// a key file: the hashes of the password and of the key file, so neither alone is
+// enough.
+func CombineKeyFile(password, keyFileHash []byte) []byte {
+	passwordHash := sha256.Sum256(password)
+	return append(passwordHash[:], keyFileHash...)
+}
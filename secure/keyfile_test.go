@@ -0,0 +1,86 @@
+package secure
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vault.key")
+
+	if err := NewKeyFile(path); err != nil {
+		t.Fatalf("NewKeyFile failed: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if info.Mode().Perm() != 0400 || info.Size() != 2*KeyFileSize+1 {
+		t.Errorf("Expected a read-only file of %v bytes, but got %v with %v bytes", 2*KeyFileSize+1, info.Mode(), info.Size())
+	}
+
+	first, err := ReadKeyFile(path)
+	if err != nil {
+		t.Fatalf("ReadKeyFile failed: %v", err)
+	}
+
+	if err := NewKeyFile(path); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Expected an existing key file to be kept, but got %v", err)
+	}
+	if again, _ := ReadKeyFile(path); !bytes.Equal(first, again) {
+		t.Error("The existing key file was changed")
+	}
+
+	other := filepath.Join(dir, "other.key")
+	if err := NewKeyFile(other); err != nil {
+		t.Fatalf("NewKeyFile failed: %v", err)
+	}
+	if second, _ := ReadKeyFile(other); bytes.Equal(first, second) {
+		t.Error("Expected different key files")
+	}
+}
+
+func TestReadKeyFile(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "photo.jpg")
+	if err := os.WriteFile(path, []byte("any file will do"), 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	hash, err := ReadKeyFile(path)
+	if err != nil || len(hash) != 32 {
+		t.Errorf("Expected a 32 byte hash, but got %x (%v)", hash, err)
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if _, err := ReadKeyFile(empty); err != ErrEmptyKeyFile {
+		t.Errorf("Expected ErrEmptyKeyFile, but got %v", err)
+	}
+
+	if _, err := ReadKeyFile(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing file error, but got %v", err)
+	}
+}
+
+func TestCombineKeyFile(t *testing.T) {
+	keyFile := bytes.Repeat([]byte{1}, 32)
+	combined := CombineKeyFile([]byte("password"), keyFile)
+
+	if bytes.Equal(combined, CombineKeyFile([]byte("other"), keyFile)) {
+		t.Error("Expected a different secret for a different password")
+	}
+	if bytes.Equal(combined, CombineKeyFile([]byte("password"), bytes.Repeat([]byte{2}, 32))) {
+		t.Error("Expected a different secret for a different key file")
+	}
+	if !bytes.Equal(combined, CombineKeyFile([]byte("password"), keyFile)) {
+		t.Error("Expected the same secret for the same inputs")
+	}
+}
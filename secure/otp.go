@@ -0,0 +1,186 @@
+package secure
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrBadOTPSecret is returned for one-time password secrets that are neither
+// base32 nor a valid otpauth:// URI.
+var ErrBadOTPSecret = errors.New("not a base32 secret or otpauth:// URI")
+
+// OTP kinds, as named in otpauth:// URIs.
+const (
+	TOTP = "totp"
+	HOTP = "hotp"
+)
+
+// OTP defaults, the values authenticator apps assume when a URI leaves them out.
+const (
+	DefaultOTPAlgorithm = "SHA1"
+	DefaultOTPDigits    = 6
+	DefaultOTPPeriod    = 30
+)
+
+var otpAlgorithms = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+var otpSecretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// OTPKey generates one-time passwords, time based (RFC 6238) or counter based
+// (RFC 4226).
+type OTPKey struct {
+	Kind      string
+	Label     string
+	Issuer    string
+	Secret    []byte
+	Algorithm string
+	Digits    int
+	// Period is how many seconds a TOTP code is valid
+	Period int
+	// Counter is the moving factor of the next HOTP code
+	Counter uint64
+}
+
+// ParseOTP reads a raw base32 secret, which makes a TOTP key with the defaults,
+// or an otpauth:// URI.
+func ParseOTP(value string) (OTPKey, error) {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(strings.ToLower(value), "otpauth://") {
+		return parseOTPURI(value)
+	}
+
+	secret, err := decodeOTPSecret(value)
+	if err != nil {
+		return OTPKey{}, err
+	}
+	return OTPKey{
+		Kind:      TOTP,
+		Secret:    secret,
+		Algorithm: DefaultOTPAlgorithm,
+		Digits:    DefaultOTPDigits,
+		Period:    DefaultOTPPeriod,
+	}, nil
+}
+
+func parseOTPURI(value string) (OTPKey, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return OTPKey{}, fmt.Errorf("%w: %v", ErrBadOTPSecret, err)
+	}
+
+	key := OTPKey{
+		Kind:      strings.ToLower(u.Host),
+		Label:     strings.TrimPrefix(u.Path, "/"),
+		Algorithm: DefaultOTPAlgorithm,
+		Digits:    DefaultOTPDigits,
+		Period:    DefaultOTPPeriod,
+	}
+	if key.Kind != TOTP && key.Kind != HOTP {
+		return OTPKey{}, fmt.Errorf("%w: unknown kind %q", ErrBadOTPSecret, u.Host)
+	}
+
+	query := u.Query()
+	key.Issuer = query.Get("issuer")
+	if key.Secret, err = decodeOTPSecret(query.Get("secret")); err != nil {
+		return OTPKey{}, err
+	}
+
+	if algorithm := query.Get("algorithm"); algorithm != "" {
+		key.Algorithm = strings.ToUpper(algorithm)
+		if otpAlgorithms[key.Algorithm] == nil {
+			return OTPKey{}, fmt.Errorf("%w: unsupported algorithm %q", ErrBadOTPSecret, algorithm)
+		}
+	}
+
+	if digits := query.Get("digits"); digits != "" {
+		key.Digits, err = strconv.Atoi(digits)
+		if err != nil || key.Digits < 6 || key.Digits > 8 {
+			return OTPKey{}, fmt.Errorf("%w: digits must be 6 to 8, not %q", ErrBadOTPSecret, digits)
+		}
+	}
+
+	if period := query.Get("period"); period != "" {
+		key.Period, err = strconv.Atoi(period)
+		if err != nil || key.Period < 1 {
+			return OTPKey{}, fmt.Errorf("%w: bad period %q", ErrBadOTPSecret, period)
+		}
+	}
+
+	if counter := query.Get("counter"); counter != "" {
+		if key.Counter, err = strconv.ParseUint(counter, 10, 64); err != nil {
+			return OTPKey{}, fmt.Errorf("%w: bad counter %q", ErrBadOTPSecret, counter)
+		}
+	}
+
+	return key, nil
+}
+
+func decodeOTPSecret(value string) ([]byte, error) {
+	value = strings.ToUpper(strings.NewReplacer(" ", "", "-", "", "=", "").Replace(value))
+	secret, err := otpSecretEncoding.DecodeString(value)
+	if err != nil || len(secret) == 0 {
+		return nil, ErrBadOTPSecret
+	}
+	return secret, nil
+}
+
+// String returns the key as an otpauth:// URI.
+func (k OTPKey) String() string {
+	query := url.Values{}
+	query.Set("secret", otpSecretEncoding.EncodeToString(k.Secret))
+	if k.Issuer != "" {
+		query.Set("issuer", k.Issuer)
+	}
+	query.Set("algorithm", k.Algorithm)
+	query.Set("digits", strconv.Itoa(k.Digits))
+	if k.Kind == HOTP {
+		query.Set("counter", strconv.FormatUint(k.Counter, 10))
+	} else {
+		query.Set("period", strconv.Itoa(k.Period))
+	}
+
+	u := url.URL{Scheme: "otpauth", Host: k.Kind, Path: "/" + k.Label, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// TOTP returns the code valid at time t and how many seconds it stays valid.
+func (k OTPKey) TOTP(t time.Time) (string, int) {
+	seconds := t.Unix()
+	period := int64(k.Period)
+	return k.HOTP(uint64(seconds / period)), int(period - seconds%period)
+}
+
+// HOTP returns the code for counter.
+func (k OTPKey) HOTP(counter uint64) string {
+	var message [8]byte
+	binary.BigEndian.PutUint64(message[:], counter)
+
+	mac := hmac.New(otpAlgorithms[k.Algorithm], k.Secret)
+	mac.Write(message[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < k.Digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", k.Digits, code%modulo)
+}
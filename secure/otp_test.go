@@ -0,0 +1,93 @@
+package secure
+
+import (
+	"encoding/base32"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHOTP(t *testing.T) {
+	// RFC 4226 appendix D
+	key := OTPKey{Kind: HOTP, Secret: []byte("12345678901234567890"), Algorithm: "SHA1", Digits: 6}
+	expected := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	for counter, code := range expected {
+		if got := key.HOTP(uint64(counter)); got != code {
+			t.Errorf("HOTP(%d) = %v, expected %v", counter, got, code)
+		}
+	}
+}
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B
+	secrets := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	tests := []struct {
+		time      int64
+		algorithm string
+		code      string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{1234567890, "SHA1", "89005924"},
+		{1234567890, "SHA256", "91819424"},
+		{1234567890, "SHA512", "93441116"},
+		{20000000000, "SHA1", "65353130"},
+		{20000000000, "SHA256", "77737706"},
+		{20000000000, "SHA512", "47863826"},
+	}
+
+	for _, test := range tests {
+		key := OTPKey{Kind: TOTP, Secret: []byte(secrets[test.algorithm]), Algorithm: test.algorithm, Digits: 8, Period: 30}
+		code, remaining := key.TOTP(time.Unix(test.time, 0))
+		if code != test.code {
+			t.Errorf("TOTP(%d, %v) = %v, expected %v", test.time, test.algorithm, code, test.code)
+		}
+		if expected := 30 - int(test.time%30); remaining != expected {
+			t.Errorf("TOTP(%d) valid for %d seconds, expected %d", test.time, remaining, expected)
+		}
+	}
+}
+
+func TestParseOTP(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	encoded := base32.StdEncoding.EncodeToString(secret)
+
+	key, err := ParseOTP("gezd gnbv gy3t qojq gezd gnbv gy3t qojq")
+	if err != nil || string(key.Secret) != string(secret) || key.Kind != TOTP || key.Digits != 6 || key.Period != 30 || key.Algorithm != "SHA1" {
+		t.Errorf("Expected a default TOTP key from base32, but got %+v (%v)", key, err)
+	}
+
+	key, err = ParseOTP("otpauth://hotp/ACME:bob?secret=" + encoded + "&issuer=ACME&algorithm=sha256&digits=8&counter=7")
+	if err != nil || key.Kind != HOTP || key.Label != "ACME:bob" || key.Issuer != "ACME" || key.Algorithm != "SHA256" || key.Digits != 8 || key.Counter != 7 {
+		t.Errorf("Unexpected key %+v (%v)", key, err)
+	}
+
+	again, err := ParseOTP(key.String())
+	if err != nil || again.String() != key.String() || string(again.Secret) != string(secret) {
+		t.Errorf("Expected %v to parse back the same, but got %+v (%v)", key, again, err)
+	}
+
+	for _, bad := range []string{
+		"",
+		"not base32!",
+		"otpauth://sms/x?secret=" + encoded,
+		"otpauth://totp/x",
+		"otpauth://totp/x?secret=" + encoded + "&algorithm=MD5",
+		"otpauth://totp/x?secret=" + encoded + "&digits=9",
+		"otpauth://totp/x?secret=" + encoded + "&period=0",
+		"otpauth://hotp/x?secret=" + encoded + "&counter=-1",
+	} {
+		if _, err := ParseOTP(bad); !errors.Is(err, ErrBadOTPSecret) {
+			t.Errorf("Expected ErrBadOTPSecret for %q, but got %v", bad, err)
+		}
+	}
+}
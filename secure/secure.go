@@ -4,46 +4,95 @@ package secure
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/pbkdf2"
 	"golang.org/x/crypto/scrypt"
 )
 
+// Version bytes prefixed to every ciphertext produced by EncryptAES.
+const (
+	// CipherVersionGCM marks AES-256-GCM: version | nonce | sealed data and tag.
+	CipherVersionGCM byte = 1
+)
+
 // Custom error for ciphertext that is too short.
 var ErrCipherTextTooShort = errors.New("ciphertext too short")
 
-// EncryptAES encrypts plaintext using AES encryption.
+// ErrAuthenticationFailed is returned when a ciphertext fails its integrity check,
+// either because it was modified or because the key is wrong.
+var ErrAuthenticationFailed = errors.New("ciphertext authentication failed (tampered data or wrong key)")
+
+// ErrUnsupportedCipherVersion is returned for ciphertexts with an unknown version byte.
+var ErrUnsupportedCipherVersion = errors.New("unsupported ciphertext version")
+
+// EncryptAES encrypts plaintext using authenticated AES-GCM encryption.
 func EncryptAES(plainText string, key []byte) (string, error) {
-	block, err := aes.NewCipher(key)
+	gcm, err := newGCM(key)
 	if err != nil {
 		return "", err
 	}
 
-	plainTextBytes := []byte(plainText)
-	cipherText := make([]byte, aes.BlockSize+len(plainTextBytes))
-	iv := cipherText[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return "", err
 	}
 
-	stream := cipher.NewCFBEncrypter(block, iv)
-	stream.XORKeyStream(cipherText[aes.BlockSize:], plainTextBytes)
+	header := []byte{CipherVersionGCM}
+	cipherText := make([]byte, 0, len(header)+len(nonce)+len(plainText)+gcm.Overhead())
+	cipherText = append(cipherText, header...)
+	cipherText = append(cipherText, nonce...)
+	// The version byte is authenticated too, so it can't be swapped.
+	cipherText = gcm.Seal(cipherText, nonce, []byte(plainText), header)
 
 	return hex.EncodeToString(cipherText), nil
 }
 
-// DecryptAES decrypts AES-encrypted ciphertext.
+// DecryptAES decrypts and authenticates AES-GCM encrypted ciphertext.
 func DecryptAES(cipherTextHex string, key []byte) (string, error) {
 	cipherText, err := hex.DecodeString(cipherTextHex)
 	if err != nil {
 		return "", err
 	}
 
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(cipherText) < 1+gcm.NonceSize()+gcm.Overhead() {
+		return "", ErrCipherTextTooShort
+	}
+
+	header := cipherText[:1]
+	if header[0] != CipherVersionGCM {
+		return "", ErrUnsupportedCipherVersion
+	}
+
+	nonce := cipherText[1 : 1+gcm.NonceSize()]
+	plainText, err := gcm.Open(nil, nonce, cipherText[1+gcm.NonceSize():], header)
+	if err != nil {
+		return "", ErrAuthenticationFailed
+	}
+
+	return string(plainText), nil
+}
+
+// DecryptAESCFB decrypts ciphertext written by the old unauthenticated AES-CFB scheme.
+// It is only kept to migrate existing vaults.
+func DecryptAESCFB(cipherTextHex string, key []byte) (string, error) {
+	cipherText, err := hex.DecodeString(cipherTextHex)
+	if err != nil {
+		return "", err
+	}
+
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
@@ -62,14 +111,176 @@ func DecryptAES(cipherTextHex string, key []byte) (string, error) {
 	return string(cipherText), nil
 }
 
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// KDF identifies the key derivation function of a vault.
+type KDF uint8
+
+const (
+	// KDFLegacy is PBKDF2 salted with scrypt(password, ""), used before vaults had their own salt.
+	KDFLegacy KDF = iota
+	KDFPBKDF2
+	KDFScrypt
+	KDFArgon2id
+)
+
+// Default parameters for new vaults.
+const (
+	KeySize                 = 32
+	SaltSize                = 32
+	DefaultPBKDF2Iterations = 600_000
+	DefaultScryptN          = 1 << 15
+	DefaultScryptR          = 8
+	DefaultScryptP          = 1
+	// Argon2id memory is in KiB. The defaults are the second recommended option
+	// of RFC 9106.
+	DefaultArgon2Time        = 3
+	DefaultArgon2Memory      = 64 << 10
+	DefaultArgon2Parallelism = 4
+)
+
+// MaxArgon2Memory bounds the memory a vault header can ask for, in KiB, so a
+// damaged or forged header can't exhaust the memory of the machine. It bounds
+// scrypt as well.
+const MaxArgon2Memory = 4 << 20
+
+// maxPBKDF2Iterations and maxScryptP bound the costs that only take time, so a
+// forged header can't keep the unlock busy for hours.
+const (
+	maxPBKDF2Iterations = 100_000_000
+	maxScryptP          = 64
+)
+
+var ErrUnsupportedKDF = errors.New("unsupported key derivation function")
+var ErrMissingSalt = errors.New("key derivation salt is missing")
+var ErrBadKDFParams = errors.New("key derivation parameters are out of range")
+
+// KDFParams holds everything needed to derive a vault key from the master password.
+type KDFParams struct {
+	Algorithm KDF
+	Salt      []byte
+	// Iterations is used by PBKDF2.
+	Iterations uint32
+	// N, R and P are the scrypt cost parameters.
+	N, R, P uint32
+	// Time, Memory in KiB and Parallelism are the Argon2id cost parameters.
+	Time, Memory, Parallelism uint32
+}
+
+func (k KDF) String() string {
+	switch k {
+	case KDFLegacy:
+		return "legacy"
+	case KDFPBKDF2:
+		return "pbkdf2-sha256"
+	case KDFScrypt:
+		return "scrypt"
+	case KDFArgon2id:
+		return "argon2id"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(k))
+	}
+}
+
+// NewKDFParams returns the default parameters for a new vault with a fresh random salt.
+func NewKDFParams() (KDFParams, error) {
+	salt, err := GenerateSalt(SaltSize)
+	if err != nil {
+		return KDFParams{}, err
+	}
+
+	return KDFParams{
+		Algorithm:   KDFArgon2id,
+		Salt:        salt,
+		Time:        DefaultArgon2Time,
+		Memory:      DefaultArgon2Memory,
+		Parallelism: DefaultArgon2Parallelism,
+	}, nil
+}
+
+// Describe returns the algorithm and the cost parameters, without the salt.
+func (p KDFParams) Describe() string {
+	switch p.Algorithm {
+	case KDFPBKDF2:
+		return fmt.Sprintf("%v, %d iterations", p.Algorithm, p.Iterations)
+	case KDFScrypt:
+		return fmt.Sprintf("%v, N=%d r=%d p=%d", p.Algorithm, p.N, p.R, p.P)
+	case KDFArgon2id:
+		return fmt.Sprintf("%v, %d MiB, %d passes, %d lanes", p.Algorithm, p.Memory>>10, p.Time, p.Parallelism)
+	default:
+		return p.Algorithm.String()
+	}
+}
+
+// DeriveKey derives a vault key from the master password using the given parameters.
+func DeriveKey(password []byte, params KDFParams) ([]byte, error) {
+	if params.Algorithm != KDFLegacy && len(params.Salt) == 0 {
+		return nil, ErrMissingSalt
+	}
+
+	switch params.Algorithm {
+	case KDFLegacy:
+		salt, err := DeriveKeyScrypt(password, []byte(""))
+		if err != nil {
+			return nil, err
+		}
+		return DeriveKeyPBKDF2(password, salt), nil
+	case KDFPBKDF2:
+		if params.Iterations < 1 || params.Iterations > maxPBKDF2Iterations {
+			return nil, ErrBadKDFParams
+		}
+		return pbkdf2.Key(password, params.Salt, int(params.Iterations), KeySize, sha256.New), nil
+	case KDFScrypt:
+		// scrypt needs 128·N·r bytes, and N must be a power of two
+		if params.N < 2 || params.N&(params.N-1) != 0 || params.R < 1 || params.P < 1 || params.P > maxScryptP ||
+			128*uint64(params.N)*uint64(params.R) > MaxArgon2Memory<<10 {
+			return nil, ErrBadKDFParams
+		}
+		return scrypt.Key(password, params.Salt, int(params.N), int(params.R), int(params.P), KeySize)
+	case KDFArgon2id:
+		if params.Time < 1 || params.Time > maxArgon2Time || params.Parallelism < 1 || params.Parallelism > 255 ||
+			params.Memory < 8*params.Parallelism || params.Memory > MaxArgon2Memory {
+			return nil, ErrBadKDFParams
+		}
+		return argon2.IDKey(password, params.Salt, params.Time, params.Memory, uint8(params.Parallelism), KeySize), nil
+	default:
+		return nil, ErrUnsupportedKDF
+	}
+}
+
 // DeriveKeyPBKDF2 derives a key from a password using PBKDF2.
 func DeriveKeyPBKDF2(password, salt []byte) []byte {
-	return pbkdf2.Key(password, salt, 4096, 32, sha256.New)
+	return pbkdf2.Key(password, salt, 4096, KeySize, sha256.New)
 }
 
 // DeriveKeyScrypt derives a key from a password using scrypt.
 func DeriveKeyScrypt(password, salt []byte) ([]byte, error) {
-	return scrypt.Key(password, salt, 16384, 8, 1, 32)
+	return scrypt.Key(password, salt, 16384, 8, 1, KeySize)
+}
+
+// MACSize is the length of the tags returned by MAC.
+const MACSize = sha256.Size
+
+// DeriveSubkey derives an independent key for purpose from the vault key, so the
+// same key is never used for two different jobs.
+func DeriveSubkey(key []byte, purpose string) []byte {
+	return MAC(key, []byte(purpose))
+}
+
+// MAC returns the HMAC-SHA256 of the concatenated parts.
+func MAC(key []byte, parts ...[]byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	for _, part := range parts {
+		mac.Write(part)
+	}
+	return mac.Sum(nil)
 }
 
 func GenerateSalt(size int) ([]byte, error) {
@@ -3,6 +3,8 @@ package secure
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/hex"
 	"io"
@@ -65,6 +67,72 @@ func TestDecryptAESWithInvalidCipherText(t *testing.T) {
 	}
 }
 
+func TestDecryptAESTampered(t *testing.T) {
+	key := []byte("a very strong encryption key 123")
+
+	cipherText, err := EncryptAES("tamper with me", key)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	raw := hexDecode(cipherText)
+	// Flip one bit of the last byte, which is part of the authentication tag
+	raw[len(raw)-1] ^= 0x01
+
+	_, err = DecryptAES(hex.EncodeToString(raw), key)
+	if err != ErrAuthenticationFailed {
+		t.Fatalf("Expected ErrAuthenticationFailed, but got %v", err)
+	}
+}
+
+func TestDecryptAESUnsupportedVersion(t *testing.T) {
+	key := []byte("a very strong encryption key 123")
+
+	cipherText, err := EncryptAES("versioned", key)
+	if err != nil {
+		t.Fatalf("Encryption failed: %v", err)
+	}
+
+	raw := hexDecode(cipherText)
+	raw[0] = CipherVersionGCM + 1
+
+	_, err = DecryptAES(hex.EncodeToString(raw), key)
+	if err != ErrUnsupportedCipherVersion {
+		t.Fatalf("Expected ErrUnsupportedCipherVersion, but got %v", err)
+	}
+}
+
+func TestDecryptAESCFB(t *testing.T) {
+	key := []byte("a very strong encryption key 123")
+	plainText := "written by an old version"
+
+	// Build a ciphertext the way the old CFB based EncryptAES did
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("Creating cipher failed: %v", err)
+	}
+	cipherText := make([]byte, aes.BlockSize+len(plainText))
+	iv := cipherText[:aes.BlockSize]
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		t.Fatalf("Failed to generate IV: %v", err)
+	}
+	cipher.NewCFBEncrypter(block, iv).XORKeyStream(cipherText[aes.BlockSize:], []byte(plainText))
+	legacy := hex.EncodeToString(cipherText)
+
+	decrypted, err := DecryptAESCFB(legacy, key)
+	if err != nil {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+	if decrypted != plainText {
+		t.Fatalf("Expected %v, but got %v", plainText, decrypted)
+	}
+
+	// Legacy ciphertexts must not be accepted by the authenticated decryption
+	if _, err := DecryptAES(legacy, key); err == nil {
+		t.Fatal("Expected DecryptAES to reject a legacy ciphertext")
+	}
+}
+
 func TestDeriveKeyPBKDF2(t *testing.T) {
 	password := []byte("securepassword")
 	salt := []byte("randomsalt")
@@ -109,6 +177,103 @@ func TestDeriveKeyScrypt(t *testing.T) {
 	}
 }
 
+func TestDeriveKey(t *testing.T) {
+	password := []byte("securepassword")
+
+	params, err := NewKDFParams()
+	if err != nil {
+		t.Fatalf("NewKDFParams failed: %v", err)
+	}
+	if len(params.Salt) != SaltSize {
+		t.Fatalf("Expected a salt of %d bytes, but got %d", SaltSize, len(params.Salt))
+	}
+
+	key, err := DeriveKey(password, params)
+	if err != nil {
+		t.Fatalf("DeriveKey failed: %v", err)
+	}
+	if len(key) != KeySize {
+		t.Fatalf("Expected key length of %d, but got %d", KeySize, len(key))
+	}
+
+	key2, err := DeriveKey(password, params)
+	if err != nil {
+		t.Fatalf("DeriveKey failed: %v", err)
+	}
+	if !bytes.Equal(key, key2) {
+		t.Fatal("Deriving the same key twice did not produce the same result")
+	}
+
+	// The same password must give a different key in another vault
+	other, err := NewKDFParams()
+	if err != nil {
+		t.Fatalf("NewKDFParams failed: %v", err)
+	}
+	otherKey, err := DeriveKey(password, other)
+	if err != nil {
+		t.Fatalf("DeriveKey failed: %v", err)
+	}
+	if bytes.Equal(key, otherKey) {
+		t.Fatal("Two vaults with the same password produced the same key")
+	}
+}
+
+func TestDeriveKeyLegacy(t *testing.T) {
+	password := []byte("securepassword")
+
+	salt, err := DeriveKeyScrypt(password, []byte(""))
+	if err != nil {
+		t.Fatalf("Scrypt key derivation failed: %v", err)
+	}
+	expected := DeriveKeyPBKDF2(password, salt)
+
+	key, err := DeriveKey(password, KDFParams{Algorithm: KDFLegacy})
+	if err != nil {
+		t.Fatalf("DeriveKey failed: %v", err)
+	}
+	if !bytes.Equal(key, expected) {
+		t.Fatal("Legacy derivation does not match the old scheme")
+	}
+}
+
+func TestDeriveKeyErrors(t *testing.T) {
+	password := []byte("securepassword")
+
+	if _, err := DeriveKey(password, KDFParams{Algorithm: KDFScrypt, N: 1024, R: 8, P: 1}); err != ErrMissingSalt {
+		t.Fatalf("Expected ErrMissingSalt, but got %v", err)
+	}
+
+	if _, err := DeriveKey(password, KDFParams{Algorithm: KDF(200), Salt: []byte("salt")}); err != ErrUnsupportedKDF {
+		t.Fatalf("Expected ErrUnsupportedKDF, but got %v", err)
+	}
+}
+
+func TestDeriveKeyBadParams(t *testing.T) {
+	salt := []byte("salt")
+
+	for _, bad := range []KDFParams{
+		{Algorithm: KDFPBKDF2, Salt: salt, Iterations: 0},
+		{Algorithm: KDFPBKDF2, Salt: salt, Iterations: maxPBKDF2Iterations + 1},
+		{Algorithm: KDFScrypt, Salt: salt, N: 0, R: 8, P: 1},
+		{Algorithm: KDFScrypt, Salt: salt, N: 1, R: 8, P: 1},
+		{Algorithm: KDFScrypt, Salt: salt, N: 1000, R: 8, P: 1},
+		{Algorithm: KDFScrypt, Salt: salt, N: 1 << 30, R: 8, P: 1},
+		{Algorithm: KDFScrypt, Salt: salt, N: 1 << 15, R: 0, P: 1},
+		{Algorithm: KDFScrypt, Salt: salt, N: 1 << 15, R: 1 << 20, P: 1},
+		{Algorithm: KDFScrypt, Salt: salt, N: 1 << 15, R: 8, P: 0},
+		{Algorithm: KDFScrypt, Salt: salt, N: 1 << 15, R: 8, P: maxScryptP + 1},
+	} {
+		if _, err := DeriveKey([]byte("password"), bad); err != ErrBadKDFParams {
+			t.Errorf("Expected ErrBadKDFParams for %+v, but got %v", bad, err)
+		}
+	}
+
+	good := KDFParams{Algorithm: KDFScrypt, Salt: salt, N: 1024, R: 8, P: 1}
+	if _, err := DeriveKey([]byte("password"), good); err != nil {
+		t.Errorf("Expected %+v to be accepted, but got %v", good, err)
+	}
+}
+
 func TestHexEncodeDecode(t *testing.T) {
 	original := "Hello, World!"
 	encoded := hex.EncodeToString([]byte(original))
@@ -160,12 +325,11 @@ func TestMasterPasswordValidation(t *testing.T) {
 	// Test decryption with the wrong password
 	wrongDerivedKey := DeriveKeyPBKDF2(wrongPassword, salt)
 	wrongM, err2 := DecryptAES(encrypted, wrongDerivedKey)
-	if err2 != nil {
-		t.Fatalf("Decryption failed")
-	} else {
-		if wrongM == plainText {
-			t.Fatalf("Decryption with wrong password worked! We expected '%v' not to be equal to '%v'", wrongM, plainText)
-		}
+	if err2 != ErrAuthenticationFailed {
+		t.Fatalf("Expected ErrAuthenticationFailed, but got %v", err2)
+	}
+	if wrongM == plainText {
+		t.Fatalf("Decryption with wrong password worked! We expected '%v' not to be equal to '%v'", wrongM, plainText)
 	}
 }
 
@@ -195,8 +359,8 @@ func TestMasterPasswordValidationMismatch(t *testing.T) {
 	wrongPassword := []byte("wrong_password")
 	wrongKey, _ := DeriveKeyScrypt(wrongPassword, salt)
 	decryptedText, err := DecryptAES(cipherText, wrongKey)
-	if err != nil {
-		t.Fatal("Decryption failed")
+	if err != ErrAuthenticationFailed {
+		t.Fatalf("Expected ErrAuthenticationFailed, but got %v", err)
 	}
 
 	// Verify wrong decryption output should not match plaintext
@@ -204,3 +368,37 @@ func TestMasterPasswordValidationMismatch(t *testing.T) {
 		t.Fatal("Mismatch expected, but decryption returned original text")
 	}
 }
+
+func TestMAC(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	tag := MAC(key, []byte("hello "), []byte("world"))
+	if len(tag) != MACSize {
+		t.Fatalf("Expected a %v byte tag, but got %v", MACSize, len(tag))
+	}
+
+	if !bytes.Equal(tag, MAC(key, []byte("hello world"))) {
+		t.Error("Expected the tag of the concatenated parts")
+	}
+	if bytes.Equal(tag, MAC(key, []byte("hello world!"))) {
+		t.Error("Expected a different tag for different data")
+	}
+	if bytes.Equal(tag, MAC([]byte("another key"), []byte("hello world"))) {
+		t.Error("Expected a different tag for a different key")
+	}
+}
+
+func TestDeriveSubkey(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	mac := DeriveSubkey(key, "mac")
+	if len(mac) != KeySize {
+		t.Fatalf("Expected a %v byte key, but got %v", KeySize, len(mac))
+	}
+	if bytes.Equal(mac, key) || bytes.Equal(mac, DeriveSubkey(key, "other")) {
+		t.Error("Expected independent keys for different purposes")
+	}
+	if !bytes.Equal(mac, DeriveSubkey(key, "mac")) {
+		t.Error("Expected the same subkey for the same purpose")
+	}
+}
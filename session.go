@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"squirrel/app"
+	"squirrel/data"
+	l "squirrel/log"
+	"squirrel/secure"
+	"time"
+)
+
+var ErrVaultNotCreated = errors.New("vault was not created")
+
+var (
+	// home holds the default vault and the vaults/ directory of the named ones.
+	home string = ""
+	// vaultName is the name of the open vault, empty when no vault is open.
+	vaultName string = ""
+)
+
+// sessionCommands work whether a vault is open or not.
+var sessionCommands = map[string]app.Command{
+	"version": app.VersionCommand(l.Println, appName, appVersion),
+	"help":    app.HelpCommand(l.Print),
+
+	"vaults": app.VaultsCommand(l.Print, func() string { return home }, func() string { return vaultName }),
+	"open":   app.OpenCommand(l.Print, openVault),
+	"close":  app.CloseCommand(l.Print, func() string { return vaultName }, closeVault),
+}
+
+// session is the state of the open vault, which closeVault forgets.
+type session struct {
+	vault         *data.Vault
+	encryptionKey []byte
+	password      []byte
+	vaultName     string
+	commands      map[string]app.Command
+	state         data.State
+}
+
+// currentSession returns the state of the open vault.
+func currentSession() session {
+	return session{vault, encryptionKey, password, vaultName, commands, state}
+}
+
+// restore makes s the open vault again.
+func (s session) restore() {
+	vault, encryptionKey, password, vaultName, commands, state = s.vault, s.encryptionKey, s.password, s.vaultName, s.commands, s.state
+}
+
+// openVault unlocks the named vault, creating it after asking when it doesn't exist
+// yet, and then closes the vault that was open. When the named vault can't be
+// opened, the open vault stays open.
+func openVault(name string) error {
+	dir, err := data.VaultDir(home, name)
+	if err != nil {
+		return err
+	}
+
+	if name != data.DefaultVault && !data.HasPassVerifyFile(&data.Vault{Dir: dir}) {
+		if !app.GetYesNoInput(l.Print, "There is no vault named "+name+". Create it?") {
+			return ErrVaultNotCreated
+		}
+	}
+
+	// Unlocking works on the open vault, so the previous one is set aside meanwhile
+	previous := currentSession()
+	session{}.restore()
+
+	if err := unlockVault(name, dir); err != nil {
+		closeVault()
+		previous.restore()
+		return err
+	}
+
+	clear(previous.encryptionKey)
+	clear(previous.password)
+	return nil
+}
+
+// unlockVault unlocks the vault in dir and makes it the open vault under name.
+func unlockVault(name, dir string) error {
+	var err error
+	vault, err = data.OpenVault(dir)
+	if err != nil {
+		return err
+	}
+	vault.Warn = func(err error) {
+		l.Println("{yellow}Warning: {0}{/yellow}", err)
+	}
+
+	key, err := signInOrInitialize(name == data.DefaultVault)
+	if err != nil {
+		return err
+	}
+
+	encryptionKey = key
+
+	if err := data.UpgradeDataFile(vault); err != nil {
+		return err
+	}
+
+	store := data.NewFileStore(vault)
+
+	// The passwords matter more than the trash, the purge can wait for the next unlock
+	err = app.PurgeExpiredTrash(l.Print, store, decryptor, trashDays, time.Now(), autoBackup)
+	if err != nil {
+		l.Println("{yellow}Purging the expired trash failed, it stays in the trash for now: {0}{/yellow}", err)
+	}
+
+	vaultName = name
+	commands = newCommands(vault, store)
+
+	printLow("Vault {0}: {1}\n", vaultName, vault.Dir)
+	state = app.ReadState(store)
+	printLow("There are {0} entries.\n", state.Count)
+	app.PrintExpiryBanner(l.Print, store, decryptor, time.Now(), expiryDays)
+
+	if params, err := vaultKDF(); err == nil && params.Algorithm != secure.KDFArgon2id {
+		l.Println("{yellow}This vault derives its key with {0}. Type {green}rekey{/green} to move it to Argon2id.{/yellow}", params.Algorithm)
+	}
+
+	return nil
+}
+
+// checkIntegrity verifies the seal of the data file of the open vault, and seals it
+// when the vault is from before sealing existed. key unlocks the vault header.
+func checkIntegrity(key []byte) error {
+	header, err := data.LoadVaultHeader(vault)
+	if err != nil {
+		return fmt.Errorf("can't read from disk: %w", err)
+	}
+	sealed := verifierSealed(header.Verifier, key)
+
+	report, err := data.CheckIntegrity(vault, sealed)
+	if err == data.ErrNotSealed {
+		l.Println("{red}The data file of this vault is not sealed, it was replaced or modified outside squirrel!{/red}")
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("can't check the data file: %w", err)
+	}
+
+	if !report.OK() {
+		app.PrintIntegrityReport(l.Print, report)
+		if data.HasDataFile(vault) {
+			l.Println("Run {green}squirrel --fix-data-file{/green} to salvage the readable entries into a new vault.")
+		} else {
+			l.Println("Put the data file back from a copy, such as the newest snapshot in the {brightWhite}backups{/brightWhite} directory of the vault.")
+		}
+		return data.ErrIntegrity
+	}
+
+	// From now on a data file without a seal is refused, whatever the header says.
+	// Vaults still to be upgraded get the new verifier with the upgrade.
+	if _, legacyCipher, _ := checkVerifier(header.Verifier, key); !sealed && !legacyCipher && header.KDF.Algorithm != secure.KDFLegacy {
+		if header.Verifier, err = secure.EncryptAES(sealedVerifierText, key); err != nil {
+			return err
+		}
+		if err := data.SaveVaultHeader(vault, header); err != nil {
+			return fmt.Errorf("can't write to disk: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// closeVault forgets the key and the master password of the open vault.
+func closeVault() {
+	clear(encryptionKey)
+	clear(password)
+
+	encryptionKey = nil
+	password = nil
+	vault = nil
+	vaultName = ""
+	commands = nil
+	state = data.State{}
+}
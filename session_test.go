@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"squirrel/data"
+	"testing"
+	"time"
+)
+
+// checkStillOpen fails the test unless the session is the one it was before.
+func checkStillOpen(t *testing.T, before session) {
+	if vault != before.vault || vaultName != before.vaultName || !bytes.Equal(encryptionKey, before.encryptionKey) ||
+		!bytes.Equal(password, before.password) || reflect.ValueOf(commands).Pointer() != reflect.ValueOf(before.commands).Pointer() {
+		t.Errorf("Expected vault %v to stay open, but got vault %v (%v)", before.vaultName, vaultName, vault)
+	}
+	checkEntry(t)
+}
+
+func TestOpenVaultKeepsOpenVaultOnFailure(t *testing.T) {
+	root := t.TempDir()
+
+	// The work vault exists already, with another password
+	workDir, err := data.VaultDir(root, "work")
+	if err != nil {
+		t.Fatalf("VaultDir failed: %v", err)
+	}
+	home = workDir
+	withInput(t, "work password", "work password", "n")
+	if err := openVault(data.DefaultVault); err != nil {
+		t.Fatalf("Creating the work vault failed: %v", err)
+	}
+	closeVault()
+
+	testSession(t, "default password")
+	home = root
+	before := currentSession()
+	before.encryptionKey = bytes.Clone(encryptionKey)
+	before.password = bytes.Clone(password)
+
+	withInput(t, "wrong", "wrong", "wrong")
+	if err := openVault("work"); err != ErrWrongPassword {
+		t.Fatalf("Expected ErrWrongPassword, but got %v", err)
+	}
+	checkStillOpen(t, before)
+
+	withInput(t, "n")
+	if err := openVault("personal"); err != ErrVaultNotCreated {
+		t.Fatalf("Expected ErrVaultNotCreated, but got %v", err)
+	}
+	checkStillOpen(t, before)
+
+	withInput(t, "work password")
+	if err := openVault("work"); err != nil {
+		t.Fatalf("Opening the work vault failed: %v", err)
+	}
+	if vaultName != "work" || vault == before.vault {
+		t.Errorf("Expected the work vault to be open, but got %v", vaultName)
+	}
+}
+
+func TestOpenVaultWhenPurgingTrashFails(t *testing.T) {
+	testSession(t, "password")
+
+	trashed := testEntry(2, "expired")
+	trashed.Deleted = time.Now().AddDate(0, 0, -2*trashDays)
+	if err := data.SaveEntry(vault, trashed); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+
+	// The snapshot before the purge can't be written
+	if err := os.WriteFile(filepath.Join(vault.Dir, "backups"), nil, 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	if err := reopen(t, "password"); err != nil {
+		t.Fatalf("Expected the vault to open, but got %v", err)
+	}
+	checkEntry(t)
+	if _, err := data.LoadEntry(vault, 2); err != nil {
+		t.Errorf("Expected the entry to stay in the trash, but got %v", err)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"squirrel/app"
+	"squirrel/data"
+	l "squirrel/log"
+	"squirrel/secure"
+)
+
+var (
+	ErrPasswordChangeUnfinished = errors.New("the password change did not finish, the vault still opens with the old password")
+	ErrPasswordChanged          = errors.New("the password was changed but the change did not finish, open the vault with the new password to finish it")
+)
+
+// verifierText is encrypted with the vault key to check the master password.
+const verifierText = "squirrel"
+
+// sealedVerifierText replaces verifierText once the vault has a sealed data file.
+// It tells that the vault must never accept a data file that is missing or not
+// sealed again.
+const sealedVerifierText = "squirrel sealed"
+
+// checkVerifier reports whether key decrypts the password verifier. legacyCipher is
+// true when the verifier was written with the old AES-CFB scheme.
+func checkVerifier(verifier string, key []byte) (ok bool, legacyCipher bool, err error) {
+	d, err := secure.DecryptAES(verifier, key)
+	if err == nil {
+		return d == verifierText || d == sealedVerifierText, false, nil
+	}
+
+	// Vaults created before authenticated encryption still use AES-CFB
+	d, err = secure.DecryptAESCFB(verifier, key)
+	if err != nil {
+		return false, false, err
+	}
+
+	return d == verifierText, d == verifierText, nil
+}
+
+// verifierSealed reports whether the verifier says the data file must be sealed.
+func verifierSealed(verifier string, key []byte) bool {
+	d, err := secure.DecryptAES(verifier, key)
+	return err == nil && d == sealedVerifierText
+}
+
+// upgradeIfNeeded resumes an interrupted upgrade, or moves a vault that still uses
+// plain text titles, AES-CFB or the shared legacy salt to the current format.
+// It returns the key to use.
+func upgradeIfNeeded(header data.VaultHeader, key []byte, legacyCipher bool) ([]byte, error) {
+	// Titles used to be stored in plain text. Encrypting them with the current key
+	// first lets the re-encryption below treat every field the same way.
+	err := app.EncryptTitles(vault, func(value string) (string, error) {
+		return secure.EncryptAES(value, key)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	staged, found, err := data.LoadStagedVaultHeader(vault)
+	if err != nil {
+		return nil, err
+	}
+
+	if found {
+		newKey, err := deriveKey(password, staged)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok, _, err := checkVerifier(staged.Verifier, newKey); err == nil && ok {
+			printLow("Resuming an interrupted vault upgrade...\n")
+			return newKey, finishUpgrade(key, newKey, legacyCipher)
+		}
+
+		if err := data.DiscardVaultHeader(vault); err != nil {
+			return nil, err
+		}
+	}
+
+	if !legacyCipher && header.KDF.Algorithm != secure.KDFLegacy {
+		return key, nil
+	}
+
+	printLow("Upgrading the vault to the current encryption format...\n")
+
+	params := header.KDF
+	if params.Algorithm == secure.KDFLegacy {
+		params, err = secure.NewKDFParams()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	upgraded := data.VaultHeader{KDF: params, KeyFile: header.KeyFile}
+	newKey, err := deriveKey(password, upgraded)
+	if err != nil {
+		return nil, err
+	}
+
+	upgraded.Verifier, err = secure.EncryptAES(sealedVerifierText, newKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// The new header only becomes active once every entry is re-encrypted
+	if err := data.StageVaultHeader(vault, upgraded); err != nil {
+		return nil, err
+	}
+
+	return newKey, finishUpgrade(key, newKey, legacyCipher)
+}
+
+// finishUpgrade re-encrypts every entry under newKey and activates the staged header.
+func finishUpgrade(oldKey, newKey []byte, legacyCipher bool) error {
+	decryptor := func(value string) (string, error) {
+		// Entries rewritten by an interrupted upgrade are already under the new key
+		if d, err := secure.DecryptAES(value, newKey); err == nil {
+			return d, nil
+		}
+		d, err := secure.DecryptAES(value, oldKey)
+		if err == nil || !legacyCipher {
+			return d, err
+		}
+		return secure.DecryptAESCFB(value, oldKey)
+	}
+	encryptor := func(value string) (string, error) {
+		return secure.EncryptAES(value, newKey)
+	}
+
+	// The data file is sealed again under the new key
+	vault.SetKey(newKey)
+
+	if err := app.ReEncrypt(vault, decryptor, encryptor, l.Print); err != nil {
+		return err
+	}
+
+	return data.CommitVaultHeader(vault)
+}
+
+// changePassword re-encrypts the open vault under a key derived from next, once
+// current is confirmed to be its master password. Argon2id vaults keep their
+// costs, older ones move to Argon2id.
+func changePassword(current, next []byte) error {
+	header, err := data.LoadVaultHeader(vault)
+	if err != nil {
+		return err
+	}
+
+	params := header.KDF
+	if params.Algorithm != secure.KDFArgon2id {
+		if params, err = secure.NewKDFParams(); err != nil {
+			return fmt.Errorf("can't generate a salt: %w", err)
+		}
+	}
+
+	return reKey(current, next, params)
+}
+
+// vaultKDF returns how the key of the open vault is derived.
+func vaultKDF() (secure.KDFParams, error) {
+	header, err := data.LoadVaultHeader(vault)
+	return header.KDF, err
+}
+
+// rekeyVault re-encrypts the open vault under a key derived with the given KDF
+// parameters. The master password stays the same.
+func rekeyVault(params secure.KDFParams) error {
+	return reKey(password, bytes.Clone(password), params)
+}
+
+// reKey re-encrypts the open vault under a key derived from next with params and a
+// new salt, once current is confirmed to be its master password. A snapshot of
+// the vault as it was is taken first, unless snapshots are turned off.
+func reKey(current, next []byte, params secure.KDFParams) error {
+	key, header, err := checkPassword(current)
+	if err != nil {
+		return err
+	}
+
+	if err := autoBackup(); err != nil {
+		return fmt.Errorf("backing up the vault failed: %w", err)
+	}
+
+	// A new salt, so the new key has nothing in common with the old one
+	if params.Salt, err = secure.GenerateSalt(secure.SaltSize); err != nil {
+		return fmt.Errorf("can't generate a salt: %w", err)
+	}
+
+	// The vault keeps needing its key file, if it does
+	changed := data.VaultHeader{KDF: params, KeyFile: header.KeyFile}
+	newKey, err := deriveKey(next, changed)
+	if err != nil {
+		return fmt.Errorf("can't derive the encryption key: %w", err)
+	}
+
+	changed.Verifier, err = secure.EncryptAES(sealedVerifierText, newKey)
+	if err != nil {
+		return err
+	}
+
+	decryptor := func(value string) (string, error) {
+		return secure.DecryptAES(value, key)
+	}
+	encryptor := func(value string) (string, error) {
+		return secure.EncryptAES(value, newKey)
+	}
+
+	err = data.ReKey(vault, changed, newKey, func() error {
+		return app.ReEncrypt(vault, decryptor, encryptor, l.Print)
+	})
+	if err != nil {
+		return err
+	}
+
+	clear(key)
+	clear(encryptionKey)
+	clear(password)
+	encryptionKey = newKey
+	password = next
+
+	return nil
+}
+
+// checkPassword returns the key and the header of the open vault when pass is its
+// master password.
+func checkPassword(pass []byte) ([]byte, data.VaultHeader, error) {
+	header, err := data.LoadVaultHeader(vault)
+	if err != nil {
+		return nil, data.VaultHeader{}, err
+	}
+
+	key, err := deriveKey(pass, header)
+	if err != nil {
+		return nil, data.VaultHeader{}, fmt.Errorf("can't derive the encryption key: %w", err)
+	}
+	if ok, _, err := checkVerifier(header.Verifier, key); err != nil || !ok {
+		return nil, data.VaultHeader{}, ErrWrongPassword
+	}
+	return key, header, nil
+}
+
+// resumeKeyChange sorts out a change of the vault key that was interrupted and left
+// a staged header. key unlocks the active header, or the staged one when
+// unlockedStaged is set. The staged header becomes active when the data file was
+// re-encrypted under its key already. It returns the key of the data file.
+func resumeKeyChange(staged data.VaultHeader, key []byte, unlockedStaged bool) ([]byte, error) {
+	// Vaults from before sealing are only re-keyed by upgradeIfNeeded, which resumes itself
+	if data.HasDataFile(vault) {
+		header, err := data.LoadDataHeader(vault)
+		if err != nil {
+			return nil, err
+		}
+		if !header.Flags.Has(data.FlagSealed) {
+			if unlockedStaged {
+				return nil, ErrPasswordChangeUnfinished
+			}
+			return key, nil
+		}
+	}
+
+	stagedKey := key
+	if !unlockedStaged {
+		// Upgrades keep the password, so it may unlock both headers
+		k, err := deriveKey(password, staged)
+		if err != nil {
+			return nil, fmt.Errorf("can't derive the encryption key: %w", err)
+		}
+		stagedKey = nil
+		if ok, _, _ := checkVerifier(staged.Verifier, k); ok {
+			stagedKey = k
+		}
+	}
+
+	if stagedKey != nil {
+		sealed, err := sealedWith(stagedKey)
+		if err != nil {
+			return nil, err
+		}
+		if sealed {
+			printLow("Finishing an interrupted change of the vault key...\n")
+			return stagedKey, data.CommitVaultHeader(vault)
+		}
+	}
+
+	if unlockedStaged {
+		// The next unlock with the old password discards the staged header
+		return nil, ErrPasswordChangeUnfinished
+	}
+
+	sealed, err := sealedWith(key)
+	if err != nil {
+		return nil, err
+	}
+	if !sealed {
+		return nil, ErrPasswordChanged
+	}
+
+	// The change never got to the data file, upgradeIfNeeded discards or resumes it
+	return key, nil
+}
+
+// sealedWith reports whether the data file of the open vault is sealed with key.
+// Without a data file there is nothing to tell the keys apart, checkIntegrity
+// refuses the vault later when it needs one.
+func sealedWith(key []byte) (bool, error) {
+	vault.SetKey(key)
+	if !data.HasDataFile(vault) {
+		return true, nil
+	}
+	report, err := data.VerifyDataFile(vault)
+	if err != nil {
+		return false, err
+	}
+	return report.OK(), nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"squirrel/data"
+	"strings"
+	"testing"
+)
+
+// errCrash stops a write the way a crash would, see crashAt.
+var errCrash = errors.New("simulated crash")
+
+// withInput makes the prompts read lines, as if they were typed.
+func withInput(t *testing.T, lines ...string) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe failed: %v", err)
+	}
+	if _, err := w.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		t.Fatalf("Writing the input failed: %v", err)
+	}
+	w.Close()
+
+	stdin := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		r.Close()
+	})
+}
+
+// testSession creates the default vault in a temp home with the master password
+// pass and opens it, with one entry in it.
+func testSession(t *testing.T, pass string) {
+	home = t.TempDir()
+	t.Cleanup(closeVault)
+
+	withInput(t, pass, pass, "n")
+	if err := openVault(data.DefaultVault); err != nil {
+		t.Fatalf("Creating the vault failed: %v", err)
+	}
+
+	if err := data.SaveEntry(vault, testEntry(1, "server")); err != nil {
+		t.Fatalf("SaveEntry failed: %v", err)
+	}
+}
+
+// testEntry returns an entry encrypted with the key of the open vault.
+func testEntry(id int64, title string) data.Entry {
+	// Every field is encrypted, empty or not
+	fields := make([]string, 5)
+	for i, value := range []string{title, "admin", "", "secret", ""} {
+		fields[i], _ = encryptor(value)
+	}
+	return data.Entry{Id: id, Title: fields[0], Username: fields[1], Address: fields[2], Password: fields[3], Notes: fields[4]}
+}
+
+// reopen closes the open vault and unlocks it again with pass.
+func reopen(t *testing.T, pass ...string) error {
+	closeVault()
+	withInput(t, pass...)
+	return openVault(data.DefaultVault)
+}
+
+// checkEntry fails the test unless the entry of testSession reads back.
+func checkEntry(t *testing.T) {
+	entry, err := data.LoadEntry(vault, 1)
+	if err != nil {
+		t.Fatalf("LoadEntry failed: %v", err)
+	}
+	if title, err := decryptor(entry.Title); err != nil || title != "server" {
+		t.Errorf("Expected the entry to decrypt, but got %q (%v)", title, err)
+	}
+}
+
+// crashAt stops the process, as far as the vault can tell, when the data file of
+// the open vault reaches step. The panic skips every clean up but the deferred
+// lock releases, which the operating system would do.
+func crashAt(t *testing.T, step string, change func() error) {
+	target := filepath.Join(vault.Dir, "data.bin")
+	restore := data.InterruptWrites(func(s, fileName string) error {
+		if s == step && fileName == target {
+			panic(errCrash)
+		}
+		return nil
+	})
+	defer restore()
+
+	defer func() {
+		if r := recover(); r != errCrash {
+			t.Fatalf("Expected the simulated crash, but got %v", r)
+		}
+	}()
+	change()
+}
+
+func hasStagedHeader(t *testing.T) bool {
+	_, found, err := data.LoadStagedVaultHeader(vault)
+	if err != nil {
+		t.Fatalf("LoadStagedVaultHeader failed: %v", err)
+	}
+	return found
+}
+
+func TestChangePassword(t *testing.T) {
+	testSession(t, "old password")
+
+	if err := changePassword([]byte("wrong"), []byte("new password")); err != ErrWrongPassword {
+		t.Fatalf("Expected ErrWrongPassword, but got %v", err)
+	}
+	if err := changePassword([]byte("old password"), []byte("new password")); err != nil {
+		t.Fatalf("changePassword failed: %v", err)
+	}
+	checkEntry(t)
+
+	if err := reopen(t, "old password", "old password", "old password"); err != ErrWrongPassword {
+		t.Errorf("Expected the old password to be refused, but got %v", err)
+	}
+	if err := reopen(t, "new password"); err != nil {
+		t.Fatalf("Unlocking with the new password failed: %v", err)
+	}
+	checkEntry(t)
+}
+
+func TestChangePasswordInterruptedAfterReEncrypting(t *testing.T) {
+	testSession(t, "old password")
+
+	// The entries are under the new key, the new header is still staged
+	crashAt(t, "sync-dir", func() error {
+		return changePassword([]byte("old password"), []byte("new password"))
+	})
+	if !hasStagedHeader(t) {
+		t.Fatal("Expected the crash to leave the new header staged")
+	}
+
+	if err := reopen(t, "old password"); err != ErrPasswordChanged {
+		t.Fatalf("Expected ErrPasswordChanged with the old password, but got %v", err)
+	}
+
+	if err := reopen(t, "new password"); err != nil {
+		t.Fatalf("Unlocking with the new password failed: %v", err)
+	}
+	if hasStagedHeader(t) {
+		t.Error("Expected the staged header to become active")
+	}
+	checkEntry(t)
+
+	if err := reopen(t, "new password"); err != nil {
+		t.Fatalf("Unlocking with the new password again failed: %v", err)
+	}
+}
+
+func TestChangePasswordInterruptedBeforeReEncrypting(t *testing.T) {
+	testSession(t, "old password")
+
+	// The new header is staged, the entries are still under the old key
+	crashAt(t, "rename", func() error {
+		return changePassword([]byte("old password"), []byte("new password"))
+	})
+	if !hasStagedHeader(t) {
+		t.Fatal("Expected the crash to leave the new header staged")
+	}
+
+	if err := reopen(t, "new password"); err != ErrPasswordChangeUnfinished {
+		t.Fatalf("Expected ErrPasswordChangeUnfinished with the new password, but got %v", err)
+	}
+
+	if err := reopen(t, "old password"); err != nil {
+		t.Fatalf("Unlocking with the old password failed: %v", err)
+	}
+	if hasStagedHeader(t) {
+		t.Error("Expected the staged header to be discarded")
+	}
+	checkEntry(t)
+
+	if err := reopen(t, "new password", "new password", "new password"); err != ErrWrongPassword {
+		t.Errorf("Expected the new password to be refused, but got %v", err)
+	}
+}